@@ -4,18 +4,51 @@ import (
 	"github.com/theinternetftw/cpugo/virt6502"
 
 	"fmt"
+	"io"
 	"os"
 )
 
 type emuState struct {
 	Mem mem
 
+	// CPU holds the registers for whichever core is running, see cpu.go
 	CPU virt6502.Virt6502
+	// CPUVariant is empty in older snapshots, which means CPU6502
+	CPUVariant CPUType
+	CPUHalt    cpuHaltState
+
+	core CPU
+
+	// ACI and the other cards are nil when not installed, see peripheral.go
+	ACI     *aciCard
+	CFFA1   *cffa1Card
+	ACIA    *aciaCard
+	HostSvc *hostSvcCard
+
+	cards         []card
+	cardPages     [256]card
+	cycleWatchers []cycleWatcher
+	irqSources    []irqSource
 
 	Screen [240 * 240 * 4]byte
 
 	Terminal terminal
 
+	// console is nil unless the keyboard and display are bridged to
+	// the host, see console.go
+	console *consoleLink
+
+	// outputWatchers see each char put on the display, see WatchOutput
+	outputWatchers []func(char byte)
+
+	// hostOut is where the host services card prints, see hostOutput
+	hostOut io.Writer
+
+	// theme is the screen's colors, zero for Themes[0], and
+	// themedScreen the screen drawn in them
+	theme        Theme
+	themedScreen []byte
+
 	autokeyInput []byte
 
 	LastKeyState     [256]bool
@@ -27,14 +60,41 @@ type emuState struct {
 	NextKeyToDisplay    byte
 	ReadyToDisplay      bool
 	KeyDisplayRequested bool
+	// DisplayCountdown is the cycles left until the display takes the
+	// requested char. It takes about one a frame, as it has to wait for
+	// the cursor to come round in its shift registers.
+	DisplayCountdown uint64
 
 	DisplayBeenInitted bool
 
+	// CPUClockHz is zero in older snapshots, see clockHz()
+	CPUClockHz int
+
+	// RefreshStealing turns on DRAM refresh cycle stealing, see refresh.go
+	RefreshStealing bool
+	RefreshCounter  uint64
+
 	Cycles       uint64
 	FrameCounter uint64
+
+	frameEnded bool
+
+	idle idleDetector
+}
+
+const defaultClockHz = 14318100 / 14
+const framesPerSecond = 60
+
+func (emu *emuState) clockHz() int {
+	if emu.CPUClockHz == 0 {
+		return defaultClockHz
+	}
+	return emu.CPUClockHz
 }
 
-const clocksPerFrame = 14318100 / 14 / 60
+func (emu *emuState) clocksPerFrame() uint64 {
+	return uint64(emu.clockHz() / framesPerSecond)
+}
 
 func (emu *emuState) flipRequested() bool {
 	result := emu.Terminal.flipRequested
@@ -43,26 +103,81 @@ func (emu *emuState) flipRequested() bool {
 }
 
 func (emu *emuState) framebuffer() []byte {
-	return emu.Terminal.screen
+	return emu.themedFramebuffer()
 }
 
 func (emu *emuState) runCycles(cycles uint) {
+	ticks := uint64(cycles)
+	if emu.RefreshStealing {
+		ticks += emu.stolenRefreshCycles(ticks)
+	}
+	emu.tick(ticks)
+}
+
+// tick advances the clock. Unlike runCycles, these are wall-clock cycles
+// and not cpu cycles, so no refresh stealing is applied.
+func (emu *emuState) tick(ticks uint64) {
 
-	emu.Cycles += uint64(cycles)
+	emu.Cycles += ticks
+	emu.tickCards(ticks)
 
 	// not great timing, probably
-	if emu.KeyDisplayRequested && emu.ReadyToDisplay {
+	if emu.KeyDisplayRequested && emu.DisplayCountdown > ticks {
+		emu.DisplayCountdown -= ticks
+	} else if emu.KeyDisplayRequested {
 		emu.KeyDisplayRequested = false
+		emu.DisplayCountdown = 0
 		emu.Terminal.writeChar(rune(emu.NextKeyToDisplay))
-	}
-	if !emu.Terminal.flipRequested {
+		if emu.console != nil {
+			emu.console.display(emu.NextKeyToDisplay, emu.Terminal.Lowercase)
+		}
+		emu.watchOutput(emu.NextKeyToDisplay)
 		emu.ReadyToDisplay = true
 	}
 
-	emu.FrameCounter += uint64(cycles)
-	if emu.FrameCounter >= clocksPerFrame {
+	emu.FrameCounter += ticks
+	if emu.FrameCounter >= emu.clocksPerFrame() {
 		emu.FrameCounter = 0
 		emu.Terminal.flipRequested = true
+		emu.frameEnded = true
+		emu.endFrameCards()
+	}
+}
+
+// watchOutput passes a char written to the display on to the
+// outputWatchers, as the display shows it
+func (emu *emuState) watchOutput(char byte) {
+	if len(emu.outputWatchers) == 0 {
+		return
+	}
+	switch {
+	case char == '\r' || char == '\n':
+		char = '\r'
+	case char == '\t':
+		char = ' '
+	case char < 32:
+		return
+	default:
+		char = byte(displayChar(rune(char), emu.Terminal.Lowercase))
+	}
+	for _, fn := range emu.outputWatchers {
+		fn(char)
+	}
+}
+
+func (emu *emuState) runFrame(input Input) {
+	emu.updateInput(input)
+	emu.frameEnded = false
+	emu.idle.frameWasIdle = false
+	for !emu.frameEnded {
+		emu.step()
+	}
+}
+
+func (emu *emuState) runForCycles(cycles uint64) {
+	target := emu.Cycles + cycles
+	for emu.Cycles < target {
+		emu.step()
 	}
 }
 
@@ -71,6 +186,20 @@ const (
 	showMemWrites = false
 )
 
+// stepInstruction runs exactly one instruction, never skipping ahead
+// while the cpu idles, for debuggers and tests
+func (emu *emuState) stepInstruction() {
+	emu.updateAutokey()
+	emu.updateConsoleInput()
+
+	if emu.irqAsserted() {
+		emu.core.IRQ()
+	}
+	emu.core.Step()
+}
+
+// step runs one instruction, then skips ahead to the end of the frame
+// if the cpu's just idling, see idle.go
 func (emu *emuState) step() {
 
 	/*
@@ -88,17 +217,35 @@ func (emu *emuState) step() {
 		}
 	*/
 
-	emu.CPU.Step()
-}
+	emu.stepInstruction()
 
-func (emu *emuState) updateInput(input Input) {
+	if emu.workPending() {
+		emu.idle.reset()
+	} else if emu.idle.spinning || emu.CPUHalt.Stopped {
+		emu.skipToFrameEnd()
+	}
+}
 
-	if len(emu.autokeyInput) > 0 {
-		if !emu.NewKeyWasPressed {
-			input.Keys[emu.autokeyInput[0]] = true
-			emu.autokeyInput = emu.autokeyInput[1:]
+// autokey input is fed in here, per-step, rather than in updateInput,
+// so it keeps pace with the monitor no matter how often input is updated.
+func (emu *emuState) updateAutokey() {
+	if len(emu.autokeyInput) > 0 && !emu.NewKeyWasPressed {
+		key := emu.autokeyInput[0]
+		emu.autokeyInput = emu.autokeyInput[1:]
+		if key >= 'a' && key <= 'z' {
+			key -= 'a' - 'A'
+		}
+		if key == 8 {
+			key = 0x5f
+		}
+		if key < 128 {
+			emu.NewKeyInput = key
+			emu.NewKeyWasPressed = true
 		}
 	}
+}
+
+func (emu *emuState) updateInput(input Input) {
 
 	// convert lower to upper case
 	for i := 0; i < 26; i++ {
@@ -147,6 +294,10 @@ func (emu *emuState) updateInput(input Input) {
 		// looking at real apple1 demos, I think
 		// this is the real behavior...
 		emu.Terminal.newline()
+		if emu.console != nil {
+			emu.console.newline()
+		}
+		emu.watchOutput('\r')
 	}
 }
 
@@ -164,13 +315,48 @@ func (emu *emuState) loadBinaryToMem(addr uint16, bin []byte) error {
 func (emu *emuState) reset() {
 	emu.DisplayBeenInitted = false
 	emu.Terminal.clearScreen()
-	emu.CPU.RESET = true
+	emu.core.Reset()
 }
 
-func newStateWithAutokeyInput(input []byte) *emuState {
+func newStateWithOptions(opts Options) (*emuState, error) {
 	emu := newState()
-	emu.autokeyInput = input
-	return emu
+	emu.autokeyInput = opts.AutokeyInput
+	if opts.ClockHz > 0 {
+		emu.CPUClockHz = opts.ClockHz
+	}
+	emu.RefreshStealing = opts.RefreshStealing
+	emu.Terminal.Lowercase = opts.LowercaseMod
+	if opts.Font != nil {
+		emu.Terminal.font = opts.Font.font
+	}
+	if opts.CPU != "" {
+		emu.CPUVariant = opts.CPU
+		if err := emu.initCPU(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.RAM != nil {
+		if err := emu.setRAMLayout(opts.RAM); err != nil {
+			return nil, err
+		}
+	}
+	emu.hostOut = opts.HostOutput
+	for _, p := range opts.Peripherals {
+		if err := emu.installPeripheral(p); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MonitorROM != nil {
+		if err := emu.loadMonitorROM(opts.MonitorROM); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Console != "" {
+		if err := emu.openConsole(opts.Console); err != nil {
+			return nil, err
+		}
+	}
+	return emu, nil
 }
 
 func makeTerminal(emu *emuState) terminal {
@@ -181,29 +367,49 @@ func makeTerminal(emu *emuState) terminal {
 		font:   a1Font5x7,
 	}
 }
-func unpackTerminalFromSnap(emu *emuState) {
+
+// unpackTerminalFromSnap sets up a terminal just loaded from a
+// snapshot. Its font and scrollback are the host's, not the machine's,
+// so aren't in snapshots: they carry over from host, the emulator the
+// snapshot was loaded into.
+func unpackTerminalFromSnap(emu, host *emuState) {
 	emu.Terminal.flipRequested = true
 	emu.Terminal.screen = emu.Screen[:]
-	emu.Terminal.font = a1Font5x7
+	emu.Terminal.font = host.Terminal.font
+	emu.Terminal.history = host.Terminal.history
 }
 
 func newState() *emuState {
 	emu := emuState{
 		Mem:            mem{},
 		ReadyToDisplay: true,
+		CPUClockHz:     defaultClockHz,
 	}
 	emu.CPU = virt6502.Virt6502{
-		RESET:     true,
-		RunCycles: emu.runCycles,
-		Write:     emu.write,
-		Read:      emu.read,
-		Err:       func(e error) { emuErr(e) },
+		RESET: true,
+	}
+	if err := emu.initCPU(); err != nil {
+		panic(err) // the default cpu type always works
 	}
 	emu.Terminal = makeTerminal(&emu)
 
 	return &emu
 }
 
+// initCPU hooks emu.CPU up to the bus and builds the core for emu.CPUVariant
+func (emu *emuState) initCPU() error {
+	emu.CPU.RunCycles = emu.runCycles
+	emu.CPU.Write = emu.write
+	emu.CPU.Read = emu.read
+	emu.CPU.Err = func(e error) { emuErr(e) }
+	core, err := newCPUCore(emu.CPUVariant, &emu.CPU, &emu.CPUHalt, emu.peek)
+	if err != nil {
+		return err
+	}
+	emu.core = core
+	return nil
+}
+
 func emuErr(args ...interface{}) {
 	fmt.Println(args...)
 	os.Exit(1)
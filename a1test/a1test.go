@@ -0,0 +1,290 @@
+// Package a1test helps test Apple-1 programs with go test, using a1go
+// as the execution engine. A Machine wraps an emulator for one test,
+// and when something goes wrong, it fails the test with a dump of the
+// screen, the registers, and the last instructions run.
+package a1test
+
+import (
+	"github.com/theinternetftw/a1go"
+
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TraceLen is how many instructions a Machine remembers for failure messages
+const TraceLen = 32
+
+// bootCycles is enough for the Woz Monitor to come up and wait for keys
+const bootCycles = 100000
+
+// Machine is an emulator set up for a test
+type Machine struct {
+	T   testing.TB
+	Emu a1go.Emulator
+
+	cmos bool
+
+	// output is what's been displayed since the last RunUntilText matched
+	output []byte
+
+	trace     [TraceLen]traceEntry
+	traceNext int
+	traceLen  int
+}
+
+type traceEntry struct {
+	regs a1go.Registers
+	code [3]byte
+	len  int
+}
+
+// New makes a Machine with a stock a1go emulator: every RAM socket
+// filled and the Woz Monitor, booted and waiting for keys.
+func New(t testing.TB) *Machine {
+	t.Helper()
+	return NewWithOptions(t, a1go.Options{})
+}
+
+// NewWithOptions makes a Machine with an emulator configured by opts,
+// booted and waiting for keys
+func NewWithOptions(t testing.TB, opts a1go.Options) *Machine {
+	t.Helper()
+	emu, err := a1go.NewEmulatorWithOptions(opts)
+	if err != nil {
+		t.Fatalf("a1test: could not make emulator: %v", err)
+	}
+	m := &Machine{T: t, Emu: emu, cmos: emu.CPUType() == a1go.CPU65C02}
+	emu.WatchOutput(func(char byte) {
+		m.output = append(m.output, char)
+	})
+	emu.RunCycles(bootCycles)
+	return m
+}
+
+// LoadROM maps in a ROM image at addr
+func (m *Machine) LoadROM(addr uint16, rom []byte) {
+	m.T.Helper()
+	if err := m.Emu.LoadROM(addr, rom); err != nil {
+		m.T.Fatalf("a1test: LoadROM: %v", err)
+	}
+}
+
+// LoadROMSet loads a named set of ROMs, e.g. "basic", from the usual
+// ROM search path
+func (m *Machine) LoadROMSet(name string) {
+	m.T.Helper()
+	manager, err := a1go.NewROMManager()
+	if err == nil {
+		_, err = manager.LoadSet(m.Emu, name)
+	}
+	if err != nil {
+		m.T.Fatalf("a1test: LoadROMSet: %v", err)
+	}
+}
+
+// LoadBinary puts bin in memory at addr
+func (m *Machine) LoadBinary(addr uint16, bin []byte) {
+	m.T.Helper()
+	if err := m.Emu.LoadBinaryToMem(addr, bin); err != nil {
+		m.T.Fatalf("a1test: LoadBinary: %v", err)
+	}
+}
+
+// LoadFile puts the contents of a file in memory at addr
+func (m *Machine) LoadFile(path string, addr uint16) {
+	m.T.Helper()
+	bin, err := ioutil.ReadFile(path)
+	if err != nil {
+		m.T.Fatalf("a1test: LoadFile: %v", err)
+	}
+	m.LoadBinary(addr, bin)
+}
+
+// Type queues text to be typed in, as the keyboard would send it.
+// It's typed as the program reads keys, while running.
+func (m *Machine) Type(s string) {
+	m.Emu.Type([]byte(s))
+}
+
+// Screen returns what's on screen, as 24 lines of up to 40 chars
+func (m *Machine) Screen() string {
+	return m.Emu.ScreenText()
+}
+
+// Mem reads a byte without side effects
+func (m *Machine) Mem(addr uint16) byte {
+	return m.Emu.ReadMem(addr)
+}
+
+// MemRange reads n bytes from addr without side effects
+func (m *Machine) MemRange(addr uint16, n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = m.Emu.ReadMem(addr + uint16(i))
+	}
+	return data
+}
+
+// SetMem writes bytes starting at addr, as the cpu would
+func (m *Machine) SetMem(addr uint16, data ...byte) {
+	for i, b := range data {
+		m.Emu.WriteMem(addr+uint16(i), b)
+	}
+}
+
+// Registers returns the cpu's registers
+func (m *Machine) Registers() a1go.Registers {
+	return m.Emu.Registers()
+}
+
+// SetRegisters sets the cpu's registers
+func (m *Machine) SetRegisters(r a1go.Registers) {
+	m.Emu.SetRegisters(r)
+}
+
+// step runs one instruction, tracing it
+func (m *Machine) step() {
+	regs := m.Emu.Registers()
+	entry := traceEntry{regs: regs}
+	entry.code[0] = m.Emu.ReadMem(regs.PC)
+	entry.len = instLen(entry.code[0], m.cmos)
+	for i := 1; i < entry.len; i++ {
+		entry.code[i] = m.Emu.ReadMem(regs.PC + uint16(i))
+	}
+	m.trace[m.traceNext] = entry
+	m.traceNext = (m.traceNext + 1) % TraceLen
+	if m.traceLen < TraceLen {
+		m.traceLen++
+	}
+	m.Emu.Step()
+}
+
+// runUntil steps until done returns true, failing the test with msg
+// if that takes more than maxCycles
+func (m *Machine) runUntil(maxCycles uint64, done func() bool, msg string) {
+	m.T.Helper()
+	start := m.Emu.CycleCount()
+	for !done() {
+		if code, exited := m.Emu.ExitRequested(); exited {
+			m.Fatalf("%v: program exited with status %v", msg, code)
+		}
+		if m.Emu.CycleCount()-start >= maxCycles {
+			m.Fatalf("%v: gave up after %v cycles", msg, maxCycles)
+		}
+		m.step()
+	}
+}
+
+// Run runs for at least the given number of cycles
+func (m *Machine) Run(cycles uint64) {
+	target := m.Emu.CycleCount() + cycles
+	for m.Emu.CycleCount() < target {
+		m.step()
+	}
+}
+
+// RunUntilText runs until s is printed, failing the test if that
+// takes more than maxCycles. Only output since the last match
+// counts, and newlines are printed as '\r', though "\n" matches too.
+func (m *Machine) RunUntilText(s string, maxCycles uint64) {
+	m.T.Helper()
+	want := strings.Replace(s, "\n", "\r", -1)
+	m.runUntil(maxCycles, func() bool {
+		if i := strings.Index(string(m.output), want); i >= 0 {
+			m.output = m.output[i+len(want):]
+			return true
+		}
+		return false
+	}, fmt.Sprintf("RunUntilText(%q)", s))
+}
+
+// RunUntilPC runs until the cpu is about to run the instruction at
+// addr, failing the test if that takes more than maxCycles
+func (m *Machine) RunUntilPC(addr uint16, maxCycles uint64) {
+	m.T.Helper()
+	m.runUntil(maxCycles, func() bool {
+		return m.Emu.Registers().PC == addr
+	}, fmt.Sprintf("RunUntilPC($%04X)", addr))
+}
+
+// RunUntilExit runs until the program exits through the host services
+// device (see a1go's hostsvc peripheral), and returns the exit status
+func (m *Machine) RunUntilExit(maxCycles uint64) int {
+	m.T.Helper()
+	start := m.Emu.CycleCount()
+	for {
+		if code, exited := m.Emu.ExitRequested(); exited {
+			return code
+		}
+		if m.Emu.CycleCount()-start >= maxCycles {
+			m.Fatalf("RunUntilExit: gave up after %v cycles", maxCycles)
+		}
+		m.step()
+	}
+}
+
+// Call calls the subroutine at addr, as if by JSR, with the given A,
+// X and Y, and runs until it returns. It returns the registers then,
+// and the interrupted program carries on afterwards.
+func (m *Machine) Call(addr uint16, a, x, y byte, maxCycles uint64) a1go.Registers {
+	m.T.Helper()
+	saved := m.Emu.Registers()
+	// push the return address, less one, like JSR does
+	ret := saved.PC - 1
+	m.Emu.WriteMem(0x100+uint16(saved.S), byte(ret>>8))
+	m.Emu.WriteMem(0x100+uint16(saved.S-1), byte(ret))
+	regs := saved
+	regs.PC, regs.A, regs.X, regs.Y, regs.S = addr, a, x, y, saved.S-2
+	m.Emu.SetRegisters(regs)
+	m.runUntil(maxCycles, func() bool {
+		regs := m.Emu.Registers()
+		return regs.PC == saved.PC && regs.S == saved.S
+	}, fmt.Sprintf("Call($%04X)", addr))
+	result := m.Emu.Registers()
+	m.Emu.SetRegisters(saved)
+	return result
+}
+
+// Fatalf fails the test with a message and a dump of the machine
+func (m *Machine) Fatalf(format string, args ...interface{}) {
+	m.T.Helper()
+	m.T.Fatalf("%v\n%v", fmt.Sprintf(format, args...), m.Dump())
+}
+
+// Errorf marks the test failed, with a message and a dump of the machine
+func (m *Machine) Errorf(format string, args ...interface{}) {
+	m.T.Helper()
+	m.T.Errorf("%v\n%v", fmt.Sprintf(format, args...), m.Dump())
+}
+
+// Dump describes the machine's state: the screen, the registers,
+// and the last instructions run
+func (m *Machine) Dump() string {
+	var sb strings.Builder
+	sb.WriteString("screen:\n")
+	for _, line := range strings.Split(strings.TrimRight(m.Screen(), "\n"), "\n") {
+		fmt.Fprintf(&sb, "  |%v\n", line)
+	}
+	fmt.Fprintf(&sb, "registers: %v\n", m.Emu.Registers())
+	fmt.Fprintf(&sb, "last %v instructions:\n", m.traceLen)
+	sb.WriteString(m.Trace())
+	return sb.String()
+}
+
+// Trace returns the last instructions run, oldest first, each with
+// the registers from just before it ran
+func (m *Machine) Trace() string {
+	var sb strings.Builder
+	for i := 0; i < m.traceLen; i++ {
+		entry := m.trace[(m.traceNext-m.traceLen+i+TraceLen)%TraceLen]
+		code := entry.code[:entry.len]
+		hex := []string{}
+		for _, b := range code {
+			hex = append(hex, fmt.Sprintf("%02X", b))
+		}
+		fmt.Fprintf(&sb, "  %04X  %-8v  %-16v  %v\n", entry.regs.PC, strings.Join(hex, " "), disasm(entry.regs.PC, code, m.cmos), entry.regs)
+	}
+	return sb.String()
+}
@@ -0,0 +1,221 @@
+package a1test
+
+import (
+	"github.com/theinternetftw/a1go"
+
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDisasm(t *testing.T) {
+	tests := []struct {
+		pc   uint16
+		code []byte
+		cmos bool
+		want string
+	}{
+		{0x0300, []byte{0xea}, false, "NOP"},
+		{0x0300, []byte{0x0a}, false, "ASL A"},
+		{0x0300, []byte{0xa9, 0x8d}, false, "LDA #$8D"},
+		{0x0300, []byte{0xa5, 0x24}, false, "LDA $24"},
+		{0x0300, []byte{0xb5, 0x24}, false, "LDA $24,X"},
+		{0x0300, []byte{0xb6, 0x24}, false, "LDX $24,Y"},
+		{0x0300, []byte{0xad, 0x11, 0xd0}, false, "LDA $D011"},
+		{0x0300, []byte{0xbd, 0x00, 0x02}, false, "LDA $0200,X"},
+		{0x0300, []byte{0xb9, 0x00, 0x02}, false, "LDA $0200,Y"},
+		{0x0300, []byte{0x6c, 0xfc, 0xff}, false, "JMP ($FFFC)"},
+		{0x0300, []byte{0xa1, 0x24}, false, "LDA ($24,X)"},
+		{0x0300, []byte{0xb1, 0x24}, false, "LDA ($24),Y"},
+		{0x0300, []byte{0xd0, 0x02}, false, "BNE $0304"},
+		{0x0300, []byte{0x10, 0xfb}, false, "BPL $02FD"},
+		{0x0300, []byte{0xb2, 0x24}, true, "LDA ($24)"},
+		{0x0300, []byte{0x7c, 0x00, 0x02}, true, "JMP ($0200,X)"},
+		{0x0300, []byte{0x80, 0x10}, true, "BRA $0312"},
+		{0x0300, []byte{0x1a}, true, "INC A"},
+		{0x0300, []byte{0xf7, 0x10}, true, "SMB7 $10"},
+		{0x0300, []byte{0x0f, 0x10, 0xfd}, true, "BBR0 $10,$0300"},
+		// 65C02 opcodes aren't 6502 ones
+		{0x0300, []byte{0xb2, 0x24}, false, ".BYTE $B2"},
+		{0x0300, []byte{0x80, 0x10}, false, ".BYTE $80"},
+		// nor is an instruction that's cut short
+		{0x0300, []byte{0xad, 0x11}, false, ".BYTE $AD"},
+	}
+	for _, test := range tests {
+		if got := disasm(test.pc, test.code, test.cmos); got != test.want {
+			t.Errorf("disasm(% X, cmos %v) = %q, want %q", test.code, test.cmos, got, test.want)
+		}
+	}
+}
+
+func TestInstLen(t *testing.T) {
+	tests := []struct {
+		op   byte
+		cmos bool
+		want int
+	}{
+		{0xea, false, 1},
+		{0xa9, false, 2},
+		{0xad, false, 3},
+		{0xb2, false, 1},
+		{0xb2, true, 2},
+		{0x9c, true, 3},
+		{0x0f, true, 3},
+	}
+	for _, test := range tests {
+		if got := instLen(test.op, test.cmos); got != test.want {
+			t.Errorf("instLen(%02X, cmos %v) = %v, want %v", test.op, test.cmos, got, test.want)
+		}
+	}
+}
+
+func TestMachineMem(t *testing.T) {
+	m := New(t)
+	m.SetMem(0x0300, 1, 2, 3)
+	m.LoadBinary(0x0303, []byte{4, 5})
+	if got := m.MemRange(0x0300, 5); string(got) != "\x01\x02\x03\x04\x05" {
+		t.Errorf("MemRange is % x, want 01 02 03 04 05", got)
+	}
+	if got := m.Mem(0xff00); got != 0xd8 {
+		t.Errorf("Woz Monitor starts with %02x, want d8", got)
+	}
+}
+
+func TestMachineCall(t *testing.T) {
+	m := New(t)
+	// CLC, ADC #$01, TAX, RTS
+	m.LoadBinary(0x0300, []byte{0x18, 0x69, 0x01, 0xaa, 0x60})
+	before := m.Registers()
+	regs := m.Call(0x0300, 0x41, 0, 0, 1000)
+	if regs.A != 0x42 || regs.X != 0x42 {
+		t.Errorf("Call returned %v, want A and X 42", regs)
+	}
+	if after := m.Registers(); after != before {
+		t.Errorf("registers after Call are %v, want them back to %v", after, before)
+	}
+	trace := m.Trace()
+	for _, want := range []string{"0300  18        CLC", "0301  69 01     ADC #$01", "0304  60        RTS"} {
+		if !strings.Contains(trace, want) {
+			t.Errorf("trace is missing %q:\n%v", want, trace)
+		}
+	}
+}
+
+func TestMachineRunUntil(t *testing.T) {
+	m := New(t)
+	m.Type("FF00.FF02\r")
+	m.RunUntilText("FF00: D8 58 A0\n", 2000000)
+	if !strings.Contains(m.Screen(), "FF00: D8 58 A0") {
+		t.Errorf("screen is missing the dump:\n%v", m.Screen())
+	}
+
+	// JMP $0300 spins in place
+	m.LoadBinary(0x0300, []byte{0x4c, 0x00, 0x03})
+	m.Type("300R\r")
+	m.RunUntilPC(0x0300, 2000000)
+	start := m.Emu.CycleCount()
+	m.Run(100)
+	if ran := m.Emu.CycleCount() - start; ran < 100 {
+		t.Errorf("Run(100) ran %v cycles", ran)
+	}
+}
+
+func TestMachineStepDoesntSkip(t *testing.T) {
+	m := New(t)
+	// the monitor's sitting in its key poll loop by now, which
+	// RunFrame would skip to the end of the frame
+	m.Run(100000)
+	for i := 0; i < 10; i++ {
+		start := m.Emu.CycleCount()
+		m.Emu.Step()
+		if ran := m.Emu.CycleCount() - start; ran > 7 {
+			t.Fatalf("Step ran %v cycles at $%04X, want one instruction's worth", ran, m.Registers().PC)
+		}
+	}
+}
+
+func TestMachineRunUntilExit(t *testing.T) {
+	out := bytes.Buffer{}
+	m := NewWithOptions(t, a1go.Options{
+		Peripherals: []a1go.PeripheralConfig{{Type: "hostsvc"}},
+		HostOutput:  &out,
+	})
+	// LDA #'H', STA $C30A (putc), LDA #CR, STA $C30A,
+	// LDA #3, STA $C307 (the status), LDA #7, STA $C300 (exit)
+	m.LoadBinary(0x0300, []byte{
+		0xa9, 0xc8, 0x8d, 0x0a, 0xc3, 0xa9, 0x8d, 0x8d, 0x0a, 0xc3,
+		0xa9, 0x03, 0x8d, 0x07, 0xc3, 0xa9, 0x07, 0x8d, 0x00, 0xc3,
+	})
+	regs := m.Registers()
+	regs.PC = 0x0300
+	m.SetRegisters(regs)
+	if code := m.RunUntilExit(1000); code != 3 {
+		t.Errorf("exit status is %v, want 3", code)
+	}
+	// the CR comes out as a newline, so line-at-a-time output sees it
+	if out.String() != "H\n" {
+		t.Errorf("printed %q, want \"H\\n\"", out.String())
+	}
+}
+
+// fatalTB is a testing.TB that records Fatalf instead of failing
+type fatalTB struct {
+	testing.TB
+	msg string
+}
+
+type fatalled struct{}
+
+func (f *fatalTB) Fatalf(format string, args ...interface{}) {
+	f.msg = strings.TrimSpace(strings.SplitN(fmt.Sprintf(format, args...), "\n", 2)[0])
+	panic(fatalled{})
+}
+
+// fatalMessage runs fn, and returns the first line of what it
+// passed to Fatalf, if it did
+func fatalMessage(f *fatalTB, fn func()) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(fatalled); !ok {
+				panic(r)
+			}
+			msg = f.msg
+		}
+	}()
+	fn()
+	return ""
+}
+
+func TestMachineFailures(t *testing.T) {
+	tb := &fatalTB{TB: t}
+	m := New(tb)
+	// JMP $0300 spins in place
+	m.LoadBinary(0x0300, []byte{0x4c, 0x00, 0x03})
+	regs := m.Registers()
+	regs.PC = 0x0300
+	m.SetRegisters(regs)
+
+	tests := []struct {
+		name string
+		fn   func()
+		want string
+	}{
+		{"RunUntilText", func() { m.RunUntilText("NEVER", 1000) }, `RunUntilText("NEVER"): gave up after 1000 cycles`},
+		{"RunUntilPC", func() { m.RunUntilPC(0x0400, 1000) }, `RunUntilPC($0400): gave up after 1000 cycles`},
+		{"RunUntilExit", func() { m.RunUntilExit(1000) }, `RunUntilExit: gave up after 1000 cycles`},
+		{"Call", func() { m.Call(0x0300, 0, 0, 0, 1000) }, `Call($0300): gave up after 1000 cycles`},
+	}
+	for _, test := range tests {
+		if got := fatalMessage(tb, test.fn); got != test.want {
+			t.Errorf("%v failed with %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	dump := m.Dump()
+	for _, want := range []string{"screen:\n", "registers: PC:0300", "last 32 instructions:\n", "0300  4C 00 03  JMP $0300"} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump is missing %q:\n%v", want, dump)
+		}
+	}
+}
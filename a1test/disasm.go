@@ -0,0 +1,166 @@
+package a1test
+
+import "fmt"
+
+// Just enough of a disassembler to make traces readable
+
+type addrMode int
+
+const (
+	modeImp addrMode = iota
+	modeAcc
+	modeImm
+	modeZP
+	modeZPX
+	modeZPY
+	modeAbs
+	modeAbsX
+	modeAbsY
+	modeInd
+	modeIndX
+	modeIndY
+	modeRel
+	// 65C02 only
+	modeZPInd
+	modeAbsXInd
+	modeZPRel
+)
+
+var modeLens = map[addrMode]int{
+	modeImp: 1, modeAcc: 1,
+	modeImm: 2, modeZP: 2, modeZPX: 2, modeZPY: 2, modeIndX: 2, modeIndY: 2, modeRel: 2, modeZPInd: 2,
+	modeAbs: 3, modeAbsX: 3, modeAbsY: 3, modeInd: 3, modeAbsXInd: 3, modeZPRel: 3,
+}
+
+type opcode struct {
+	name string
+	mode addrMode
+}
+
+var opcodes6502 = map[byte]opcode{
+	0x00: {"BRK", modeImp}, 0x01: {"ORA", modeIndX}, 0x05: {"ORA", modeZP}, 0x06: {"ASL", modeZP},
+	0x08: {"PHP", modeImp}, 0x09: {"ORA", modeImm}, 0x0a: {"ASL", modeAcc}, 0x0d: {"ORA", modeAbs},
+	0x0e: {"ASL", modeAbs}, 0x10: {"BPL", modeRel}, 0x11: {"ORA", modeIndY}, 0x15: {"ORA", modeZPX},
+	0x16: {"ASL", modeZPX}, 0x18: {"CLC", modeImp}, 0x19: {"ORA", modeAbsY}, 0x1d: {"ORA", modeAbsX},
+	0x1e: {"ASL", modeAbsX}, 0x20: {"JSR", modeAbs}, 0x21: {"AND", modeIndX}, 0x24: {"BIT", modeZP},
+	0x25: {"AND", modeZP}, 0x26: {"ROL", modeZP}, 0x28: {"PLP", modeImp}, 0x29: {"AND", modeImm},
+	0x2a: {"ROL", modeAcc}, 0x2c: {"BIT", modeAbs}, 0x2d: {"AND", modeAbs}, 0x2e: {"ROL", modeAbs},
+	0x30: {"BMI", modeRel}, 0x31: {"AND", modeIndY}, 0x35: {"AND", modeZPX}, 0x36: {"ROL", modeZPX},
+	0x38: {"SEC", modeImp}, 0x39: {"AND", modeAbsY}, 0x3d: {"AND", modeAbsX}, 0x3e: {"ROL", modeAbsX},
+	0x40: {"RTI", modeImp}, 0x41: {"EOR", modeIndX}, 0x45: {"EOR", modeZP}, 0x46: {"LSR", modeZP},
+	0x48: {"PHA", modeImp}, 0x49: {"EOR", modeImm}, 0x4a: {"LSR", modeAcc}, 0x4c: {"JMP", modeAbs},
+	0x4d: {"EOR", modeAbs}, 0x4e: {"LSR", modeAbs}, 0x50: {"BVC", modeRel}, 0x51: {"EOR", modeIndY},
+	0x55: {"EOR", modeZPX}, 0x56: {"LSR", modeZPX}, 0x58: {"CLI", modeImp}, 0x59: {"EOR", modeAbsY},
+	0x5d: {"EOR", modeAbsX}, 0x5e: {"LSR", modeAbsX}, 0x60: {"RTS", modeImp}, 0x61: {"ADC", modeIndX},
+	0x65: {"ADC", modeZP}, 0x66: {"ROR", modeZP}, 0x68: {"PLA", modeImp}, 0x69: {"ADC", modeImm},
+	0x6a: {"ROR", modeAcc}, 0x6c: {"JMP", modeInd}, 0x6d: {"ADC", modeAbs}, 0x6e: {"ROR", modeAbs},
+	0x70: {"BVS", modeRel}, 0x71: {"ADC", modeIndY}, 0x75: {"ADC", modeZPX}, 0x76: {"ROR", modeZPX},
+	0x78: {"SEI", modeImp}, 0x79: {"ADC", modeAbsY}, 0x7d: {"ADC", modeAbsX}, 0x7e: {"ROR", modeAbsX},
+	0x81: {"STA", modeIndX}, 0x84: {"STY", modeZP}, 0x85: {"STA", modeZP}, 0x86: {"STX", modeZP},
+	0x88: {"DEY", modeImp}, 0x8a: {"TXA", modeImp}, 0x8c: {"STY", modeAbs}, 0x8d: {"STA", modeAbs},
+	0x8e: {"STX", modeAbs}, 0x90: {"BCC", modeRel}, 0x91: {"STA", modeIndY}, 0x94: {"STY", modeZPX},
+	0x95: {"STA", modeZPX}, 0x96: {"STX", modeZPY}, 0x98: {"TYA", modeImp}, 0x99: {"STA", modeAbsY},
+	0x9a: {"TXS", modeImp}, 0x9d: {"STA", modeAbsX}, 0xa0: {"LDY", modeImm}, 0xa1: {"LDA", modeIndX},
+	0xa2: {"LDX", modeImm}, 0xa4: {"LDY", modeZP}, 0xa5: {"LDA", modeZP}, 0xa6: {"LDX", modeZP},
+	0xa8: {"TAY", modeImp}, 0xa9: {"LDA", modeImm}, 0xaa: {"TAX", modeImp}, 0xac: {"LDY", modeAbs},
+	0xad: {"LDA", modeAbs}, 0xae: {"LDX", modeAbs}, 0xb0: {"BCS", modeRel}, 0xb1: {"LDA", modeIndY},
+	0xb4: {"LDY", modeZPX}, 0xb5: {"LDA", modeZPX}, 0xb6: {"LDX", modeZPY}, 0xb8: {"CLV", modeImp},
+	0xb9: {"LDA", modeAbsY}, 0xba: {"TSX", modeImp}, 0xbc: {"LDY", modeAbsX}, 0xbd: {"LDA", modeAbsX},
+	0xbe: {"LDX", modeAbsY}, 0xc0: {"CPY", modeImm}, 0xc1: {"CMP", modeIndX}, 0xc4: {"CPY", modeZP},
+	0xc5: {"CMP", modeZP}, 0xc6: {"DEC", modeZP}, 0xc8: {"INY", modeImp}, 0xc9: {"CMP", modeImm},
+	0xca: {"DEX", modeImp}, 0xcc: {"CPY", modeAbs}, 0xcd: {"CMP", modeAbs}, 0xce: {"DEC", modeAbs},
+	0xd0: {"BNE", modeRel}, 0xd1: {"CMP", modeIndY}, 0xd5: {"CMP", modeZPX}, 0xd6: {"DEC", modeZPX},
+	0xd8: {"CLD", modeImp}, 0xd9: {"CMP", modeAbsY}, 0xdd: {"CMP", modeAbsX}, 0xde: {"DEC", modeAbsX},
+	0xe0: {"CPX", modeImm}, 0xe1: {"SBC", modeIndX}, 0xe4: {"CPX", modeZP}, 0xe5: {"SBC", modeZP},
+	0xe6: {"INC", modeZP}, 0xe8: {"INX", modeImp}, 0xe9: {"SBC", modeImm}, 0xea: {"NOP", modeImp},
+	0xec: {"CPX", modeAbs}, 0xed: {"SBC", modeAbs}, 0xee: {"INC", modeAbs}, 0xf0: {"BEQ", modeRel},
+	0xf1: {"SBC", modeIndY}, 0xf5: {"SBC", modeZPX}, 0xf6: {"INC", modeZPX}, 0xf8: {"SED", modeImp},
+	0xf9: {"SBC", modeAbsY}, 0xfd: {"SBC", modeAbsX}, 0xfe: {"INC", modeAbsX},
+}
+
+// opcodes65C02 are the 65C02's additions, bit ops included
+var opcodes65C02 = map[byte]opcode{
+	0x04: {"TSB", modeZP}, 0x0c: {"TSB", modeAbs}, 0x12: {"ORA", modeZPInd}, 0x14: {"TRB", modeZP},
+	0x1a: {"INC", modeAcc}, 0x1c: {"TRB", modeAbs}, 0x32: {"AND", modeZPInd}, 0x34: {"BIT", modeZPX},
+	0x3a: {"DEC", modeAcc}, 0x3c: {"BIT", modeAbsX}, 0x52: {"EOR", modeZPInd}, 0x5a: {"PHY", modeImp},
+	0x64: {"STZ", modeZP}, 0x72: {"ADC", modeZPInd}, 0x74: {"STZ", modeZPX}, 0x7a: {"PLY", modeImp},
+	0x7c: {"JMP", modeAbsXInd}, 0x80: {"BRA", modeRel}, 0x89: {"BIT", modeImm}, 0x92: {"STA", modeZPInd},
+	0x9c: {"STZ", modeAbs}, 0x9e: {"STZ", modeAbsX}, 0xb2: {"LDA", modeZPInd}, 0xcb: {"WAI", modeImp},
+	0xd2: {"CMP", modeZPInd}, 0xda: {"PHX", modeImp}, 0xdb: {"STP", modeImp}, 0xf2: {"SBC", modeZPInd},
+	0xfa: {"PLX", modeImp},
+}
+
+func init() {
+	for i := byte(0); i < 8; i++ {
+		opcodes65C02[0x07+i<<4] = opcode{fmt.Sprint("RMB", i), modeZP}
+		opcodes65C02[0x87+i<<4] = opcode{fmt.Sprint("SMB", i), modeZP}
+		opcodes65C02[0x0f+i<<4] = opcode{fmt.Sprint("BBR", i), modeZPRel}
+		opcodes65C02[0x8f+i<<4] = opcode{fmt.Sprint("BBS", i), modeZPRel}
+	}
+}
+
+func lookupOpcode(op byte, cmos bool) (opcode, bool) {
+	if cmos {
+		if o, ok := opcodes65C02[op]; ok {
+			return o, true
+		}
+	}
+	o, ok := opcodes6502[op]
+	return o, ok
+}
+
+// instLen is how many bytes the instruction starting with op takes up
+func instLen(op byte, cmos bool) int {
+	if o, ok := lookupOpcode(op, cmos); ok {
+		return modeLens[o.mode]
+	}
+	return 1
+}
+
+// disasm returns the instruction in code, which starts at pc
+func disasm(pc uint16, code []byte, cmos bool) string {
+	o, ok := lookupOpcode(code[0], cmos)
+	if !ok || len(code) < modeLens[o.mode] {
+		return fmt.Sprintf(".BYTE $%02X", code[0])
+	}
+	var b1, w uint16
+	if len(code) > 1 {
+		b1 = uint16(code[1])
+	}
+	if len(code) > 2 {
+		w = b1 | uint16(code[2])<<8
+	}
+	switch o.mode {
+	case modeAcc:
+		return o.name + " A"
+	case modeImm:
+		return fmt.Sprintf("%v #$%02X", o.name, b1)
+	case modeZP:
+		return fmt.Sprintf("%v $%02X", o.name, b1)
+	case modeZPX:
+		return fmt.Sprintf("%v $%02X,X", o.name, b1)
+	case modeZPY:
+		return fmt.Sprintf("%v $%02X,Y", o.name, b1)
+	case modeAbs:
+		return fmt.Sprintf("%v $%04X", o.name, w)
+	case modeAbsX:
+		return fmt.Sprintf("%v $%04X,X", o.name, w)
+	case modeAbsY:
+		return fmt.Sprintf("%v $%04X,Y", o.name, w)
+	case modeInd:
+		return fmt.Sprintf("%v ($%04X)", o.name, w)
+	case modeIndX:
+		return fmt.Sprintf("%v ($%02X,X)", o.name, b1)
+	case modeIndY:
+		return fmt.Sprintf("%v ($%02X),Y", o.name, b1)
+	case modeRel:
+		return fmt.Sprintf("%v $%04X", o.name, pc+2+uint16(int8(code[1])))
+	case modeZPInd:
+		return fmt.Sprintf("%v ($%02X)", o.name, b1)
+	case modeAbsXInd:
+		return fmt.Sprintf("%v ($%04X,X)", o.name, w)
+	case modeZPRel:
+		return fmt.Sprintf("%v $%02X,$%04X", o.name, b1, pc+3+uint16(int8(code[2])))
+	}
+	return o.name
+}
@@ -0,0 +1,36 @@
+package a1go
+
+// aciCard is the Apple Cassette Interface's I/O page. Its firmware is
+// just a rom at $C100, loaded like any other. The I/O page is the same
+// PROM again, except any access flips the tape output, and a read's A0
+// is replaced by the tape input. No tape is attached yet, so the input
+// sits still and reads never see an edge.
+type aciCard struct {
+	TapeOut bool
+	TapeIn  bool
+}
+
+func installACI(emu *emuState, args map[string]string) error {
+	emu.ACI = &aciCard{}
+	return nil
+}
+
+func (a *aciCard) addrRange() AddrRange {
+	return AddrRange{0xc000, 0xc0ff}
+}
+
+func (a *aciCard) read(emu *emuState, addr uint16) byte {
+	a.TapeOut = !a.TapeOut
+	romAddr := 0xc100 | addr&0xfe
+	if a.TapeIn {
+		romAddr |= 1
+	}
+	if val, ok := emu.Mem.romByte(romAddr); ok {
+		return val
+	}
+	return 0xff
+}
+
+func (a *aciCard) write(emu *emuState, addr uint16, val byte) {
+	a.TapeOut = !a.TapeOut
+}
@@ -0,0 +1,226 @@
+package a1go
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The 6850 ACIA has just two registers, picked by A0: control/status
+// and transmit/receive data. The card answers for a whole page, so the
+// pair repeats all through it.
+const (
+	aciaStatusRDRF = 0x01
+	aciaStatusTDRE = 0x02
+	aciaStatusOVRN = 0x20
+	aciaStatusIRQ  = 0x80
+
+	aciaCtrlDivideMask  = 0x03
+	aciaCtrlMasterReset = 0x03
+	aciaCtrlWordShift   = 2
+	aciaCtrlTxShift     = 5
+	aciaCtrlTxIRQ       = 1
+	aciaCtrlRxIRQ       = 0x80
+
+	aciaDefaultAddr = 0xc200
+	aciaDefaultBaud = 9600
+)
+
+// aciaFrameBits is start + data + parity + stop bits for each word select
+var aciaFrameBits = [8]int{11, 11, 10, 10, 11, 10, 11, 11}
+
+// aciaCard is a 6850 ACIA serial card, with its serial port bridged to a
+// host link (see hostlink.go). Received bytes wait in the host link until
+// the cpu has read the last one, so nothing is lost to overruns.
+type aciaCard struct {
+	Addr uint16
+	Link string
+	// Baud is the rate at the usual divide-by-16 setting
+	Baud int
+	// IRQ wires the card to the cpu's IRQ line
+	IRQ bool
+
+	Control byte
+	Status  byte
+	RDR     byte
+	TDR     byte
+	Shifter byte
+
+	ShifterBusy bool
+	TxCountdown int64
+	RxCountdown int64
+
+	link *hostLink
+}
+
+func installACIA(emu *emuState, args map[string]string) error {
+	a := &aciaCard{
+		Addr: aciaDefaultAddr,
+		Link: args["link"],
+		Baud: aciaDefaultBaud,
+		// as if the firmware already did a master reset and picked 8N1
+		Control: 5<<aciaCtrlWordShift | 1,
+		Status:  aciaStatusTDRE,
+	}
+	if a.Link == "" {
+		return fmt.Errorf("needs a link, %v", HostLinkSpecHelp)
+	}
+	if addrStr, ok := args["addr"]; ok {
+		addr, err := strconv.ParseUint(strings.TrimPrefix(addrStr, "$"), 16, 16)
+		if err != nil {
+			return fmt.Errorf("bad addr %q: %v", addrStr, err)
+		}
+		a.Addr = uint16(addr)
+	}
+	if a.Addr >= 0xd000 && a.Addr < 0xe000 {
+		return fmt.Errorf("addr $%04X is in the PIA's I/O space", a.Addr)
+	}
+	if baudStr, ok := args["baud"]; ok {
+		baud, err := strconv.Atoi(baudStr)
+		if err != nil || baud <= 0 {
+			return fmt.Errorf("bad baud %q", baudStr)
+		}
+		a.Baud = baud
+	}
+	if irqStr, ok := args["irq"]; ok {
+		irq, err := strconv.ParseBool(irqStr)
+		if err != nil {
+			return fmt.Errorf("bad irq setting %q, want true or false", irqStr)
+		}
+		a.IRQ = irq
+	}
+	emu.ACIA = a
+	return nil
+}
+
+func (a *aciaCard) addrRange() AddrRange {
+	page := a.Addr &^ 0xff
+	return AddrRange{page, page | 0xff}
+}
+
+func (a *aciaCard) openHost() error {
+	if a.link != nil {
+		return nil
+	}
+	link, err := openHostLink(a.Link)
+	if err != nil {
+		return err
+	}
+	a.link = link
+	fmt.Printf("acia at $%04X: connect to %v\n", a.Addr, link.Desc)
+	return nil
+}
+
+func (a *aciaCard) inReset() bool {
+	return a.Control&aciaCtrlDivideMask == aciaCtrlMasterReset
+}
+
+func (a *aciaCard) dataMask() byte {
+	if (a.Control>>aciaCtrlWordShift)&7 < 4 {
+		return 0x7f
+	}
+	return 0xff
+}
+
+// frameCycles is how many cpu cycles one byte takes on the wire
+func (a *aciaCard) frameCycles(emu *emuState) int64 {
+	baud := a.Baud
+	switch a.Control & aciaCtrlDivideMask {
+	case 0:
+		baud *= 16
+	case 2:
+		baud /= 4
+	}
+	if baud < 1 {
+		baud = 1
+	}
+	bits := aciaFrameBits[(a.Control>>aciaCtrlWordShift)&7]
+	return int64(emu.clockHz()) * int64(bits) / int64(baud)
+}
+
+func (a *aciaCard) irq() bool {
+	if !a.IRQ {
+		return false
+	}
+	return a.status()&aciaStatusIRQ != 0
+}
+
+func (a *aciaCard) status() byte {
+	status := a.Status &^ aciaStatusIRQ
+	rxIRQ := a.Control&aciaCtrlRxIRQ != 0 && status&(aciaStatusRDRF|aciaStatusOVRN) != 0
+	txIRQ := (a.Control>>aciaCtrlTxShift)&3 == aciaCtrlTxIRQ && status&aciaStatusTDRE != 0
+	if rxIRQ || txIRQ {
+		status |= aciaStatusIRQ
+	}
+	return status
+}
+
+func (a *aciaCard) read(emu *emuState, addr uint16) byte {
+	if addr&1 == 0 {
+		return a.status()
+	}
+	a.Status &^= aciaStatusRDRF | aciaStatusOVRN
+	return a.RDR
+}
+
+func (a *aciaCard) write(emu *emuState, addr uint16, val byte) {
+	if addr&1 == 0 {
+		a.Control = val
+		if a.inReset() {
+			a.Status = aciaStatusTDRE
+			a.ShifterBusy = false
+		}
+		return
+	}
+	if a.inReset() {
+		return
+	}
+	a.TDR = val & a.dataMask()
+	a.Status &^= aciaStatusTDRE
+	if !a.ShifterBusy {
+		a.loadShifter(emu)
+	}
+}
+
+func (a *aciaCard) loadShifter(emu *emuState) {
+	a.Shifter = a.TDR
+	a.ShifterBusy = true
+	a.TxCountdown = a.frameCycles(emu)
+	a.Status |= aciaStatusTDRE
+}
+
+// busy is while a byte is going out, or one is due to come in, i.e.
+// the host has sent one and the cpu has read the last. Bytes the cpu
+// isn't reading don't keep it busy, so the cpu can still idle.
+func (a *aciaCard) busy() bool {
+	if a.inReset() {
+		return false
+	}
+	return a.ShifterBusy || (a.Status&aciaStatusRDRF == 0 && a.link.pending())
+}
+
+func (a *aciaCard) tick(emu *emuState, cycles uint64) {
+	if a.inReset() {
+		return
+	}
+	if a.ShifterBusy {
+		a.TxCountdown -= int64(cycles)
+		if a.TxCountdown <= 0 {
+			a.link.send(a.Shifter)
+			a.ShifterBusy = false
+			if a.Status&aciaStatusTDRE == 0 {
+				a.loadShifter(emu)
+			}
+		}
+	}
+	a.RxCountdown -= int64(cycles)
+	if a.RxCountdown <= 0 {
+		a.RxCountdown = a.frameCycles(emu)
+		if a.Status&aciaStatusRDRF == 0 {
+			if b, ok := a.link.recv(); ok {
+				a.RDR = b & a.dataMask()
+				a.Status |= aciaStatusRDRF
+			}
+		}
+	}
+}
@@ -0,0 +1,923 @@
+package a1go
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Apple-1 BASIC zero page pointers
+const (
+	basicLOMEM = 0x4a // start of variable space
+	basicHIMEM = 0x4c // end of program space
+	basicPP    = 0xca // start of program
+	basicPV    = 0xcc // end of variables
+)
+
+// what cold start (E000R) sets up in a 4K machine
+const (
+	basicDefaultLOMEM = 0x0800
+	basicDefaultHIMEM = 0x1000
+)
+
+// basicWarmStart is the entry point that keeps the program in memory
+const basicWarmStart = 0xe2b3
+
+// In memory, a program is a run of lines, lowest line number first,
+// ending at HIMEM. Each line is:
+//
+//	[len] [linenum lo] [linenum hi] [tokens...] [tokEOL]
+//
+// where len counts the whole line, len byte included. Inside a line,
+// bytes < 0x80 are tokens. Everything else is high-bit ascii:
+// numbers are their first digit (0xb0-0xb9) followed by their value
+// as a 16-bit little endian int, and variable names are spelled out
+// in full, ending at the next token.
+//
+// Integer BASIC's syntax table is context sensitive, so the same text
+// can map to several tokens depending on where it appears (e.g. "=" is
+// one token in a LET, another in a numeric compare, and yet another in
+// a string compare). The constants below name the ones we emit.
+const (
+	tokEOL       = 0x01
+	tokColon     = 0x03
+	tokLoad      = 0x04
+	tokSave      = 0x05
+	tokCon       = 0x06
+	tokRunNum    = 0x07
+	tokRun       = 0x08
+	tokDel       = 0x09
+	tokDelComma  = 0x0a
+	tokScr       = 0x0b
+	tokClr       = 0x0c
+	tokAuto      = 0x0d
+	tokAutoComma = 0x0e
+	tokHimem     = 0x10
+	tokLomem     = 0x11
+
+	tokAdd         = 0x12
+	tokSub         = 0x13
+	tokMul         = 0x14
+	tokDiv         = 0x15
+	tokEq          = 0x16
+	tokNe          = 0x17
+	tokGe          = 0x18
+	tokGt          = 0x19
+	tokLe          = 0x1a
+	tokNeAlt       = 0x1b
+	tokLt          = 0x1c
+	tokAnd         = 0x1d
+	tokOr          = 0x1e
+	tokMod         = 0x1f
+	tokPow         = 0x20
+	tokStrDimParen = 0x22
+	tokSubstrComma = 0x23
+	tokThenNum     = 0x24
+	tokThen        = 0x25
+	tokInStrComma  = 0x26
+	tokInNumComma  = 0x27
+	tokQuoteOpen   = 0x28
+	tokQuoteClose  = 0x29
+	tokSubstrParen = 0x2a
+	tokArrayParen  = 0x2d
+	tokPeek        = 0x2e
+	tokRnd         = 0x2f
+	tokSgn         = 0x30
+	tokAbs         = 0x31
+	tokPdl         = 0x32
+	tokNumDimParen = 0x34
+	tokUnaryPlus   = 0x35
+	tokUnaryMinus  = 0x36
+	tokNot         = 0x37
+	tokParen       = 0x38
+	tokStrEq       = 0x39
+	tokStrNe       = 0x3a
+	tokLen         = 0x3b
+	tokAsc         = 0x3c
+	tokFnParen     = 0x3f
+	tokDollar      = 0x40
+	tokDimComma    = 0x43
+
+	tokPrintStrSemi  = 0x45
+	tokPrintNumSemi  = 0x46
+	tokPrintEndSemi  = 0x47
+	tokPrintStrComma = 0x48
+	tokPrintNumComma = 0x49
+	tokPrintEndComma = 0x4a
+
+	tokCall       = 0x4d
+	tokDimNum     = 0x4e
+	tokDimStr     = 0x4f
+	tokTab        = 0x50
+	tokEnd        = 0x51
+	tokInputStr   = 0x52 // INPUT "PROMPT",...
+	tokInputNum   = 0x53
+	tokInputSVar  = 0x54
+	tokFor        = 0x55
+	tokForEq      = 0x56
+	tokTo         = 0x57
+	tokStep       = 0x58
+	tokNext       = 0x59
+	tokNextComma  = 0x5a
+	tokReturn     = 0x5b
+	tokGosub      = 0x5c
+	tokRem        = 0x5d
+	tokLet        = 0x5e
+	tokGoto       = 0x5f
+	tokIf         = 0x60
+	tokPrintStr   = 0x61
+	tokPrintNum   = 0x62
+	tokPrint      = 0x63
+	tokPoke       = 0x64
+	tokPokeComma  = 0x65
+	tokLetStrEq   = 0x70
+	tokLetNumEq   = 0x71
+	tokCloseParen = 0x72
+	tokListNum    = 0x74
+	tokListComma  = 0x75
+	tokList       = 0x76
+)
+
+type basicLine struct {
+	num    int
+	tokens []byte
+}
+
+// tokenizeBasic turns program text into lines of tokens, sorted by line
+// number. As when typing a program in, later lines replace earlier lines
+// with the same number, and a bare line number deletes that line.
+func tokenizeBasic(src []byte) ([]basicLine, error) {
+	lineMap := map[int][]byte{}
+	errs := []string{}
+
+	srcLines := strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n")
+	for i, srcLine := range srcLines {
+		srcLine = strings.TrimRight(srcLine, "\r")
+		if strings.TrimSpace(srcLine) == "" {
+			continue
+		}
+		num, tokens, err := tokenizeBasicLine(srcLine)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("line %v: %v", i+1, err))
+			continue
+		}
+		if tokens == nil {
+			delete(lineMap, num)
+		} else {
+			lineMap[num] = tokens
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("syntax errors in basic program:\n%v", strings.Join(errs, "\n"))
+	}
+
+	lines := []basicLine{}
+	for num, tokens := range lineMap {
+		lines = append(lines, basicLine{num, tokens})
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].num < lines[j].num })
+	return lines, nil
+}
+
+// encodeBasicProgram packs lines into their in-memory format
+func encodeBasicProgram(lines []basicLine) ([]byte, error) {
+	prog := []byte{}
+	for _, line := range lines {
+		lineLen := 3 + len(line.tokens) + 1
+		if lineLen > 0xff {
+			return nil, fmt.Errorf("basic line %v is too long", line.num)
+		}
+		prog = append(prog, byte(lineLen), byte(line.num), byte(line.num>>8))
+		prog = append(prog, line.tokens...)
+		prog = append(prog, tokEOL)
+	}
+	return prog, nil
+}
+
+func (emu *emuState) read16(addr uint16) uint16 {
+	return uint16(emu.peek(addr)) | uint16(emu.peek(addr+1))<<8
+}
+
+func (emu *emuState) write16(addr uint16, val uint16) {
+	emu.write(addr, byte(val))
+	emu.write(addr+1, byte(val>>8))
+}
+
+// loadBasicProgram tokenizes src and puts it in memory just below HIMEM,
+// setting the pointers the way BASIC would have after typing it in. If
+// BASIC hasn't been started yet, it uses the cold start defaults, so
+// the program survives a warm start (E2B3R).
+func (emu *emuState) loadBasicProgram(src []byte) error {
+	lines, err := tokenizeBasic(src)
+	if err != nil {
+		return err
+	}
+	prog, err := encodeBasicProgram(lines)
+	if err != nil {
+		return err
+	}
+
+	lomem, himem := emu.read16(basicLOMEM), emu.read16(basicHIMEM)
+	if himem == 0 || lomem >= himem {
+		lomem, himem = basicDefaultLOMEM, basicDefaultHIMEM
+	}
+	if len(prog) > int(himem-lomem) {
+		return fmt.Errorf("basic program is %v bytes, only %v bytes between LOMEM and HIMEM", len(prog), himem-lomem)
+	}
+
+	start := himem - uint16(len(prog))
+	if err := emu.loadBinaryToMem(start, prog); err != nil {
+		return err
+	}
+	emu.write16(basicLOMEM, lomem)
+	emu.write16(basicHIMEM, himem)
+	emu.write16(basicPP, start)
+	emu.write16(basicPV, lomem)
+	return nil
+}
+
+// basicSyntaxError is what the tokenizer panics with,
+// to be recovered in tokenizeBasicLine
+type basicSyntaxError struct {
+	msg string
+}
+
+type basicTokenizer struct {
+	src string
+	pos int
+	out []byte
+}
+
+func tokenizeBasicLine(srcLine string) (num int, tokens []byte, err error) {
+	t := basicTokenizer{src: strings.ToUpper(srcLine)}
+
+	defer func() {
+		if r := recover(); r != nil {
+			synErr, ok := r.(basicSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%v (at col %v)", synErr.msg, t.pos+1)
+		}
+	}()
+
+	t.skipSpaces()
+	if !t.atDigit() {
+		t.fail("missing line number")
+	}
+	num = t.number()
+	if num > 32767 {
+		t.fail("line number %v out of range", num)
+	}
+	if t.skipSpaces(); t.atEnd() {
+		return num, nil, nil
+	}
+	t.out = []byte{}
+	t.statementList()
+	return num, t.out, nil
+}
+
+func (t *basicTokenizer) fail(format string, args ...interface{}) {
+	panic(basicSyntaxError{fmt.Sprintf(format, args...)})
+}
+
+func (t *basicTokenizer) emit(b ...byte) {
+	t.out = append(t.out, b...)
+}
+
+func (t *basicTokenizer) skipSpaces() {
+	for t.pos < len(t.src) && (t.src[t.pos] == ' ' || t.src[t.pos] == '\t') {
+		t.pos++
+	}
+}
+
+func (t *basicTokenizer) atEnd() bool {
+	t.skipSpaces()
+	return t.pos >= len(t.src)
+}
+
+func (t *basicTokenizer) peek() byte {
+	t.skipSpaces()
+	if t.pos >= len(t.src) {
+		return 0
+	}
+	return t.src[t.pos]
+}
+
+func (t *basicTokenizer) atDigit() bool {
+	c := t.peek()
+	return c >= '0' && c <= '9'
+}
+
+func (t *basicTokenizer) atLetter() bool {
+	c := t.peek()
+	return c >= 'A' && c <= 'Z'
+}
+
+func (t *basicTokenizer) atKeyword(kw string) bool {
+	t.skipSpaces()
+	return strings.HasPrefix(t.src[t.pos:], kw)
+}
+
+func (t *basicTokenizer) accept(kw string) bool {
+	if t.atKeyword(kw) {
+		t.pos += len(kw)
+		return true
+	}
+	return false
+}
+
+func (t *basicTokenizer) expect(kw string, tok byte) {
+	if !t.accept(kw) {
+		t.fail("expected %q", kw)
+	}
+	t.emit(tok)
+}
+
+func (t *basicTokenizer) number() int {
+	t.skipSpaces()
+	val := 0
+	for t.pos < len(t.src) && t.src[t.pos] >= '0' && t.src[t.pos] <= '9' {
+		val = val*10 + int(t.src[t.pos]-'0')
+		if val > 0xffff {
+			t.fail("number too big")
+		}
+		t.pos++
+	}
+	return val
+}
+
+func (t *basicTokenizer) numberConst() {
+	t.skipSpaces()
+	firstDigit := t.src[t.pos]
+	val := t.number()
+	if val > 32767 {
+		t.fail("number %v out of range", val)
+	}
+	t.emit(firstDigit|0x80, byte(val), byte(val>>8))
+}
+
+// keywords that can end a variable name, as they can come right after one
+var basicInfixKeywords = []string{"AND", "OR", "MOD", "TO", "STEP", "THEN"}
+
+// varName emits a variable name, and reports if it's a string var
+func (t *basicTokenizer) varName() bool {
+	if !t.atLetter() {
+		t.fail("expected variable name")
+	}
+	t.emit(t.src[t.pos] | 0x80)
+	t.pos++
+	for t.pos < len(t.src) {
+		c := t.src[t.pos]
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			break
+		}
+		infix := false
+		for _, kw := range basicInfixKeywords {
+			if strings.HasPrefix(t.src[t.pos:], kw) {
+				infix = true
+			}
+		}
+		if infix {
+			break
+		}
+		t.emit(c | 0x80)
+		t.pos++
+	}
+	if t.peek() == '$' {
+		t.pos++
+		t.emit(tokDollar)
+		return true
+	}
+	return false
+}
+
+// variable emits a variable with an optional subscript,
+// and reports if it's a string var
+func (t *basicTokenizer) variable() bool {
+	isStr := t.varName()
+	if t.peek() == '(' {
+		t.pos++
+		if isStr {
+			t.emit(tokSubstrParen)
+			t.numExpr()
+			if t.accept(",") {
+				t.emit(tokSubstrComma)
+				t.numExpr()
+			}
+		} else {
+			t.emit(tokArrayParen)
+			t.numExpr()
+		}
+		t.expect(")", tokCloseParen)
+	}
+	return isStr
+}
+
+func (t *basicTokenizer) stringLiteral() {
+	t.skipSpaces()
+	t.pos++ // opening quote
+	t.emit(tokQuoteOpen)
+	for {
+		if t.pos >= len(t.src) {
+			t.fail("unterminated string")
+		}
+		c := t.src[t.pos]
+		t.pos++
+		if c == '"' {
+			break
+		}
+		t.emit(c | 0x80)
+	}
+	t.emit(tokQuoteClose)
+}
+
+type basicFunc struct {
+	name string
+	tok  byte
+}
+
+var basicNumFuncs = []basicFunc{
+	{"PEEK", tokPeek}, {"RND", tokRnd}, {"SGN", tokSgn}, {"ABS", tokAbs}, {"PDL", tokPdl},
+}
+
+// operand emits a single value, and reports if it's a string
+func (t *basicTokenizer) operand() bool {
+	switch {
+	case t.accept("-"):
+		t.emit(tokUnaryMinus)
+		t.operand()
+		return false
+	case t.accept("+"):
+		t.emit(tokUnaryPlus)
+		t.operand()
+		return false
+	case t.accept("NOT"):
+		t.emit(tokNot)
+		t.operand()
+		return false
+	case t.accept("("):
+		t.emit(tokParen)
+		t.numExpr()
+		t.expect(")", tokCloseParen)
+		return false
+	case t.peek() == '"':
+		t.stringLiteral()
+		return true
+	case t.atDigit():
+		t.numberConst()
+		return false
+	case t.accept("LEN("):
+		t.emit(tokLen)
+		t.strExpr()
+		t.expect(")", tokCloseParen)
+		return false
+	case t.accept("ASC("):
+		t.emit(tokAsc)
+		t.strExpr()
+		t.expect(")", tokCloseParen)
+		return false
+	}
+	for _, fn := range basicNumFuncs {
+		if t.atKeyword(fn.name) {
+			t.pos += len(fn.name)
+			t.emit(fn.tok)
+			t.expect("(", tokFnParen)
+			t.numExpr()
+			t.expect(")", tokCloseParen)
+			return false
+		}
+	}
+	if t.atLetter() {
+		return t.variable()
+	}
+	t.fail("expected expression")
+	return false
+}
+
+type basicOp struct {
+	text string
+	tok  byte
+}
+
+// two char ops first, so they match before their one char prefixes
+var basicNumOps = []basicOp{
+	{">=", tokGe}, {"<=", tokLe}, {"<>", tokNeAlt},
+	{"+", tokAdd}, {"-", tokSub}, {"*", tokMul}, {"/", tokDiv}, {"^", tokPow},
+	{"=", tokEq}, {"#", tokNe}, {">", tokGt}, {"<", tokLt},
+	{"AND", tokAnd}, {"OR", tokOr}, {"MOD", tokMod},
+}
+
+var basicStrOps = []basicOp{
+	{"<>", tokStrNe}, {"=", tokStrEq}, {"#", tokStrNe},
+}
+
+// expr emits an expression, and reports if it's a string
+func (t *basicTokenizer) expr() bool {
+	isStr := t.operand()
+	for {
+		if isStr {
+			matched := false
+			for _, op := range basicStrOps {
+				if t.accept(op.text) {
+					t.emit(op.tok)
+					if !t.operand() {
+						t.fail("can't compare a string to a number")
+					}
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return true
+			}
+			isStr = false
+		} else {
+			matched := false
+			for _, op := range basicNumOps {
+				if t.accept(op.text) {
+					t.emit(op.tok)
+					if t.operand() {
+						t.fail("can't use a string in a numeric expression")
+					}
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		}
+	}
+}
+
+func (t *basicTokenizer) numExpr() {
+	if t.expr() {
+		t.fail("expected a number, not a string")
+	}
+}
+
+func (t *basicTokenizer) strExpr() {
+	if !t.expr() {
+		t.fail("expected a string, not a number")
+	}
+}
+
+func (t *basicTokenizer) statementList() {
+	t.statement()
+	for t.accept(":") {
+		t.emit(tokColon)
+		t.statement()
+	}
+	if !t.atEnd() {
+		t.fail("unexpected %q", t.src[t.pos:])
+	}
+}
+
+// order matters where one keyword is a prefix of another
+func (t *basicTokenizer) statement() {
+	switch {
+	case t.accept("REM"):
+		t.remStatement()
+	case t.accept("PRINT"):
+		t.printStatement()
+	case t.accept("INPUT"):
+		t.inputStatement()
+	case t.accept("LET"):
+		t.emit(tokLet)
+		t.letStatement()
+	case t.accept("GOTO"):
+		t.emit(tokGoto)
+		t.numExpr()
+	case t.accept("GOSUB"):
+		t.emit(tokGosub)
+		t.numExpr()
+	case t.accept("RETURN"):
+		t.emit(tokReturn)
+	case t.accept("IF"):
+		t.ifStatement()
+	case t.accept("FOR"):
+		t.forStatement()
+	case t.accept("NEXT"):
+		t.nextStatement()
+	case t.accept("DIM"):
+		t.dimStatement()
+	case t.accept("POKE"):
+		t.emit(tokPoke)
+		t.numExpr()
+		t.expect(",", tokPokeComma)
+		t.numExpr()
+	case t.accept("CALL"):
+		t.emit(tokCall)
+		t.numExpr()
+	case t.accept("TAB"):
+		t.emit(tokTab)
+		t.numExpr()
+	case t.accept("END"):
+		t.emit(tokEnd)
+	case t.accept("HIMEM"):
+		t.memStatement(tokHimem)
+	case t.accept("LOMEM"):
+		t.memStatement(tokLomem)
+	case t.accept("LIST"):
+		t.optionalRange(tokList, tokListNum, tokListComma)
+	case t.accept("RUN"):
+		t.optionalRange(tokRun, tokRunNum, 0)
+	case t.accept("DEL"):
+		t.optionalRange(0, tokDel, tokDelComma)
+	case t.accept("AUTO"):
+		t.optionalRange(0, tokAuto, tokAutoComma)
+	case t.accept("CLR"):
+		t.emit(tokClr)
+	case t.accept("SCR"):
+		t.emit(tokScr)
+	case t.accept("CON"):
+		t.emit(tokCon)
+	case t.accept("LOAD"):
+		t.emit(tokLoad)
+	case t.accept("SAVE"):
+		t.emit(tokSave)
+	case t.atLetter():
+		t.letStatement()
+	default:
+		t.fail("expected statement")
+	}
+}
+
+func (t *basicTokenizer) remStatement() {
+	t.emit(tokRem)
+	for ; t.pos < len(t.src); t.pos++ {
+		t.emit(t.src[t.pos] | 0x80)
+	}
+}
+
+func (t *basicTokenizer) letStatement() {
+	if t.variable() {
+		t.expect("=", tokLetStrEq)
+		t.strExpr()
+	} else {
+		t.expect("=", tokLetNumEq)
+		t.numExpr()
+	}
+}
+
+// PRINT's token and its separators' tokens
+// depend on whether they follow a string or a number
+func (t *basicTokenizer) printStatement() {
+	if t.atEnd() || t.peek() == ':' {
+		t.emit(tokPrint)
+		return
+	}
+	tokPos := len(t.out)
+	t.emit(tokPrint)
+	first := true
+	for {
+		if t.atEnd() || t.peek() == ':' {
+			return
+		}
+		isStr := false
+		if c := t.peek(); c != ';' && c != ',' {
+			isStr = t.expr()
+		}
+		if first {
+			first = false
+			t.out[tokPos] = tokPrintNum
+			if isStr {
+				t.out[tokPos] = tokPrintStr
+			}
+		}
+		semi, comma := byte(tokPrintNumSemi), byte(tokPrintNumComma)
+		if isStr {
+			semi, comma = tokPrintStrSemi, tokPrintStrComma
+		}
+		switch {
+		case t.accept(";"):
+			if t.atEnd() || t.peek() == ':' {
+				semi = tokPrintEndSemi
+			}
+			t.emit(semi)
+		case t.accept(","):
+			if t.atEnd() || t.peek() == ':' {
+				comma = tokPrintEndComma
+			}
+			t.emit(comma)
+		default:
+			return
+		}
+	}
+}
+
+// like PRINT, INPUT's tokens depend on what type of var follows them
+func (t *basicTokenizer) inputStatement() {
+	if t.peek() == '"' {
+		t.emit(tokInputStr)
+		t.stringLiteral()
+		if !t.accept(",") {
+			t.fail("expected \",\"")
+		}
+	} else {
+		tokPos := len(t.out)
+		t.emit(tokInputNum)
+		if t.variable() {
+			t.out[tokPos] = tokInputSVar
+		}
+		if !t.accept(",") {
+			return
+		}
+	}
+	for {
+		sepPos := len(t.out)
+		t.emit(tokInNumComma)
+		if t.variable() {
+			t.out[sepPos] = tokInStrComma
+		}
+		if !t.accept(",") {
+			return
+		}
+	}
+}
+
+func (t *basicTokenizer) ifStatement() {
+	t.emit(tokIf)
+	t.numExpr()
+	if !t.accept("THEN") {
+		t.fail("expected THEN")
+	}
+	if t.atDigit() {
+		t.emit(tokThenNum)
+		t.numberConst()
+	} else {
+		t.emit(tokThen)
+		t.statement()
+	}
+}
+
+func (t *basicTokenizer) forStatement() {
+	t.emit(tokFor)
+	if t.varName() {
+		t.fail("FOR needs a numeric variable")
+	}
+	t.expect("=", tokForEq)
+	t.numExpr()
+	t.expect("TO", tokTo)
+	t.numExpr()
+	if t.accept("STEP") {
+		t.emit(tokStep)
+		t.numExpr()
+	}
+}
+
+func (t *basicTokenizer) nextStatement() {
+	t.emit(tokNext)
+	for {
+		if t.varName() {
+			t.fail("NEXT needs a numeric variable")
+		}
+		if !t.accept(",") {
+			return
+		}
+		t.emit(tokNextComma)
+	}
+}
+
+func (t *basicTokenizer) dimStatement() {
+	tokPos := len(t.out)
+	t.emit(tokDimNum)
+	for first := true; ; first = false {
+		isStr := t.varName()
+		if first && isStr {
+			t.out[tokPos] = tokDimStr
+		}
+		if isStr {
+			t.expect("(", tokStrDimParen)
+		} else {
+			t.expect("(", tokNumDimParen)
+		}
+		t.numExpr()
+		t.expect(")", tokCloseParen)
+		if !t.accept(",") {
+			return
+		}
+		t.emit(tokDimComma)
+	}
+}
+
+func (t *basicTokenizer) memStatement(tok byte) {
+	if !t.accept("=") && !t.accept(":") {
+		t.fail("expected \"=\"")
+	}
+	t.emit(tok)
+	t.numExpr()
+}
+
+// optionalRange handles e.g. LIST, LIST 10, LIST 10,20. Zero tokens
+// mean that form isn't allowed.
+func (t *basicTokenizer) optionalRange(bareTok, numTok, commaTok byte) {
+	if t.atEnd() || t.peek() == ':' {
+		if bareTok == 0 {
+			t.fail("expected line number")
+		}
+		t.emit(bareTok)
+		return
+	}
+	if numTok == 0 {
+		t.fail("unexpected %q", t.src[t.pos:])
+	}
+	t.emit(numTok)
+	t.numExpr()
+	if commaTok != 0 && t.accept(",") {
+		t.emit(commaTok)
+		t.numExpr()
+	}
+}
+
+// basicTokenText is how each token LISTs
+var basicTokenText = [128]string{
+	"", "", "_", ":", "LOAD", "SAVE", "CON", "RUN ",
+	"RUN", "DEL ", ",", "SCR", "CLR", "AUTO ", ",", "MAN",
+	"HIMEM=", "LOMEM=", "+", "-", "*", "/", "=", "#",
+	">=", ">", "<=", "<>", "<", " AND ", " OR ", " MOD ",
+	"^", "+", "(", ",", " THEN ", " THEN ", ",", ",",
+	"\"", "\"", "(", "!", "!", "(", "PEEK", "RND",
+	"SGN", "ABS", "PDL", "RNDX", "(", "+", "-", "NOT ",
+	"(", "=", "#", "LEN(", "ASC(", "SCRN(", ",", "(",
+	"$", "$", "(", ",", ",", ";", ";", ";",
+	",", ",", ",", "TEXT", "GR", "CALL ", "DIM ", "DIM ",
+	"TAB ", "END", "INPUT ", "INPUT ", "INPUT ", "FOR ", "=", " TO ",
+	" STEP ", "NEXT ", ",", "RETURN", "GOSUB ", "REM", "LET ", "GOTO ",
+	"IF ", "PRINT ", "PRINT ", "PRINT", "POKE ", ",", "COLOR=", "PLOT ",
+	",", "HLIN ", ",", " AT ", "VLIN ", ",", " AT ", "VTAB ",
+	"=", "=", ")", ")", "LIST ", ",", "LIST", "POP",
+	"NODSP ", "NODSP ", "NOTRACE", "DSP ", "DSP ", "TRACE", "PR#", "IN#",
+}
+
+// listBasicProgram reads the program out of memory, from PP up to HIMEM,
+// and returns it as text, one line per program line.
+func (emu *emuState) listBasicProgram() (string, error) {
+	pp, himem := emu.read16(basicPP), emu.read16(basicHIMEM)
+	if himem == 0 || pp > himem {
+		return "", fmt.Errorf("no basic program in memory (PP=0x%04x, HIMEM=0x%04x)", pp, himem)
+	}
+	for page := int(pp >> 8); pp < himem && page <= int((himem-1)>>8); page++ {
+		if !emu.Mem.isRAM(uint16(page << 8)) {
+			return "", fmt.Errorf("no basic program in memory (PP=0x%04x to HIMEM=0x%04x isn't all in RAM)", pp, himem)
+		}
+	}
+
+	sb := strings.Builder{}
+	for addr := pp; addr < himem; {
+		lineLen := uint16(emu.peek(addr))
+		if lineLen < 4 || addr+lineLen > himem || addr+lineLen < addr {
+			return "", fmt.Errorf("bad basic line length %v at 0x%04x", lineLen, addr)
+		}
+		line := make([]byte, lineLen)
+		for i := range line {
+			line[i] = emu.peek(addr + uint16(i))
+		}
+		num := int(line[1]) | int(line[2])<<8
+		text, err := listBasicLine(line[3:])
+		if err != nil {
+			return "", fmt.Errorf("line %v: %v", num, err)
+		}
+		fmt.Fprintf(&sb, "%v %v\n", num, text)
+		addr += lineLen
+	}
+	return sb.String(), nil
+}
+
+func listBasicLine(tokens []byte) (string, error) {
+	sb := strings.Builder{}
+	inVarName := false
+	for i := 0; i < len(tokens); i++ {
+		b := tokens[i]
+		switch {
+		case b == tokEOL:
+			return sb.String(), nil
+		case b == tokRem:
+			sb.WriteString(basicTokenText[b])
+			for i++; i < len(tokens) && tokens[i] != tokEOL; i++ {
+				sb.WriteByte(tokens[i] & 0x7f)
+			}
+			return sb.String(), nil
+		case b == tokQuoteOpen:
+			sb.WriteByte('"')
+			for i++; i < len(tokens) && tokens[i] != tokQuoteClose; i++ {
+				sb.WriteByte(tokens[i] & 0x7f)
+			}
+			sb.WriteByte('"')
+		case b < 0x80:
+			inVarName = false
+			sb.WriteString(basicTokenText[b])
+		case b >= 0xb0 && b <= 0xb9 && !inVarName:
+			if i+2 >= len(tokens) {
+				return "", fmt.Errorf("truncated number")
+			}
+			fmt.Fprintf(&sb, "%v", int(tokens[i+1])|int(tokens[i+2])<<8)
+			i += 2
+		default:
+			inVarName = true
+			sb.WriteByte(b & 0x7f)
+		}
+	}
+	return "", fmt.Errorf("missing end of line")
+}
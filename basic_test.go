@@ -0,0 +1,215 @@
+package a1go
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenizeBasicLine(t *testing.T) {
+	tests := []struct {
+		src    string
+		num    int
+		tokens []byte
+	}{
+		{`10 PRINT "HI"`, 10, []byte{0x61, 0x28, 0xc8, 0xc9, 0x29}},
+		{`20 goto 10`, 20, []byte{0x5f, 0xb1, 0x0a, 0x00}},
+		{`30 A=5`, 30, []byte{0xc1, 0x71, 0xb5, 0x05, 0x00}},
+		{`40 IF A>3 THEN 10`, 40, []byte{0x60, 0xc1, 0x19, 0xb3, 0x03, 0x00, 0x24, 0xb1, 0x0a, 0x00}},
+		{`50 FOR I=1 TO 10 STEP 2`, 50, []byte{0x55, 0xc9, 0x56, 0xb1, 0x01, 0x00, 0x57, 0xb1, 0x0a, 0x00, 0x58, 0xb2, 0x02, 0x00}},
+		{`60 NEXT I`, 60, []byte{0x59, 0xc9}},
+		{`70 A$="X"`, 70, []byte{0xc1, 0x40, 0x70, 0x28, 0xd8, 0x29}},
+		{`80 REM HI THERE`, 80, []byte{0x5d, 0xa0, 0xc8, 0xc9, 0xa0, 0xd4, 0xc8, 0xc5, 0xd2, 0xc5}},
+		{`90 END`, 90, []byte{0x51}},
+		{`100 PRINT A;`, 100, []byte{0x62, 0xc1, 0x47}},
+		{`110 X=PEEK(-1)`, 110, []byte{0xd8, 0x71, 0x2e, 0x3f, 0x36, 0xb1, 0x01, 0x00, 0x72}},
+		{`120 DIM A$(10),B(5)`, 120, []byte{0x4f, 0xc1, 0x40, 0x22, 0xb1, 0x0a, 0x00, 0x72, 0x43, 0xc2, 0x34, 0xb5, 0x05, 0x00, 0x72}},
+		{`130 INPUT "NUM",N`, 130, []byte{0x52, 0x28, 0xce, 0xd5, 0xcd, 0x29, 0x27, 0xce}},
+		{`140 POKE 512,X*2+1:CALL -256`, 140, []byte{0x64, 0xb5, 0x00, 0x02, 0x65, 0xd8, 0x14, 0xb2, 0x02, 0x00, 0x12, 0xb1, 0x01, 0x00, 0x03, 0x4d, 0x36, 0xb2, 0x00, 0x01}},
+		{`150 IF A$="Y" THEN PRINT "YES"`, 150, []byte{0x60, 0xc1, 0x40, 0x39, 0x28, 0xd9, 0x29, 0x25, 0x61, 0x28, 0xd9, 0xc5, 0xd3, 0x29}},
+		{`160 LET Z=(A+B)/2 MOD 3`, 160, []byte{0x5e, 0xda, 0x71, 0x38, 0xc1, 0x12, 0xc2, 0x72, 0x15, 0xb2, 0x02, 0x00, 0x1f, 0xb3, 0x03, 0x00}},
+		{`170 GOSUB 100 : RETURN`, 170, []byte{0x5c, 0xb1, 0x64, 0x00, 0x03, 0x5b}},
+		{`180 PRINT A,B`, 180, []byte{0x62, 0xc1, 0x49, 0xc2}},
+		{`190 IF A#B AND C<=D OR NOT E THEN 5`, 190, []byte{0x60, 0xc1, 0x17, 0xc2, 0x1d, 0xc3, 0x1a, 0xc4, 0x1e, 0x37, 0xc5, 0x24, 0xb5, 0x05, 0x00}},
+		// a bare line number deletes the line
+		{`200`, 200, nil},
+	}
+	for _, test := range tests {
+		num, tokens, err := tokenizeBasicLine(test.src)
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if num != test.num || string(tokens) != string(test.tokens) {
+			t.Errorf("%q: got line %v % x, want line %v % x", test.src, num, tokens, test.num, test.tokens)
+		}
+	}
+}
+
+func TestTokenizeBasicLineErrors(t *testing.T) {
+	tests := []struct {
+		src, wantErr string
+	}{
+		{`PRINT "HI"`, "missing line number"},
+		{`40000 END`, "out of range"},
+		{`10 GOTO`, "col"},
+		{`10 PRINT "HI`, "col"},
+		{`10 A=`, "col"},
+		{`10 FOR I=1`, "col"},
+	}
+	for _, test := range tests {
+		_, _, err := tokenizeBasicLine(test.src)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%q: got error %v, want one mentioning %q", test.src, err, test.wantErr)
+		}
+	}
+}
+
+func TestTokenizeBasic(t *testing.T) {
+	src := "30 END\r\n10 PRINT 1\n\n20 PRINT 2\n10 PRINT 3\n20\n"
+	lines, err := tokenizeBasic([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []basicLine{
+		{10, []byte{0x62, 0xb3, 0x03, 0x00}},
+		{30, []byte{0x51}},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %v lines, want %v", len(lines), len(want))
+	}
+	for i := range want {
+		if lines[i].num != want[i].num || string(lines[i].tokens) != string(want[i].tokens) {
+			t.Errorf("line %v is %v % x, want %v % x", i, lines[i].num, lines[i].tokens, want[i].num, want[i].tokens)
+		}
+	}
+
+	prog, err := encodeBasicProgram(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantProg := []byte{
+		0x08, 0x0a, 0x00, 0x62, 0xb3, 0x03, 0x00, 0x01,
+		0x05, 0x1e, 0x00, 0x51, 0x01,
+	}
+	if string(prog) != string(wantProg) {
+		t.Errorf("encoded % x, want % x", prog, wantProg)
+	}
+
+	_, err = tokenizeBasic([]byte("10 END\n20 GOTO\n30 FOO BAR\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2:") || !strings.Contains(err.Error(), "line 3:") {
+		t.Errorf("got error %v, want one for lines 2 and 3", err)
+	}
+}
+
+func TestLoadBasicProgram(t *testing.T) {
+	emu := newState()
+	if err := emu.loadBasicProgram([]byte("10 PRINT 3\n30 END\n")); err != nil {
+		t.Fatal(err)
+	}
+	// with BASIC not started, it goes just under the cold start HIMEM
+	pointers := []struct {
+		name      string
+		addr, val uint16
+	}{
+		{"LOMEM", basicLOMEM, basicDefaultLOMEM},
+		{"HIMEM", basicHIMEM, basicDefaultHIMEM},
+		{"PP", basicPP, basicDefaultHIMEM - 13},
+		{"PV", basicPV, basicDefaultLOMEM},
+	}
+	for _, p := range pointers {
+		if got := emu.read16(p.addr); got != p.val {
+			t.Errorf("%v is 0x%04x, want 0x%04x", p.name, got, p.val)
+		}
+	}
+	if got := emu.peek(basicDefaultHIMEM - 13); got != 0x08 {
+		t.Errorf("first line's length is %v, want 8", got)
+	}
+
+	// a program too big for LOMEM to HIMEM is refused
+	emu.write16(basicHIMEM, basicDefaultLOMEM+4)
+	if err := emu.loadBasicProgram([]byte("10 PRINT 3\n")); err == nil {
+		t.Error("loaded a program bigger than LOMEM to HIMEM")
+	}
+}
+
+func TestBasicListRoundTrip(t *testing.T) {
+	tests := []struct {
+		src, listing string
+	}{
+		{`10 print "hi"`, `10 PRINT "HI"`},
+		{`20 goto 10`, `20 GOTO 10`},
+		{`30 A = 5`, `30 A=5`},
+		{`40 IF A>3 THEN 10`, `40 IF A>3 THEN 10`},
+		{`50 FOR I=1 TO 10 STEP 2`, `50 FOR I=1 TO 10 STEP 2`},
+		{`60 NEXT I`, `60 NEXT I`},
+		{`70 A$="X"`, `70 A$="X"`},
+		{`80 REM  odd  SPACING`, `80 REM  ODD  SPACING`},
+		{`100 PRINT A;`, `100 PRINT A;`},
+		{`110 X=PEEK(-1)`, `110 X=PEEK(-1)`},
+		{`120 DIM A$(10),B(5)`, `120 DIM A$(10),B(5)`},
+		{`130 INPUT "NUM",N`, `130 INPUT "NUM",N`},
+		{`140 POKE 512,X*2+1 : CALL -256`, `140 POKE 512,X*2+1:CALL -256`},
+		{`150 IF A$="Y" THEN PRINT "YES"`, `150 IF A$="Y" THEN PRINT "YES"`},
+		{`160 LET Z=(A+B)/2 MOD 3`, `160 LET Z=(A+B)/2 MOD 3`},
+		{`170 GOSUB 100:RETURN`, `170 GOSUB 100:RETURN`},
+		{`180 PRINT A,B`, `180 PRINT A,B`},
+		{`190 IF A#B AND C<=D OR NOT E THEN 5`, `190 IF A#B AND C<=D OR NOT E THEN 5`},
+		{`200 LIST 10,20`, `200 LIST 10,20`},
+		{`210 VAR1=32767`, `210 VAR1=32767`},
+	}
+	for _, test := range tests {
+		emu := newState()
+		if err := emu.loadBasicProgram([]byte(test.src)); err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		listing, err := emu.listBasicProgram()
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if listing != test.listing+"\n" {
+			t.Errorf("%q listed as %q, want %q", test.src, listing, test.listing+"\n")
+		}
+
+		// and the listing tokenizes back to the same thing
+		_, want, _ := tokenizeBasicLine(test.src)
+		if _, got, err := tokenizeBasicLine(test.listing); err != nil || string(got) != string(want) {
+			t.Errorf("%q tokenized back to % x (err %v), want % x", test.listing, got, err, want)
+		}
+	}
+}
+
+func TestListBasicProgramErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		pp, himem uint16
+		prog      []byte
+		ram       []AddrRange
+	}{
+		{"nothing loaded", 0, 0, nil, nil},
+		{"PP above HIMEM", 0x0900, 0x0800, nil, nil},
+		{"short line", 0x0f00, 0x0f04, []byte{0x02, 0x0a, 0x00, 0x01}, nil},
+		{"line past HIMEM", 0x0f00, 0x0f04, []byte{0x08, 0x0a, 0x00, 0x01}, nil},
+		{"missing end of line", 0x0f00, 0x0f05, []byte{0x05, 0x0a, 0x00, 0x51, 0x51}, nil},
+		{"truncated number", 0x0f00, 0x0f06, []byte{0x06, 0x0a, 0x00, 0x5f, 0xb1, 0x01}, nil},
+		// these would stop the emulator if read the way the cpu does
+		{"PP in I/O space", 0xc000, 0xc100, nil, nil},
+		{"HIMEM past RAM", 0xbf00, 0xd013, nil, nil},
+		{"PP in unpopulated RAM", 0x0f00, 0x1000, nil, []AddrRange{{0x0000, 0x0eff}}},
+	}
+	for _, test := range tests {
+		emu := newState()
+		if test.ram != nil {
+			if err := emu.setRAMLayout(test.ram); err != nil {
+				t.Fatal(err)
+			}
+		}
+		emu.write16(basicPP, test.pp)
+		emu.write16(basicHIMEM, test.himem)
+		emu.loadBinaryToMem(test.pp, test.prog)
+		if listing, err := emu.listBasicProgram(); err == nil {
+			t.Errorf("%v: listed %q, want an error", test.name, listing)
+		}
+	}
+}
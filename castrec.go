@@ -0,0 +1,103 @@
+package a1go
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// CastRecorder writes what the display shows as an asciinema v2
+// cast, a char at a time. Chars are timed in emulated time, so casts
+// replay at the Apple-1's own rate, even if recorded in turbo.
+type CastRecorder struct {
+	w      *bufio.Writer
+	col    int
+	events int
+
+	// time is emulated time so far, in seconds
+	time      float64
+	lastCycle uint64
+
+	err error
+}
+
+type castHeader struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Title     string `json:"title,omitempty"`
+}
+
+// NewCastRecorder starts a cast of emu's display, with an optional
+// title. Pass it each char from emu's WatchOutput with Output.
+func NewCastRecorder(w io.Writer, emu Emulator, title string) (*CastRecorder, error) {
+	r := &CastRecorder{
+		w:         bufio.NewWriter(w),
+		lastCycle: emu.CycleCount(),
+	}
+	header, err := json.Marshal(castHeader{
+		Version:   2,
+		Width:     termCols,
+		Height:    termRows,
+		Timestamp: time.Now().Unix(),
+		Title:     title,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.write(append(header, '\n'))
+	return r, r.err
+}
+
+func (r *CastRecorder) write(b []byte) {
+	if r.err == nil {
+		_, r.err = r.w.Write(b)
+	}
+}
+
+// Output adds a char written to emu's display
+func (r *CastRecorder) Output(emu Emulator, char byte) error {
+	// a snapshot load can turn back the clock, so just count what's
+	// gone by since the last char
+	cycle := emu.CycleCount()
+	if cycle > r.lastCycle {
+		r.time += float64(cycle-r.lastCycle) / float64(emu.ClockHz())
+	}
+	r.lastCycle = cycle
+
+	// the display wraps as soon as a line's full, and a CR after
+	// that is another line, so terminals mustn't do their own wrapping
+	out := ""
+	if char == '\r' {
+		out, r.col = "\r\n", 0
+	} else {
+		out = string(rune(char))
+		if r.col++; r.col == termCols {
+			out, r.col = out+"\r\n", 0
+		}
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	r.write([]byte(fmt.Sprintf("[%.6f, \"o\", %s]\n", r.time, data)))
+	r.events++
+	return r.err
+}
+
+// Close flushes the cast. It doesn't close the writer the
+// recorder was made with.
+func (r *CastRecorder) Close() error {
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+// Events is how many output events have been written so far
+func (r *CastRecorder) Events() int {
+	return r.events
+}
@@ -0,0 +1,555 @@
+package a1go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The CFFA1 puts its firmware at $9000-$AFDF and a CompactFlash card's
+// ATA task file at $AFE0-$AFFF, laid out like the Apple II CFFA's. The
+// data register is 16 bits wide, so the high byte goes through a latch:
+// reading the data register latches the high byte for a following read
+// of $AFE0, and a write of $AFE0 latches the high byte for the next
+// write of the data register.
+const (
+	cffa1FirmwareStart = 0x9000
+	cffa1RegsStart     = 0xafe0
+	cffa1End           = 0xafff
+
+	cfRegDataHigh    = 0x0
+	cfRegSetCSMask   = 0x1
+	cfRegClearCSMask = 0x2
+	cfRegDevCtrl     = 0x6 // alt status on read
+	cfRegData        = 0x8
+	cfRegError       = 0x9 // features on write
+	cfRegSectorCount = 0xa
+	cfRegLBA0        = 0xb
+	cfRegLBA1        = 0xc
+	cfRegLBA2        = 0xd
+	cfRegHead        = 0xe
+	cfRegStatus      = 0xf // command on write
+
+	cfStatusBSY  = 0x80
+	cfStatusDRDY = 0x40
+	cfStatusDSC  = 0x10
+	cfStatusDRQ  = 0x08
+	cfStatusERR  = 0x01
+
+	cfErrorIDNF = 0x10
+	cfErrorABRT = 0x04
+
+	cfHeadLBA = 0x40
+
+	cfDevCtrlSRST = 0x04
+
+	cfCmdReadSectors         = 0x20
+	cfCmdReadSectorsNoRetry  = 0x21
+	cfCmdWriteSectors        = 0x30
+	cfCmdWriteSectorsNoRetry = 0x31
+	cfCmdInitParams          = 0x91
+	cfCmdIdentify            = 0xec
+	cfCmdSetFeatures         = 0xef
+
+	// CHS geometry reported by IDENTIFY, for software that doesn't do LBA
+	cfHeads           = 16
+	cfSectorsPerTrack = 63
+
+	cfNoTransfer = 0
+	cfReading    = 1
+	cfWriting    = 2
+)
+
+// cffa1Card is the CFFA1 CompactFlash card, backed by a ProDOS disk
+// image or by a host dir turned into a ProDOS volume.
+type cffa1Card struct {
+	ImagePath string
+	HostDir   string
+
+	Error       byte
+	Features    byte
+	SectorCount byte
+	LBA         [3]byte
+	Head        byte
+	Status      byte
+	DevCtrl     byte
+	DataHigh    byte
+
+	Buffer      [prodosBlockSize]byte
+	BufferPos   int
+	Transfer    int
+	TransferLBA uint32
+	SectorsLeft int
+
+	store blockStore
+
+	wroteThisFrame bool
+	needsFlush     bool
+}
+
+func installCFFA1(emu *emuState, args map[string]string) error {
+	c := &cffa1Card{
+		ImagePath: args["image"],
+		HostDir:   args["dir"],
+		Status:    cfStatusDRDY | cfStatusDSC,
+	}
+	if (c.ImagePath == "") == (c.HostDir == "") {
+		return fmt.Errorf("needs exactly one of image or dir")
+	}
+	emu.CFFA1 = c
+	return nil
+}
+
+func (c *cffa1Card) addrRange() AddrRange {
+	return AddrRange{cffa1FirmwareStart, cffa1End}
+}
+
+func (c *cffa1Card) openHost() error {
+	if c.store != nil {
+		return nil
+	}
+	var err error
+	if c.ImagePath != "" {
+		c.store, err = openImageStore(c.ImagePath)
+	} else {
+		c.store, err = openDirStore(c.HostDir)
+	}
+	return err
+}
+
+// handOverHost gives the store, and any flush it's waiting on, to a
+// card on the same image or dir, so unsaved writes carry over. Anything
+// else gets the store flushed and closed.
+func (c *cffa1Card) handOverHost(next []card) {
+	if c.store == nil {
+		return
+	}
+	for _, n := range next {
+		if n, ok := n.(*cffa1Card); ok && n.ImagePath == c.ImagePath && n.HostDir == c.HostDir {
+			n.store = c.store
+			n.needsFlush = c.needsFlush || c.wroteThisFrame
+			c.store = nil
+			return
+		}
+	}
+	if err := c.store.flush(); err != nil {
+		fmt.Println("cffa1:", err)
+	}
+	if err := c.store.close(); err != nil {
+		fmt.Println("cffa1:", err)
+	}
+	c.store = nil
+}
+
+func (c *cffa1Card) endFrame() {
+	if c.wroteThisFrame {
+		c.wroteThisFrame = false
+		c.needsFlush = true
+	} else if c.needsFlush {
+		// writes have gone quiet for a frame, so whatever was
+		// being saved is probably all there by now.
+		c.needsFlush = false
+		if err := c.store.flush(); err != nil {
+			fmt.Println("cffa1:", err)
+		}
+	}
+}
+
+func (c *cffa1Card) read(emu *emuState, addr uint16) byte {
+	if addr < cffa1RegsStart {
+		if val, ok := emu.Mem.romByte(addr); ok {
+			return val
+		}
+		return 0xff
+	}
+	switch addr & 0xf {
+	case cfRegDataHigh:
+		return c.DataHigh
+	case cfRegDevCtrl, cfRegStatus:
+		return c.Status
+	case cfRegData:
+		return c.readData()
+	case cfRegError:
+		return c.Error
+	case cfRegSectorCount:
+		return c.SectorCount
+	case cfRegLBA0, cfRegLBA1, cfRegLBA2:
+		return c.LBA[addr&0xf-cfRegLBA0]
+	case cfRegHead:
+		return c.Head
+	}
+	return 0xff
+}
+
+func (c *cffa1Card) write(emu *emuState, addr uint16, val byte) {
+	if addr < cffa1RegsStart {
+		return // firmware is read-only
+	}
+	switch addr & 0xf {
+	case cfRegDataHigh:
+		c.DataHigh = val
+	case cfRegSetCSMask, cfRegClearCSMask:
+		// only there to hide the 6502's dummy reads from the card
+	case cfRegDevCtrl:
+		c.DevCtrl = val
+		if val&cfDevCtrlSRST != 0 {
+			c.softReset()
+		}
+	case cfRegData:
+		c.writeData(val)
+	case cfRegError:
+		c.Features = val
+	case cfRegSectorCount:
+		c.SectorCount = val
+	case cfRegLBA0, cfRegLBA1, cfRegLBA2:
+		c.LBA[addr&0xf-cfRegLBA0] = val
+	case cfRegHead:
+		c.Head = val
+	case cfRegStatus:
+		c.command(val)
+	}
+}
+
+func (c *cffa1Card) softReset() {
+	c.Transfer = cfNoTransfer
+	c.Error = 0
+	c.SectorCount, c.LBA, c.Head = 1, [3]byte{1, 0, 0}, 0
+	c.Status = cfStatusDRDY | cfStatusDSC
+}
+
+func (c *cffa1Card) fail(errBits byte) {
+	c.Transfer = cfNoTransfer
+	c.Error = errBits
+	c.Status = cfStatusDRDY | cfStatusDSC | cfStatusERR
+}
+
+// startLBA decodes the task file's address, in LBA or CHS terms
+func (c *cffa1Card) startLBA() uint32 {
+	if c.Head&cfHeadLBA != 0 {
+		return uint32(c.Head&0xf)<<24 | uint32(c.LBA[2])<<16 | uint32(c.LBA[1])<<8 | uint32(c.LBA[0])
+	}
+	cyl := uint32(c.LBA[2])<<8 | uint32(c.LBA[1])
+	sector := uint32(c.LBA[0])
+	if sector == 0 {
+		sector = 1 // not legal, but don't wrap
+	}
+	return (cyl*cfHeads+uint32(c.Head&0xf))*cfSectorsPerTrack + sector - 1
+}
+
+func (c *cffa1Card) command(cmd byte) {
+	c.Error = 0
+	c.Transfer = cfNoTransfer
+	c.Status = cfStatusDRDY | cfStatusDSC
+
+	switch cmd {
+	case cfCmdIdentify:
+		c.identify()
+		c.SectorsLeft = 0
+		c.startTransfer(cfReading)
+	case cfCmdReadSectors, cfCmdReadSectorsNoRetry, cfCmdWriteSectors, cfCmdWriteSectorsNoRetry:
+		count := int(c.SectorCount)
+		if count == 0 {
+			count = 256
+		}
+		c.TransferLBA = c.startLBA()
+		if uint64(c.TransferLBA)+uint64(count) > uint64(c.store.numBlocks()) {
+			c.fail(cfErrorIDNF)
+			return
+		}
+		c.SectorsLeft = count - 1
+		if cmd == cfCmdReadSectors || cmd == cfCmdReadSectorsNoRetry {
+			if c.loadSector() {
+				c.startTransfer(cfReading)
+			}
+		} else {
+			c.startTransfer(cfWriting)
+		}
+	case cfCmdInitParams, cfCmdSetFeatures:
+		// nothing to set up
+	default:
+		if cmd&0xf0 == 0x10 {
+			// recalibrate
+			return
+		}
+		c.fail(cfErrorABRT)
+	}
+}
+
+func (c *cffa1Card) startTransfer(kind int) {
+	c.Transfer = kind
+	c.BufferPos = 0
+	c.Status |= cfStatusDRQ
+}
+
+func (c *cffa1Card) loadSector() bool {
+	if err := c.store.readBlock(c.TransferLBA, c.Buffer[:]); err != nil {
+		fmt.Println("cffa1:", err)
+		c.fail(cfErrorIDNF)
+		return false
+	}
+	return true
+}
+
+func (c *cffa1Card) readData() byte {
+	if c.Transfer != cfReading {
+		return 0xff
+	}
+	val := c.Buffer[c.BufferPos]
+	c.DataHigh = c.Buffer[c.BufferPos+1]
+	c.BufferPos += 2
+	if c.BufferPos >= len(c.Buffer) {
+		if c.SectorsLeft == 0 {
+			c.Transfer = cfNoTransfer
+			c.Status &^= cfStatusDRQ
+		} else {
+			c.SectorsLeft--
+			c.TransferLBA++
+			if c.loadSector() {
+				c.BufferPos = 0
+			}
+		}
+	}
+	return val
+}
+
+func (c *cffa1Card) writeData(val byte) {
+	if c.Transfer != cfWriting {
+		return
+	}
+	c.Buffer[c.BufferPos] = val
+	c.Buffer[c.BufferPos+1] = c.DataHigh
+	c.BufferPos += 2
+	if c.BufferPos >= len(c.Buffer) {
+		if err := c.store.writeBlock(c.TransferLBA, c.Buffer[:]); err != nil {
+			fmt.Println("cffa1:", err)
+			c.fail(cfErrorABRT)
+			return
+		}
+		c.wroteThisFrame = true
+		if c.SectorsLeft == 0 {
+			c.Transfer = cfNoTransfer
+			c.Status &^= cfStatusDRQ
+		} else {
+			c.SectorsLeft--
+			c.TransferLBA++
+			c.BufferPos = 0
+		}
+	}
+}
+
+func (c *cffa1Card) identify() {
+	c.Buffer = [prodosBlockSize]byte{}
+	setWord := func(i int, val uint16) {
+		binary.LittleEndian.PutUint16(c.Buffer[i*2:], val)
+	}
+	// ATA strings put the first char of each pair in the high byte
+	setString := func(firstWord, nWords int, s string) {
+		padded := []byte(fmt.Sprintf("%-*s", nWords*2, s))
+		for i := 0; i < nWords*2; i += 2 {
+			c.Buffer[firstWord*2+i] = padded[i+1]
+			c.Buffer[firstWord*2+i+1] = padded[i]
+		}
+	}
+	sectors := c.store.numBlocks()
+	cyls := sectors / (cfHeads * cfSectorsPerTrack)
+	if cyls > 0xffff {
+		cyls = 0xffff
+	}
+	setWord(0, 0x848a) // CompactFlash
+	setWord(1, uint16(cyls))
+	setWord(3, cfHeads)
+	setWord(6, cfSectorsPerTrack)
+	setString(10, 10, "A1GO")
+	setString(23, 4, "1.0")
+	setString(27, 20, "A1GO VIRTUAL CF")
+	setWord(47, 1)
+	setWord(49, 0x0200) // LBA supported
+	setWord(53, 1)
+	setWord(54, uint16(cyls))
+	setWord(55, cfHeads)
+	setWord(56, cfSectorsPerTrack)
+	setWord(57, uint16(sectors))
+	setWord(58, uint16(sectors>>16))
+	setWord(60, uint16(sectors))
+	setWord(61, uint16(sectors>>16))
+}
+
+// blockStore is the storage behind the CF card
+type blockStore interface {
+	numBlocks() uint32
+	readBlock(lba uint32, buf []byte) error
+	writeBlock(lba uint32, buf []byte) error
+	// flush is called once writes have gone quiet
+	flush() error
+	close() error
+}
+
+// imageStore is a ProDOS-order disk image: .po, .hdv, or .2mg
+type imageStore struct {
+	f      *os.File
+	offset int64
+	blocks uint32
+}
+
+func openImageStore(path string) (*imageStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s := &imageStore{f: f, blocks: uint32(info.Size() / prodosBlockSize)}
+
+	hdr := make([]byte, 64)
+	if _, err := f.ReadAt(hdr, 0); err == nil && string(hdr[:4]) == "2IMG" {
+		if format := binary.LittleEndian.Uint32(hdr[0x0c:]); format != 1 {
+			f.Close()
+			return nil, fmt.Errorf("%v: only ProDOS-order 2mg images work on a CF card", path)
+		}
+		s.offset = int64(binary.LittleEndian.Uint32(hdr[0x18:]))
+		s.blocks = binary.LittleEndian.Uint32(hdr[0x1c:]) / prodosBlockSize
+	}
+	if s.blocks == 0 {
+		f.Close()
+		return nil, fmt.Errorf("%v: image is empty", path)
+	}
+	return s, nil
+}
+
+func (s *imageStore) numBlocks() uint32 { return s.blocks }
+
+func (s *imageStore) readBlock(lba uint32, buf []byte) error {
+	_, err := s.f.ReadAt(buf[:prodosBlockSize], s.offset+int64(lba)*prodosBlockSize)
+	return err
+}
+
+func (s *imageStore) writeBlock(lba uint32, buf []byte) error {
+	_, err := s.f.WriteAt(buf[:prodosBlockSize], s.offset+int64(lba)*prodosBlockSize)
+	return err
+}
+
+func (s *imageStore) flush() error {
+	return s.f.Sync()
+}
+
+func (s *imageStore) close() error {
+	return s.f.Close()
+}
+
+const (
+	dirStoreMinBlocks = 16384 // 8MB
+	dirStoreMaxBlocks = 65535
+)
+
+// dirStore is a host dir turned into an in-memory ProDOS volume. Files
+// saved on the Apple-1 side are written back to the dir on flush, named
+// like HELLO#F80800 to keep their ProDOS type and aux type. Deleting a
+// file on the Apple-1 side leaves the host's copy alone.
+type dirStore struct {
+	dir string
+	img []byte
+	// hostNames maps ProDOS names back to the host files they came from
+	hostNames map[string]string
+}
+
+func openDirStore(dir string) (*dirStore, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &dirStore{dir: dir, hostNames: map[string]string{}}
+	files := []prodosFile{}
+	blocksNeeded := prodosBitmapBlock + dirStoreMaxBlocks/4096 + 1
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		name, fileTyp, auxType := parseHostName(entry.Name())
+		for i := 2; s.hostNames[name] != ""; i++ {
+			suffix := fmt.Sprint(i)
+			base := name
+			if len(base)+len(suffix) > prodosMaxNameLen {
+				base = base[:prodosMaxNameLen-len(suffix)]
+			}
+			name = base + suffix
+		}
+		s.hostNames[name] = entry.Name()
+		files = append(files, prodosFile{
+			name:    name,
+			fileTyp: fileTyp,
+			auxType: auxType,
+			modTime: entry.ModTime(),
+			data:    data,
+		})
+		blocksNeeded += len(data)/prodosBlockSize + 2 + len(data)/(256*prodosBlockSize)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	totalBlocks := blocksNeeded + dirStoreMinBlocks
+	if totalBlocks > dirStoreMaxBlocks {
+		totalBlocks = dirStoreMaxBlocks
+	}
+	volName := filepath.Base(filepath.Clean(dir))
+	if s.img, err = buildProdosVolume(volName, files, totalBlocks); err != nil {
+		return nil, fmt.Errorf("%v: %v", dir, err)
+	}
+	return s, nil
+}
+
+func (s *dirStore) numBlocks() uint32 { return uint32(len(s.img) / prodosBlockSize) }
+
+func (s *dirStore) readBlock(lba uint32, buf []byte) error {
+	copy(buf, s.img[lba*prodosBlockSize:])
+	return nil
+}
+
+func (s *dirStore) writeBlock(lba uint32, buf []byte) error {
+	copy(s.img[lba*prodosBlockSize:(lba+1)*prodosBlockSize], buf)
+	return nil
+}
+
+func (s *dirStore) flush() error {
+	files, err := readProdosFiles(s.img)
+	if err != nil {
+		return fmt.Errorf("could not export volume to %v: %v", s.dir, err)
+	}
+	var badName error
+	for _, f := range files {
+		hostName, ok := s.hostNames[f.name]
+		if !ok {
+			hostName = f.hostName()
+			// the name comes from the guest's directory, which could
+			// hold anything, so keep it from escaping the dir
+			if !isPlainFileName(hostName) {
+				if badName == nil {
+					badName = fmt.Errorf("not exporting %q, it isn't a plain file name", f.name)
+				}
+				continue
+			}
+			s.hostNames[f.name] = hostName
+		}
+		path := filepath.Join(s.dir, hostName)
+		if old, err := ioutil.ReadFile(path); err == nil && bytes.Equal(old, f.data) {
+			continue
+		}
+		if err := ioutil.WriteFile(path, f.data, 0644); err != nil {
+			return err
+		}
+	}
+	return badName
+}
+
+// close has nothing to do, the volume only lives in memory
+func (s *dirStore) close() error { return nil }
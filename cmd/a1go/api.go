@@ -0,0 +1,320 @@
+package main
+
+import (
+	"github.com/theinternetftw/a1go"
+
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The control api lets test tooling in any language drive a running
+// emulator over http. Everything is JSON, except screenshots (PNG) and
+// snapshots (the same bytes F4 saves). Addresses are hex strings.
+
+const apiMaxBodyLen = 16 << 20
+
+type apiStatus struct {
+	Paused    bool
+	Cycles    uint64
+	ClockHz   int
+	CPU       a1go.CPUType
+	Registers a1go.Registers
+}
+
+type apiMem struct {
+	Addr string
+	Data []int
+}
+
+type apiText struct {
+	Text string
+}
+
+func startAPI(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", apiIndex)
+	mux.HandleFunc("/status", apiOnly("GET", apiGetStatus))
+	mux.HandleFunc("/type", apiOnly("POST", apiType))
+	mux.HandleFunc("/reset", apiOnly("POST", apiButton(func(in *a1go.Input) { in.ResetButton = true })))
+	mux.HandleFunc("/clear", apiOnly("POST", apiButton(func(in *a1go.Input) { in.ClearScreenButton = true })))
+	mux.HandleFunc("/mem", apiMemHandler)
+	mux.HandleFunc("/registers", apiOnly("GET", apiGetRegisters))
+	mux.HandleFunc("/screen", apiOnly("GET", apiGetScreen))
+	mux.HandleFunc("/screenshot", apiOnly("GET", apiGetScreenshot))
+	mux.HandleFunc("/snapshot", apiSnapshotHandler)
+	mux.HandleFunc("/pause", apiOnly("POST", apiSetPaused(true)))
+	mux.HandleFunc("/resume", apiOnly("POST", apiSetPaused(false)))
+	mux.HandleFunc("/step", apiOnly("POST", apiStep))
+
+	listener, err := net.Listen("tcp", addr)
+	dieIf(err)
+	fmt.Printf("control api at http://%v/\n", listener.Addr())
+	go func() {
+		dieIf(http.Serve(listener, mux))
+	}()
+}
+
+const apiUsage = `a1go control api
+
+GET  /status                   paused, cycle count, clock, cpu and registers
+POST /type      {"Text": "E000R\r"}   type text, as if by autokey
+POST /reset                    press reset
+POST /clear                    press clear screen
+GET  /mem?addr=0300&len=16     read memory (no side effects)
+POST /mem       {"Addr": "0300", "Data": [169, 0]}   write memory
+GET  /registers                cpu registers
+GET  /screen                   screen text
+GET  /screenshot?scale=2       screen as a PNG
+GET  /snapshot                 make a snapshot
+POST /snapshot  (snapshot)     load a snapshot
+POST /pause, /resume           stop and start the clock
+POST /step?n=1                 pause, then run n instructions
+`
+
+func apiIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		apiError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %v, see /", r.URL.Path))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, apiUsage)
+}
+
+// apiOnly wraps a handler so it only answers one method
+func apiOnly(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%v only", method))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func apiError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"Error": err.Error()})
+}
+
+func apiReply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiReadJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(io.LimitReader(r.Body, apiMaxBodyLen)).Decode(v); err != nil {
+		return fmt.Errorf("bad request body: %v", err)
+	}
+	return nil
+}
+
+func parseHexAddr(s string) (uint16, error) {
+	addr, err := strconv.ParseUint(strings.TrimPrefix(s, "$"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad addr %q, want hex like 0300", s)
+	}
+	return uint16(addr), nil
+}
+
+func apiGetStatus(w http.ResponseWriter, r *http.Request) {
+	var status apiStatus
+	callEmu(func(loop *emuLoop) {
+		status = apiStatus{
+			Paused:    loop.paused,
+			Cycles:    loop.emu.CycleCount(),
+			ClockHz:   loop.emu.ClockHz(),
+			CPU:       loop.emu.CPUType(),
+			Registers: loop.emu.Registers(),
+		}
+	})
+	apiReply(w, status)
+}
+
+func apiType(w http.ResponseWriter, r *http.Request) {
+	var text apiText
+	if err := apiReadJSON(r, &text); err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	callEmu(func(loop *emuLoop) {
+		loop.emu.Type([]byte(text.Text))
+	})
+	apiReply(w, struct{}{})
+}
+
+// apiButton presses a button, on top of whatever keys the frontend has down
+func apiButton(press func(in *a1go.Input)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		callEmu(func(loop *emuLoop) {
+			input := loop.cmd.input
+			press(&input)
+			loop.emu.UpdateInput(input)
+			loop.emu.UpdateInput(loop.cmd.input)
+		})
+		apiReply(w, struct{}{})
+	}
+}
+
+func apiMemHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		addr, err := parseHexAddr(r.URL.Query().Get("addr"))
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		length := 1
+		if lenStr := r.URL.Query().Get("len"); lenStr != "" {
+			length, err = strconv.Atoi(lenStr)
+			if err != nil || length < 1 || int(addr)+length > 0x10000 {
+				apiError(w, http.StatusBadRequest, fmt.Errorf("bad len %q", lenStr))
+				return
+			}
+		}
+		mem := apiMem{Addr: fmt.Sprintf("%04X", addr), Data: make([]int, length)}
+		callEmu(func(loop *emuLoop) {
+			for i := range mem.Data {
+				mem.Data[i] = int(loop.emu.ReadMem(addr + uint16(i)))
+			}
+		})
+		apiReply(w, mem)
+	case "POST":
+		var mem apiMem
+		if err := apiReadJSON(r, &mem); err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		addr, err := parseHexAddr(mem.Addr)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		if int(addr)+len(mem.Data) > 0x10000 {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("data runs past $FFFF"))
+			return
+		}
+		for _, b := range mem.Data {
+			if b < 0 || b > 255 {
+				apiError(w, http.StatusBadRequest, fmt.Errorf("bad byte %v", b))
+				return
+			}
+		}
+		callEmu(func(loop *emuLoop) {
+			for i, b := range mem.Data {
+				loop.emu.WriteMem(addr+uint16(i), byte(b))
+			}
+		})
+		apiReply(w, struct{}{})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("GET or POST only"))
+	}
+}
+
+func apiGetRegisters(w http.ResponseWriter, r *http.Request) {
+	var regs a1go.Registers
+	callEmu(func(loop *emuLoop) {
+		regs = loop.emu.Registers()
+	})
+	apiReply(w, regs)
+}
+
+func apiGetScreen(w http.ResponseWriter, r *http.Request) {
+	var text apiText
+	callEmu(func(loop *emuLoop) {
+		text.Text = loop.emu.ScreenText()
+	})
+	apiReply(w, text)
+}
+
+func apiGetScreenshot(w http.ResponseWriter, r *http.Request) {
+	scale := 1
+	if scaleStr := r.URL.Query().Get("scale"); scaleStr != "" {
+		var err error
+		if scale, err = strconv.Atoi(scaleStr); err != nil {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("bad scale %q", scaleStr))
+			return
+		}
+	}
+	var png bytes.Buffer
+	var err error
+	callEmu(func(loop *emuLoop) {
+		err = loop.emu.Screenshot(&png, scale)
+	})
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png.Bytes())
+}
+
+func apiSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		var snapshot []byte
+		callEmu(func(loop *emuLoop) {
+			snapshot = loop.emu.MakeSnapshot()
+		})
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(snapshot)
+	case "POST":
+		snapshot, err := ioutil.ReadAll(io.LimitReader(r.Body, apiMaxBodyLen))
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		callEmu(func(loop *emuLoop) {
+			var newEmu a1go.Emulator
+			if newEmu, err = loop.emu.LoadSnapshot(snapshot); err == nil {
+				loop.setEmu(newEmu)
+			}
+		})
+		if err != nil {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("failed to load snapshot: %v", err))
+			return
+		}
+		apiReply(w, struct{}{})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		apiError(w, http.StatusMethodNotAllowed, fmt.Errorf("GET or POST only"))
+	}
+}
+
+func apiSetPaused(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		callEmu(func(loop *emuLoop) {
+			loop.paused = paused
+		})
+		apiReply(w, struct{}{})
+	}
+}
+
+func apiStep(w http.ResponseWriter, r *http.Request) {
+	n := 1
+	if nStr := r.URL.Query().Get("n"); nStr != "" {
+		var err error
+		if n, err = strconv.Atoi(nStr); err != nil || n < 1 {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("bad n %q", nStr))
+			return
+		}
+	}
+	var regs a1go.Registers
+	callEmu(func(loop *emuLoop) {
+		loop.paused = true
+		for i := 0; i < n; i++ {
+			loop.emu.Step()
+		}
+		regs = loop.emu.Registers()
+	})
+	apiReply(w, regs)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/theinternetftw/a1go"
+
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func printMachine(machine a1go.Profile, emu a1go.Emulator) {
+	ram := []string{}
+	for _, r := range emu.RAMLayout() {
+		ram = append(ram, r.String())
+	}
+	fmt.Printf("machine: %v (%v at %.3fMHz, ram %v)\n",
+		machine.Name, emu.CPUType(), float64(emu.ClockHz())/1e6, strings.Join(ram, ","))
+}
+
+func printMachineInfo(profiles a1go.Profiles) {
+	fmt.Println("user machine profiles:", a1go.ProfilesPath())
+	fmt.Println("machines:")
+	for _, name := range profiles.Names() {
+		fmt.Println("  " + profiles[name].String())
+	}
+	fmt.Println("peripherals:")
+	for _, t := range a1go.PeripheralTypes() {
+		fmt.Printf("  %v: %v\n", t.Name, t.Description)
+		args := []string{}
+		for arg := range t.Args {
+			args = append(args, arg)
+		}
+		sort.Strings(args)
+		for _, arg := range args {
+			fmt.Printf("    %v: %v\n", arg, t.Args[arg])
+		}
+	}
+}
+
+// cffa1Config backs a CFFA1 with a dir if path is one, or an image if not
+func cffa1Config(path string) a1go.PeripheralConfig {
+	arg := "image"
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		arg = "dir"
+	}
+	return a1go.PeripheralConfig{Type: "cffa1", Args: map[string]string{arg: path}}
+}
+
+func hostSvcConfig(dir string) a1go.PeripheralConfig {
+	args := map[string]string{}
+	if dir != "" {
+		args["dir"] = dir
+	}
+	return a1go.PeripheralConfig{Type: "hostsvc", Args: args}
+}
+
+func serialConfig(cfg settings) a1go.PeripheralConfig {
+	return a1go.PeripheralConfig{Type: "acia", Args: map[string]string{
+		"link": cfg.serialLink,
+		"addr": cfg.serialAddr,
+		"irq":  strconv.FormatBool(cfg.serialIRQ),
+	}}
+}
@@ -3,174 +3,753 @@ package main
 import (
 	"github.com/theinternetftw/a1go"
 	"github.com/theinternetftw/a1go/profiling"
-	"github.com/theinternetftw/glimmer"
 
+	"bytes"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
+	"sort"
+	"strings"
+	"time"
 )
 
+// settings are the frontend's knobs, mostly from the command line
+type settings struct {
+	machine          string
+	machinesFilename string
+
+	cpu       string
+	clockHz   int
+	speed     float64
+	turbo     bool
+	frameSkip int
+
+	refreshStealing bool
+
+	basFilename    string
+	basOutFilename string
+
+	romSet          string
+	romSetChosen    bool
+	extraROMs       romFlags
+	monitorFilename string
+
+	cffa1Path string
+
+	serialLink string
+	serialAddr string
+	serialIRQ  bool
+
+	hostServices bool
+	hostDir      string
+
+	console  string
+	headless bool
+
+	frontend string
+	webAddr  string
+
+	apiAddr string
+
+	script *a1go.Script
+
+	screenshotScale int
+
+	transcriptFilename string
+
+	// theme is nil to leave the emu's default
+	theme *a1go.Theme
+
+	// crt is nil unless the window should look like a CRT
+	crt *a1go.CRTOptions
+
+	recordFilename string
+	recordFormat   string
+	recordScale    int
+	recordDups     bool
+
+	// snapshotPrefix and the other prefixes are named after the
+	// input file, see outputBaseFor
+	snapshotPrefix   string
+	screenshotPrefix string
+	recordingPrefix  string
+}
+
+// frontends run the emulator and show it to the user. Each gets its
+// own file, and "window" is only there in builds without the nogui tag.
+var frontends = map[string]func(emu a1go.Emulator, cfg settings){
+	"term": runTerm,
+	"web":  runWeb,
+}
+
+func defaultFrontend() string {
+	if _, ok := frontends["window"]; ok {
+		return "window"
+	}
+	return "term"
+}
+
+func frontendNames() string {
+	names := []string{}
+	for name := range frontends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
 func main() {
 
 	defer profiling.Start().Stop()
 
-	assert(len(os.Args) == 2 || len(os.Args) == 1, "usage: ./a1go [INPUT_FILENAME]")
+	var cfg settings
+	flag.StringVar(&cfg.machine, "machine", a1go.DefaultProfileName, "machine profile to emulate (see -list-machines)")
+	flag.StringVar(&cfg.machinesFilename, "machines", "", "json file of extra machine profiles (also read from "+a1go.ProfilesPath()+")")
+	listMachines := flag.Bool("list-machines", false, "print the known machine profiles and peripherals, then exit")
+	flag.StringVar(&cfg.cpu, "cpu", "", "cpu core, overriding the machine's: 6502 (NMOS, like a real apple-1) or 65c02 (like the Replica-1 and most clones)")
+	flag.IntVar(&cfg.clockHz, "clock", 0, "cpu clock in Hz, overriding the machine's, e.g. 2000000 for a 2MHz clone")
+	flag.Float64Var(&cfg.speed, "speed", 1, "speed multiplier, e.g. 0.25, 2, 10")
+	flag.BoolVar(&cfg.turbo, "turbo", false, "start with turbo (F11) on")
+	flag.IntVar(&cfg.frameSkip, "frameskip", 0, "emulated frames to skip between draws (0 draws whenever the display is ready for one)")
+	flag.BoolVar(&cfg.refreshStealing, "refresh-stealing", false, "stall the cpu for DRAM refresh like a real board (~0.96MHz effective)")
+	flag.StringVar(&cfg.basFilename, "bas", "", "integer BASIC program text to load straight into memory (needs a rom set with BASIC)")
+	flag.StringVar(&cfg.romSet, "romset", "", "named set of roms to load, overriding the machine's (see -list-roms)")
+	flag.Var(&cfg.extraROMs, "rom", "extra rom image to load, as FILE or FILE@ADDR, e.g. mymon.bin@FF00 (repeatable)")
+	flag.StringVar(&cfg.monitorFilename, "monitor", "", "replacement monitor rom, ending at $FFFF (256 bytes at $FF00, up to 4K at $F000)")
+	flag.StringVar(&cfg.cffa1Path, "cffa1", "", "add a CFFA1 CompactFlash card, backed by this ProDOS disk image or host dir (needs the CFFA1 rom)")
+	flag.StringVar(&cfg.serialLink, "serial", "", "add a 6850 ACIA serial card, bridged to "+a1go.HostLinkSpecHelp)
+	flag.StringVar(&cfg.serialAddr, "serial-addr", "C200", "hex address of the serial card")
+	flag.BoolVar(&cfg.serialIRQ, "serial-irq", false, "wire the serial card to the cpu's IRQ line")
+	flag.BoolVar(&cfg.hostServices, "host-services", false, "add the host services device at $C300, for guest code to print to stdout, get the time, and exit")
+	flag.StringVar(&cfg.hostDir, "host-dir", "", "host dir the host services device can open files in (implies -host-services)")
+	flag.StringVar(&cfg.console, "console", "", "also bridge the keyboard and display to "+a1go.HostLinkSpecHelp)
+	flag.BoolVar(&cfg.headless, "headless", false, "run without a window, just the -console, -api and/or -script")
+	flag.StringVar(&cfg.frontend, "frontend", defaultFrontend(), "how to show the emulator: "+frontendNames()+" (term is ANSI text in this terminal, web serves a page at -web-addr)")
+	flag.StringVar(&cfg.webAddr, "web-addr", "localhost:6580", "where the web frontend listens")
+	scriptFilename := flag.String("script", "", "run this script of commands against the emulator (see -script-help)")
+	scriptHelp := flag.Bool("script-help", false, "describe the -script format, then exit")
+	fontFilename := flag.String("font", "", "draw the screen with this character generator instead of the 2513's: a raw char ROM dump, PNG glyph sheet or BDF font (see -font-help)")
+	fontHelp := flag.Bool("font-help", false, "describe the -font formats, then exit")
+	lowercase := flag.Bool("lowercase", false, "add the lowercase mod, so lowercase shows as lowercase, like some clones")
+	flag.StringVar(&cfg.apiAddr, "api", "", "serve the HTTP/JSON control api here, e.g. 127.0.0.1:6581")
+	flag.IntVar(&cfg.screenshotScale, "screenshot-scale", 2, "how many times bigger than the 240x192 screen F12 screenshots are")
+	themeSpec := flag.String("theme", "", "screen colors: white, green or amber (or p4, p1, p3), RRGGBB on black, or RRGGBB/RRGGBB (F3 cycles the stock ones)")
+	crtSpec := flag.String("crt", "", "make the window look like a CRT (window frontend only): on, or settings like scanlines=0.6,bloom=0.2,persistence=0.5,blur=0,scale=4 (each 0-1, unset ones keep their defaults)")
+	flag.StringVar(&cfg.transcriptFilename, "transcript", "", "append everything printed to this text file, as it's printed, so nothing's lost when it scrolls off")
+	flag.StringVar(&cfg.recordFilename, "record", "", "record from the start to this animated GIF, or asciinema cast if it ends in .cast (F8 starts and stops recording to timestamped files)")
+	flag.StringVar(&cfg.recordFormat, "record-format", "gif", "what F8 records: gif, or cast for an asciinema cast of what's printed")
+	flag.IntVar(&cfg.recordScale, "record-scale", 2, "how many times bigger than the 240x192 screen recordings are")
+	flag.BoolVar(&cfg.recordDups, "record-dups", false, "keep frames that don't change anything in recordings, instead of folding them into the one before")
+	listROMs := flag.Bool("list-roms", false, "print the rom search path, known roms and rom sets, then exit")
+	flag.StringVar(&cfg.basOutFilename, "bas-out", "", "where F7 writes a listing of the BASIC program in memory (default INPUT_FILENAME.listing.bas)")
+	listBasFilename := flag.String("list-bas", "", "print the BASIC program saved in this snapshot file, then exit")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: ./a1go [OPTIONS] [INPUT_FILENAME]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		cfg.romSetChosen = cfg.romSetChosen || f.Name == "romset" || f.Name == "machine"
+	})
+
+	assert(flag.NArg() <= 1, "usage: ./a1go [OPTIONS] [INPUT_FILENAME]")
+	assert(cfg.speed > 0, "speed must be positive")
+	assert(cfg.screenshotScale >= 1 && cfg.screenshotScale <= 16, "screenshot scale must be 1-16")
+	assert(cfg.recordScale >= 1 && cfg.recordScale <= 16, "recording scale must be 1-16")
+	assert(cfg.recordFormat == "gif" || cfg.recordFormat == "cast", "record format must be gif or cast")
+	runFrontend, ok := frontends[cfg.frontend]
+	assert(ok, fmt.Sprintf("unknown frontend %q, want one of: %v", cfg.frontend, frontendNames()))
 
-	var emu a1go.Emulator
+	if *scriptHelp {
+		fmt.Println("a1go scripts:", a1go.ScriptHelp)
+		return
+	}
+	if *fontHelp {
+		fmt.Println(a1go.FontFormatHelp)
+		return
+	}
+	if *listBasFilename != "" {
+		listBasicFromSnapshot(*listBasFilename)
+		return
+	}
+
+	romManager, err := a1go.NewROMManager()
+	dieIf(err)
+	if *listROMs {
+		printROMInfo(romManager)
+		return
+	}
+
+	profiles, err := a1go.LoadProfiles()
+	dieIf(err)
+	if cfg.machinesFilename != "" {
+		dieIf(profiles.LoadFile(cfg.machinesFilename))
+	}
+	if *listMachines {
+		printMachineInfo(profiles)
+		return
+	}
+	machine, err := profiles.Get(cfg.machine)
+	dieIf(err)
+
+	emuOpts := machine.Options()
+	if cfg.cpu != "" {
+		emuOpts.CPU = a1go.CPUType(strings.ToLower(cfg.cpu))
+	}
+	if cfg.clockHz != 0 {
+		emuOpts.ClockHz = cfg.clockHz
+	}
+	if cfg.romSet == "" {
+		cfg.romSet = machine.ROMSet
+	}
+	emuOpts.RefreshStealing = cfg.refreshStealing
+	emuOpts.Console = cfg.console
+	emuOpts.LowercaseMod = *lowercase
+	if *fontFilename != "" {
+		emuOpts.Font, err = a1go.LoadFont(*fontFilename)
+		dieIf(err)
+	}
+	assert(!cfg.headless || cfg.console != "" || cfg.apiAddr != "" || *scriptFilename != "", "-headless needs a -console, -api or -script to talk to")
+	if *scriptFilename != "" {
+		cfg.script, err = a1go.LoadScript(*scriptFilename)
+		dieIf(err)
+	}
+	if *themeSpec != "" {
+		theme, err := a1go.ParseTheme(*themeSpec)
+		dieIf(err)
+		cfg.theme = &theme
+	}
+	if *crtSpec != "" {
+		// only the window draws the screen as pixels, for the crt to work on
+		assert(!cfg.headless, "-crt needs a window, it can't be used with -headless")
+		assert(cfg.frontend == "window", fmt.Sprintf("-crt only works with the window frontend, not %q", cfg.frontend))
+		crt, err := a1go.ParseCRTOptions(*crtSpec)
+		dieIf(err)
+		cfg.crt = &crt
+	}
+	if cfg.cffa1Path != "" {
+		emuOpts.Peripherals = append(emuOpts.Peripherals, cffa1Config(cfg.cffa1Path))
+	}
+	if cfg.serialLink != "" {
+		emuOpts.Peripherals = append(emuOpts.Peripherals, serialConfig(cfg))
+	}
+	if cfg.hostServices || cfg.hostDir != "" {
+		emuOpts.Peripherals = append(emuOpts.Peripherals, hostSvcConfig(cfg.hostDir))
+	}
+	if !cfg.headless {
+		emuOpts.HostOutput = &statusWriter{prefix: "hostsvc: "}
+	}
 
 	romFilename := ""
-	if len(os.Args) == 2 {
-		romFilename = os.Args[1]
+	if flag.NArg() == 1 {
+		romFilename = flag.Arg(0)
 		inputBytes, err := ioutil.ReadFile(romFilename)
 		dieIf(err)
 
-		emu = a1go.NewEmulatorWithAutokeyInput(inputBytes)
+		emuOpts.AutokeyInput = inputBytes
+	}
+
+	var basBytes []byte
+	if cfg.basFilename != "" {
+		basBytes, err = ioutil.ReadFile(cfg.basFilename)
+		dieIf(err)
+		// warm start BASIC, so it keeps the program we load
+		emuOpts.AutokeyInput = append([]byte("E2B3R\r"), emuOpts.AutokeyInput...)
+	}
+
+	emu, err := a1go.NewEmulatorWithOptions(emuOpts)
+	dieIf(err)
+
+	printMachine(machine, emu)
+	loadedROMs := loadROMs(romManager, emu, cfg, emuOpts.Peripherals)
+
+	if basBytes != nil {
+		assert(hasBASIC(loadedROMs), "-bas needs BASIC, but no BASIC rom was loaded")
+		err := emu.LoadBasicProgram(basBytes)
+		dieIf(err)
+		fmt.Println("loaded", cfg.basFilename)
+	}
+
+	cfg.snapshotPrefix = outputBaseFor(romFilename) + ".snapshot"
+	cfg.screenshotPrefix = outputBaseFor(romFilename) + ".screenshot-"
+	cfg.recordingPrefix = outputBaseFor(romFilename) + ".recording-"
+	if cfg.basOutFilename == "" {
+		cfg.basOutFilename = outputBaseFor(romFilename) + ".listing.bas"
+	}
+
+	if cfg.theme != nil {
+		emu.SetTheme(*cfg.theme)
+	}
+	if cfg.transcriptFilename != "" {
+		dieIf(startTranscript(emu, cfg.transcriptFilename))
+	}
+	if cfg.recordFilename != "" {
+		_, err := startRecording(emu, cfg, cfg.recordFilename)
+		dieIf(err)
+	}
+	if cfg.apiAddr != "" {
+		startAPI(cfg.apiAddr)
+	}
+	if cfg.headless && cfg.script != nil && cfg.console == "" && cfg.apiAddr == "" {
+		// nothing else to wait on, so no need for pacing
+		runScript(a1go.NewScriptRunner(cfg.script, emu), emu)
+	}
+	if cfg.headless {
+		// nothing sends cmds or takes frames, runEmu copes with both
+		runEmu(emu, cfg, make(chan emuCmd), make(chan emuFrame, 1))
+		return
+	}
+	runFrontend(emu, cfg)
+	exitProgram(0)
+}
+
+// emuCmd is everything a frontend sends over to the emu loop. The
+// window sends one every frame, others only when something changes,
+// so one-shot things like typed text are only acted on once.
+type emuCmd struct {
+	input     a1go.Input
+	hyperMode bool
+	speed     float64
+
+	// typed is text to type in, see a1go.Emulator.Type
+	typed []byte
+
+	// snapshotMode is 'm' to make a snapshot, 'l' to load one, or 'x' for neither
+	snapshotMode rune
+	snapshotNum  rune
+
+	listBasic  bool
+	screenshot bool
+	record     bool
+
+	// nextTheme switches to the next stock theme
+	nextTheme bool
+
+	// scroll is how many lines further back through the scrollback
+	// to look, or forward if negative. Pressing a key goes back to live.
+	scroll int
+}
+
+// scrollPage is how far PageUp and PageDown scroll, and
+// scrollWheelLines how far a notch of the mouse wheel does
+const (
+	scrollPage       = 20
+	scrollWheelLines = 3
+)
+
+// emuFrame is a drawn frame, as pixels and as text, and the
+// theme it's in, for frontends that color the text themselves
+type emuFrame struct {
+	pix   []byte
+	text  string
+	theme a1go.Theme
+}
+
+var speedPresets = []float64{0.25, 0.5, 1, 2, 4, 10}
+
+func nextSpeedPreset(speed float64, faster bool) float64 {
+	if faster {
+		for _, p := range speedPresets {
+			if p > speed {
+				return p
+			}
+		}
 	} else {
-		emu = a1go.NewEmulator()
-	}
-
-	execPath, err := os.Executable()
-	if err == nil {
-		execDir := path.Dir(execPath)
-		basicPath := path.Join(execDir, "roms", "basic.bin")
-		basicBytes, err := ioutil.ReadFile(basicPath)
-		if err == nil {
-			err := emu.LoadBinaryToMem(0xe000, basicBytes)
-			if err != nil {
-				dieIf(fmt.Errorf("err when loading basic.bin: %v", err))
-			} else {
-				fmt.Println("loaded basic!")
+		for i := len(speedPresets) - 1; i >= 0; i-- {
+			if speedPresets[i] < speed {
+				return speedPresets[i]
 			}
+		}
+	}
+	return speed
+}
+
+// outputBaseFor is what files made while running romFilename are named after
+func outputBaseFor(romFilename string) string {
+	if romFilename == "" {
+		return "algo"
+	}
+	return romFilename
+}
+
+// exitProgram finishes any recording, then exits
+func exitProgram(code int) {
+	stopRecording()
+	exitNow(code)
+}
+
+// exitNow exits. Frontends that need to clean up first swap it out.
+var exitNow = os.Exit
+
+// showStatus reports what the frontend keys did. Frontends that
+// own the whole terminal swap it out.
+var showStatus = func(msg string) {
+	fmt.Println(msg)
+}
+
+// statusWriter passes what's written to it to showStatus a line at a
+// time, so guest output doesn't scribble over the frontend
+type statusWriter struct {
+	prefix string
+	line   []byte
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' {
+			showStatus(w.prefix + string(w.line))
+			w.line = w.line[:0]
 		} else {
-			fmt.Println("could not auto-load basic:", err)
+			w.line = append(w.line, b)
 		}
-	} else {
-		fmt.Println("could not find executable path:", err)
-	}
-
-	screenW := 240
-	screenH := 192
-	glimmer.InitDisplayLoop(glimmer.InitDisplayLoopOptions{
-		WindowTitle:  "a1go",
-		WindowWidth:  screenW*2 + 40,
-		WindowHeight: screenH*2 + 40,
-		RenderWidth:  screenW,
-		RenderHeight: screenH,
-		InitCallback: func(sharedState *glimmer.WindowState) {
-			startEmu(sharedState, emu, romFilename)
-		},
-	})
+	}
+	return len(p), nil
 }
 
-func startEmu(window *glimmer.WindowState, emu a1go.Emulator, romFilename string) {
+func toggleTurbo(hyperMode bool) bool {
+	showStatus(fmt.Sprint("turbo: ", !hyperMode))
+	return !hyperMode
+}
 
-	frameTimer := glimmer.MakeFrameTimer()
+func changeSpeed(speed float64, faster bool) float64 {
+	speed = nextSpeedPreset(speed, faster)
+	showStatus(fmt.Sprintf("speed: %v%%", speed*100))
+	return speed
+}
 
-	if romFilename == "" {
-		romFilename = "algo"
+// emuLoop owns the emulator. It paces itself against the wall clock,
+// picking up new cmds from the frontend as they arrive, or, in
+// hyperMode, just runs as fast as it can. If the emulator is sitting
+// waiting for a keypress, there's no point in hyperMode, so it just
+// waits a frame for the next cmd.
+type emuLoop struct {
+	emu      a1go.Emulator
+	cfg      settings
+	throttle *a1go.Throttle
+	cmd      emuCmd
+
+	cmds <-chan emuCmd
+
+	// paused stops the clock, for the control api
+	paused bool
+
+	// script is nil unless running a -script
+	script *a1go.ScriptRunner
+
+	// scrollBack is how many lines back through the scrollback the
+	// screen shown is, zero for live, and shownScrollBack what was last drawn
+	scrollBack      int
+	shownScrollBack int
+}
+
+// emuCall is a fn for the emu loop to run between frames, for things
+// that need more than an emuCmd, like the control api. done is closed
+// once it has run.
+type emuCall struct {
+	fn   func(loop *emuLoop)
+	done chan struct{}
+}
+
+// emuCalls is how the control api gets at whatever emu loop is running
+var emuCalls = make(chan emuCall)
+
+// callEmu runs fn on the emu loop and waits for it to finish
+func callEmu(fn func(loop *emuLoop)) {
+	call := emuCall{fn: fn, done: make(chan struct{})}
+	emuCalls <- call
+	<-call.done
+}
+
+func runEmu(emu a1go.Emulator, cfg settings, cmds <-chan emuCmd, frames chan<- emuFrame) {
+
+	loop := emuLoop{
+		cfg:  cfg,
+		cmd:  emuCmd{hyperMode: cfg.turbo, speed: cfg.speed},
+		cmds: cmds,
+	}
+	loop.setEmu(emu)
+	watchRecordingOutput(emu, func() a1go.Emulator { return loop.emu })
+	if cfg.script != nil {
+		loop.script = a1go.NewScriptRunner(cfg.script, emu)
 	}
-	snapshotPrefix := romFilename + ".snapshot"
-	snapInProgress := false
 
-	numDown := 'x'
-	lastNumDown := 'x'
-	snapshotMode := 'x'
+	framesSinceDraw := 0
 
 	for {
-		newInput := a1go.Input{}
-
-		hyperMode := false
+		if loop.paused {
+			loop.wait(nil)
+			loop.throttle.Reset(loop.emu.CycleCount())
+			continue
+		}
 
-		window.InputMutex.Lock()
-		{
+		loop.emu.RunFrame(loop.cmd.input)
+		if code, exited := loop.emu.ExitRequested(); exited {
+			exitProgram(code)
+		}
+		if loop.script != nil {
+			loop.updateScript()
+		}
 
-			switch {
-			case window.CodeIsDown(glimmer.KeyCodeF1):
-				newInput.ResetButton = true
-			case window.CodeIsDown(glimmer.KeyCodeF2):
-				newInput.ClearScreenButton = true
-			case window.CodeIsDown(glimmer.KeyCodeF11):
-				hyperMode = true
-			}
+		framesSinceDraw++
+		flip := loop.emu.FlipRequested()
+		if flip {
+			captureRecording(loop.emu)
+		}
+		redraw := flip || loop.scrollBack != loop.shownScrollBack
+		if redraw && framesSinceDraw > cfg.frameSkip && len(frames) == 0 {
+			framesSinceDraw = 0
+			frames <- loop.frame()
+		}
 
-			if window.CodeIsDown(glimmer.KeyCodeF4) {
-				snapshotMode = 'm'
-			} else if window.CodeIsDown(glimmer.KeyCodeF9) {
-				snapshotMode = 'l'
+		if loop.cmd.hyperMode {
+			if loop.emu.IsIdle() {
+				// still wake up every frame, as keys can
+				// come in from the console as well as cmds
+				loop.wait(time.After(time.Second / 60))
 			} else {
-				snapInProgress = false
+				loop.poll()
 			}
+			loop.throttle.Reset(loop.emu.CycleCount())
+			continue
+		}
 
-			numDown = 'x'
-			for r := '0'; r <= '9'; r++ {
-				if window.CharIsDown(r) {
-					numDown = r
-					break
-				}
-			}
-			if lastNumDown != 'x' {
-				if !window.CharIsDown(lastNumDown) {
-					lastNumDown = 'x'
-				}
+		for {
+			wait := loop.throttle.TimeUntil(loop.emu.CycleCount())
+			if loop.cmd.hyperMode || loop.paused || wait <= 0 {
+				break
 			}
+			loop.wait(time.After(wait))
+		}
+	}
+}
 
-			if snapshotMode == 'x' && lastNumDown == 'x' {
-				window.CopyKeyCharArray(newInput.Keys[:])
-				newInput.Keys['\r'] = window.CodeIsDown(glimmer.KeyCodeEnter)
-			}
+// frame is what the frontend should show: the screen, or the
+// scrollback while looking back through it
+func (loop *emuLoop) frame() emuFrame {
+	loop.shownScrollBack = loop.scrollBack
+	if loop.scrollBack > 0 {
+		return emuFrame{
+			pix:   loop.emu.ScrollbackFramebuffer(loop.scrollBack),
+			text:  loop.emu.ScrollbackText(loop.scrollBack),
+			theme: loop.emu.Theme(),
 		}
-		window.InputMutex.Unlock()
-
-		if numDown > '0' && numDown <= '9' {
-			snapFilename := snapshotPrefix + string(numDown)
-			if snapshotMode == 'm' {
-				if !snapInProgress {
-					snapInProgress = true
-					lastNumDown = numDown
-					snapshotMode = 'x'
-					snapshot := emu.MakeSnapshot()
-					if len(snapshot) > 0 {
-						ioutil.WriteFile(snapFilename, snapshot, os.FileMode(0644))
-					}
-					fmt.Println("writing snap to", snapFilename)
-				}
-			} else if snapshotMode == 'l' {
-				if !snapInProgress {
-					snapInProgress = true
-					lastNumDown = numDown
-					snapshotMode = 'x'
-					snapBytes, err := ioutil.ReadFile(snapFilename)
-					if err != nil {
-						fmt.Println("failed to load snapshot:", err)
-						continue
-					}
-					newEmu, err := emu.LoadSnapshot(snapBytes)
-					if err != nil {
-						fmt.Println("failed to load snapshot:", err)
-						continue
-					}
-					emu = newEmu
-				}
-			}
+	}
+	fb := loop.emu.Framebuffer()
+	pix := make([]byte, len(fb))
+	copy(pix, fb)
+	return emuFrame{pix: pix, text: loop.emu.ScreenText(), theme: loop.emu.Theme()}
+}
+
+// scrollBy moves through the scrollback, without
+// touching anything the emulated machine can see
+func (loop *emuLoop) scrollBy(lines int) {
+	back := loop.scrollBack + lines
+	if limit := loop.emu.ScrollbackLines(); back > limit {
+		back = limit
+	}
+	if back < 0 {
+		back = 0
+	}
+	if back == loop.scrollBack {
+		return
+	}
+	loop.scrollBack = back
+	if back == 0 {
+		showStatus("back to live view")
+	} else {
+		showStatus(fmt.Sprintf("scrollback: %v of %v lines back, any key returns", back, loop.emu.ScrollbackLines()))
+	}
+}
+
+// pressedKey says if newCmd presses a key or button that
+// wasn't down in old
+func pressedKey(old, newCmd emuCmd) bool {
+	if len(newCmd.typed) > 0 || newCmd.input.ResetButton || newCmd.input.ClearScreenButton {
+		return true
+	}
+	for i, down := range newCmd.input.Keys {
+		if down && !old.input.Keys[i] {
+			return true
 		}
+	}
+	return false
+}
 
-		emu.UpdateInput(newInput)
-		emu.Step()
+// updateScript runs the script along, and reports how it went once
+// it's done. Headless runs exit then, with a failing status if it failed.
+func (loop *emuLoop) updateScript() {
+	done, err := loop.script.Update(loop.emu)
+	if !done {
+		return
+	}
+	loop.script = nil
+	if err != nil {
+		showStatus(fmt.Sprint("script failed: ", err))
+	} else {
+		showStatus("script passed")
+	}
+	if loop.cfg.headless {
+		exitScript(err)
+	}
+}
 
+// runScript runs a script headless, as fast as possible, then exits
+func runScript(runner *a1go.ScriptRunner, emu a1go.Emulator) {
+	watchRecordingOutput(emu, func() a1go.Emulator { return emu })
+	var err error
+	for {
+		var done bool
+		if done, err = runner.Update(emu); done {
+			break
+		}
+		emu.RunFrame(a1go.Input{})
 		if emu.FlipRequested() {
-			frameTimer.MarkRenderComplete()
-			window.RenderMutex.Lock()
-			copy(window.Pix, emu.Framebuffer())
-			window.RenderMutex.Unlock()
+			captureRecording(emu)
+		}
+	}
+	if code, exited := emu.ExitRequested(); exited {
+		exitProgram(code)
+	}
+	if err != nil {
+		fmt.Println("script failed:", err)
+	} else {
+		fmt.Println("script passed")
+	}
+	exitScript(err)
+}
+
+func exitScript(err error) {
+	if err != nil {
+		exitProgram(1)
+	}
+	exitProgram(0)
+}
+
+// setEmu starts running emu, e.g. after a snapshot load
+func (loop *emuLoop) setEmu(emu a1go.Emulator) {
+	loop.emu = emu
+	loop.throttle = a1go.NewThrottle(emu.ClockHz())
+	loop.throttle.SetMultiplier(loop.cmd.speed, emu.CycleCount())
+}
+
+// wait handles the next cmd or call, or gives up when timeout fires
+func (loop *emuLoop) wait(timeout <-chan time.Time) {
+	select {
+	case newCmd := <-loop.cmds:
+		loop.handleCmd(newCmd)
+	case call := <-emuCalls:
+		call.fn(loop)
+		close(call.done)
+	case <-timeout:
+	}
+}
+
+// poll handles a cmd or call if there's one waiting
+func (loop *emuLoop) poll() {
+	select {
+	case newCmd := <-loop.cmds:
+		loop.handleCmd(newCmd)
+	case call := <-emuCalls:
+		call.fn(loop)
+		close(call.done)
+	default:
+	}
+}
 
-			if !hyperMode {
-				<-window.DrawNotifier
+func (loop *emuLoop) handleCmd(newCmd emuCmd) {
+	emu, cfg := loop.emu, loop.cfg
+	if loop.scrollBack > 0 && pressedKey(loop.cmd, newCmd) {
+		loop.scrollBy(-loop.scrollBack)
+	}
+	loop.cmd = newCmd
+	cmd := &loop.cmd
+	if cmd.speed != loop.throttle.Multiplier() {
+		loop.throttle.SetMultiplier(cmd.speed, emu.CycleCount())
+	}
+	if cmd.snapshotMode == 'm' || cmd.snapshotMode == 'l' {
+		snapFilename := cfg.snapshotPrefix + string(cmd.snapshotNum)
+		if cmd.snapshotMode == 'm' {
+			snapshot := emu.MakeSnapshot()
+			if len(snapshot) > 0 {
+				ioutil.WriteFile(snapFilename, snapshot, os.FileMode(0644))
+			}
+			showStatus(fmt.Sprint("writing snap to ", snapFilename))
+		} else {
+			if newEmu, err := loadSnapshot(emu, snapFilename); err != nil {
+				showStatus(fmt.Sprint("failed to load snapshot: ", err))
+			} else {
+				showStatus(fmt.Sprint("loaded snap from ", snapFilename))
+				loop.setEmu(newEmu)
+				emu = newEmu
 			}
-			frameTimer.MarkFrameComplete()
-			frameTimer.PrintStatsEveryXFrames(60 * 5)
 		}
+		cmd.snapshotMode = 'x'
+	}
+	if cmd.listBasic {
+		cmd.listBasic = false
+		if listing, err := emu.ListBasicProgram(); err != nil {
+			showStatus(fmt.Sprint("failed to list basic program: ", err))
+		} else if err := ioutil.WriteFile(cfg.basOutFilename, []byte(listing), os.FileMode(0644)); err != nil {
+			showStatus(fmt.Sprint("failed to write basic listing: ", err))
+		} else {
+			showStatus(fmt.Sprint("wrote basic listing to ", cfg.basOutFilename))
+		}
+	}
+	if cmd.nextTheme {
+		cmd.nextTheme = false
+		emu.SetTheme(a1go.NextTheme(emu.Theme()))
+		showStatus(fmt.Sprint("theme: ", emu.Theme().Name))
+	}
+	if cmd.scroll != 0 {
+		loop.scrollBy(cmd.scroll)
+		cmd.scroll = 0
+	}
+	if cmd.record {
+		cmd.record = false
+		toggleRecording(emu, cfg)
+	}
+	if cmd.screenshot {
+		cmd.screenshot = false
+		if filename, err := writeScreenshot(emu, cfg); err != nil {
+			showStatus(fmt.Sprint("failed to write screenshot: ", err))
+		} else {
+			showStatus(fmt.Sprint("wrote screenshot to ", filename))
+		}
+	}
+	if cmd.typed != nil {
+		emu.Type(cmd.typed)
+		cmd.typed = nil
+	}
+	// apply input right away, so short keypresses
+	// aren't lost while we wait on the throttle
+	emu.UpdateInput(cmd.input)
+}
+
+// writeScreenshot writes a PNG of the screen to a timestamped file
+func writeScreenshot(emu a1go.Emulator, cfg settings) (string, error) {
+	filename := cfg.screenshotPrefix + time.Now().Format("20060102-150405.000") + ".png"
+	buf := bytes.Buffer{}
+	if err := emu.Screenshot(&buf, cfg.screenshotScale); err != nil {
+		return "", err
+	}
+	return filename, ioutil.WriteFile(filename, buf.Bytes(), os.FileMode(0644))
+}
+
+func listBasicFromSnapshot(snapFilename string) {
+	emu, err := loadSnapshot(a1go.NewEmulator(), snapFilename)
+	dieIf(err)
+	listing, err := emu.ListBasicProgram()
+	dieIf(err)
+	fmt.Print(listing)
+}
+
+func loadSnapshot(emu a1go.Emulator, snapFilename string) (a1go.Emulator, error) {
+	snapBytes, err := ioutil.ReadFile(snapFilename)
+	if err != nil {
+		return nil, err
 	}
+	return emu.LoadSnapshot(snapBytes)
 }
 
 func assert(test bool, msg string) {
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func makeRaw(f *os.File) (func(), error) {
+	return nil, fmt.Errorf("the term frontend isn't supported on this os")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"github.com/theinternetftw/a1go"
+
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recording is a GIF or cast recording in progress. The emu loop
+// feeds it, but exiting can happen on any goroutine, so it's locked.
+var recording struct {
+	sync.Mutex
+	file *os.File
+	gif  *a1go.GIFRecorder
+	cast *a1go.CastRecorder
+}
+
+// startRecording starts recording emu to filename, as a cast if it
+// ends in .cast and a GIF otherwise. If filename is empty, it's a
+// timestamped file in the -record-format.
+func startRecording(emu a1go.Emulator, cfg settings, filename string) (string, error) {
+	recording.Lock()
+	defer recording.Unlock()
+	if recording.file != nil {
+		return "", fmt.Errorf("already recording to %v", recording.file.Name())
+	}
+	if filename == "" {
+		filename = cfg.recordingPrefix + time.Now().Format("20060102-150405.000") + "." + cfg.recordFormat
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".cast") {
+		title := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		recording.cast, err = a1go.NewCastRecorder(file, emu, title)
+	} else {
+		recording.gif, err = a1go.NewGIFRecorder(file, emu, cfg.recordScale, !cfg.recordDups)
+	}
+	if err != nil {
+		recording.gif, recording.cast = nil, nil
+		file.Close()
+		os.Remove(filename)
+		return "", err
+	}
+	recording.file = file
+	return filename, nil
+}
+
+func isRecording() bool {
+	recording.Lock()
+	defer recording.Unlock()
+	return recording.file != nil
+}
+
+// captureRecording adds a frame, if recording a GIF
+func captureRecording(emu a1go.Emulator) {
+	recording.Lock()
+	defer recording.Unlock()
+	if recording.gif == nil {
+		return
+	}
+	if err := recording.gif.Capture(emu); err != nil {
+		abortRecording(err)
+	}
+}
+
+// recordOutput adds a char written to the display, if recording a cast
+func recordOutput(emu a1go.Emulator, char byte) {
+	recording.Lock()
+	defer recording.Unlock()
+	if recording.cast == nil {
+		return
+	}
+	if err := recording.cast.Output(emu, char); err != nil {
+		abortRecording(err)
+	}
+}
+
+// watchRecordingOutput sends what emu displays to recordOutput, with
+// the emu that's current, as snapshot loads swap it out
+func watchRecordingOutput(emu a1go.Emulator, current func() a1go.Emulator) {
+	emu.WatchOutput(func(char byte) {
+		recordOutput(current(), char)
+	})
+}
+
+// abortRecording gives up on a recording after a write fails. The
+// recording lock must be held.
+func abortRecording(err error) {
+	recording.file.Close()
+	recording.file, recording.gif, recording.cast = nil, nil, nil
+	showStatus(fmt.Sprint("recording stopped: ", err))
+}
+
+// stopRecording finishes the recording, if there is one, and
+// says where it went
+func stopRecording() {
+	recording.Lock()
+	defer recording.Unlock()
+	if recording.file == nil {
+		return
+	}
+	var err error
+	var what string
+	if recording.gif != nil {
+		err = recording.gif.Close()
+		what = fmt.Sprintf("%v frames", recording.gif.Frames())
+	} else {
+		err = recording.cast.Close()
+		what = fmt.Sprintf("%v chars", recording.cast.Events())
+	}
+	if closeErr := recording.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		showStatus(fmt.Sprint("failed to write recording: ", err))
+	} else {
+		showStatus(fmt.Sprintf("wrote %v to %v", what, recording.file.Name()))
+	}
+	recording.file, recording.gif, recording.cast = nil, nil, nil
+}
+
+// toggleRecording starts or stops recording, for the record hotkey
+func toggleRecording(emu a1go.Emulator, cfg settings) {
+	if isRecording() {
+		stopRecording()
+		return
+	}
+	if filename, err := startRecording(emu, cfg, ""); err != nil {
+		showStatus(fmt.Sprint("failed to start recording: ", err))
+	} else {
+		showStatus(fmt.Sprint("recording to ", filename))
+	}
+}
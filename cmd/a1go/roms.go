@@ -0,0 +1,136 @@
+package main
+
+import (
+	"github.com/theinternetftw/a1go"
+
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// romFlags collects repeated -rom FILE[@ADDR] flags
+type romFlags []string
+
+func (r *romFlags) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *romFlags) Set(val string) error {
+	*r = append(*r, val)
+	return nil
+}
+
+func parseROMFlag(val string) (string, *uint16, error) {
+	at := strings.LastIndex(val, "@")
+	if at < 0 {
+		return val, nil, nil
+	}
+	addr, err := strconv.ParseUint(strings.TrimPrefix(val[at+1:], "$"), 16, 16)
+	if err != nil {
+		return "", nil, fmt.Errorf("bad rom addr in %q: %v", val, err)
+	}
+	addr16 := uint16(addr)
+	return val[:at], &addr16, nil
+}
+
+// loadROMs loads the rom set, any firmware the cards need, and any extra
+// roms, and reports what loaded. Missing roms in the default machine's
+// set are only warned about, as a stock apple-1 doesn't need anything
+// past the built-in monitor.
+func loadROMs(m *a1go.ROMManager, emu a1go.Emulator, cfg settings, peripherals []a1go.PeripheralConfig) []a1go.LoadedROM {
+	loaded, err := m.LoadSet(emu, cfg.romSet)
+	if err != nil {
+		if cfg.romSetChosen {
+			dieIf(err)
+		}
+		fmt.Println("warning:", err)
+	}
+	for _, p := range peripherals {
+		romName := peripheralROM(p.Type)
+		if romName == "" || isLoaded(loaded, romName) {
+			continue
+		}
+		l, err := m.LoadROM(emu, romName)
+		if err != nil {
+			fmt.Printf("warning: %v card has no firmware: %v\n", p.Type, err)
+			continue
+		}
+		loaded = append(loaded, l)
+	}
+	if cfg.monitorFilename != "" {
+		l, err := m.LoadMonitorFile(emu, cfg.monitorFilename)
+		dieIf(err)
+		// the set's own monitor is hidden under it, so isn't reported
+		loaded = append(dropCovered(loaded, l), l)
+	}
+	for _, romFlag := range cfg.extraROMs {
+		filename, addr, err := parseROMFlag(romFlag)
+		dieIf(err)
+		l, err := m.LoadFile(emu, filename, addr)
+		dieIf(err)
+		loaded = append(loaded, l)
+	}
+	fmt.Println("roms loaded:")
+	for _, l := range loaded {
+		fmt.Println("  " + l.String())
+	}
+	return loaded
+}
+
+func peripheralROM(peripheralType string) string {
+	for _, t := range a1go.PeripheralTypes() {
+		if t.Name == peripheralType {
+			return t.ROM
+		}
+	}
+	return ""
+}
+
+func isLoaded(loaded []a1go.LoadedROM, name string) bool {
+	for _, l := range loaded {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dropCovered drops the roms that lie wholly under top
+func dropCovered(loaded []a1go.LoadedROM, top a1go.LoadedROM) []a1go.LoadedROM {
+	kept := []a1go.LoadedROM{}
+	for _, l := range loaded {
+		if l.Addr < top.Addr || int(l.Addr)+l.Size > int(top.Addr)+top.Size {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+func hasBASIC(loaded []a1go.LoadedROM) bool {
+	for _, l := range loaded {
+		if l.BASIC {
+			return true
+		}
+	}
+	return false
+}
+
+func printROMInfo(m *a1go.ROMManager) {
+	fmt.Println("rom search path:")
+	for _, dir := range m.SearchPath {
+		fmt.Println("  " + dir)
+	}
+	fmt.Println("user manifest:", a1go.ManifestPath())
+	fmt.Println("known roms:")
+	for _, known := range m.Known {
+		sha1 := strings.Join(known.SHA1s, " or ")
+		if sha1 == "" {
+			sha1 = "(no sha1, matched by filename only)"
+		}
+		fmt.Printf("  %v at $%04X, files: %v, sha1: %v\n", known.Name, known.Addr, strings.Join(known.Files, ", "), sha1)
+	}
+	fmt.Println("rom sets:")
+	for _, name := range m.SetNames() {
+		fmt.Printf("  %v: %v\n", name, strings.Join(m.Sets[name], ", "))
+	}
+}
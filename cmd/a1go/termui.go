@@ -0,0 +1,308 @@
+package main
+
+import (
+	"github.com/theinternetftw/a1go"
+
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const termHelp = "F1 reset  F2 clear  F3 theme  F4/F9+1-9 save/load  F5/F6 speed  F8 rec  F11 turbo  F12 shot  PgUp/PgDn scroll  ^A q quit  ^A ? more"
+
+const termCtrlHelp = "^A then: r reset, c clear, s/l+1-9 save/load, -/+ speed, t turbo, b list BASIC, p screenshot, g record GIF, h theme, q quit, ^A a literal ^A"
+
+// termKeys maps the escape sequences terminals send for F-keys. xterm
+// and friends send the first set, the linux console the [[ ones.
+var termKeys = map[string]int{
+	"OP": 1, "OQ": 2, "OR": 3, "OS": 4,
+	"[11~": 1, "[12~": 2, "[13~": 3, "[14~": 4, "[15~": 5,
+	"[17~": 6, "[18~": 7, "[19~": 8, "[20~": 9, "[21~": 10,
+	"[23~": 11, "[24~": 12,
+	"[[A": 1, "[[B": 2, "[[C": 3, "[[D": 4, "[[E": 5,
+}
+
+// termScrollKeys maps PageUp and PageDown to how far they scroll back
+var termScrollKeys = map[string]int{
+	"[5~": scrollPage, "[6~": -scrollPage,
+}
+
+// termUI draws the screen with ANSI escapes and turns keys from
+// stdin into emuCmds, so a1go can be used over ssh with no display.
+type termUI struct {
+	cmds chan<- emuCmd
+	cmd  emuCmd
+
+	lines [24]string
+
+	// ctrlA is set after a ^A, and snapshotMode ('m' or 'l') after a
+	// save or load key, while waiting on the key that says what to do
+	ctrlA        bool
+	snapshotMode rune
+
+	// theme is what the screen's being drawn in
+	theme a1go.Theme
+}
+
+// termOut guards stdout: the draw loop and the emulator's status
+// messages both write escapes to it, from different goroutines
+var termOut sync.Mutex
+
+// termPrintf writes to the terminal in one piece, see termOut
+func termPrintf(format string, args ...interface{}) {
+	termOut.Lock()
+	defer termOut.Unlock()
+	fmt.Printf(format, args...)
+}
+
+func runTerm(emu a1go.Emulator, cfg settings) {
+	restore, err := makeRaw(os.Stdin)
+	dieIf(err)
+
+	exitNow = func(code int) {
+		termPrintf("\x1b[0m\x1b[?25h\x1b[27;1H\r\n")
+		restore()
+		os.Exit(code)
+	}
+	quit := func() {
+		exitProgram(0)
+	}
+	showStatus = func(msg string) {
+		termPrintf("\x1b[26;1H%v\x1b[K", msg)
+	}
+
+	cmds := make(chan emuCmd)
+	frames := make(chan emuFrame, 1)
+	ui := termUI{
+		cmds: cmds,
+		cmd:  emuCmd{hyperMode: cfg.turbo, speed: cfg.speed, snapshotMode: 'x'},
+	}
+	go runEmu(emu, cfg, cmds, frames)
+
+	keys := make(chan []byte)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- append([]byte{}, buf[:n]...)
+		}
+	}()
+
+	termPrintf("\x1b[2J\x1b[?25l\x1b[25;1H\x1b[7m%v\x1b[0m", termHelp)
+	for {
+		select {
+		case frame := <-frames:
+			if frame.theme != ui.theme {
+				ui.setTheme(frame.theme)
+			}
+			ui.draw(frame.text)
+		case k, ok := <-keys:
+			if !ok || !ui.handleKeys(k) {
+				quit()
+			}
+		}
+	}
+}
+
+// termThemeColors sets the terminal's colors to theme's, leaving
+// the terminal's own colors for the stock white on black
+func termThemeColors(theme a1go.Theme) string {
+	if theme == a1go.Themes[0] {
+		return "\x1b[0m"
+	}
+	fg, bg := theme.Foreground, theme.Background
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm", fg.R, fg.G, fg.B, bg.R, bg.G, bg.B)
+}
+
+// setTheme clears the screen in theme's colors, to be redrawn
+func (ui *termUI) setTheme(theme a1go.Theme) {
+	ui.theme = theme
+	ui.lines = [24]string{}
+	colors := termThemeColors(theme)
+	termPrintf("%v\x1b[2J\x1b[25;1H\x1b[7m%v\x1b[0m%v", colors, termHelp, colors)
+}
+
+// draw redraws the lines that changed since last time
+func (ui *termUI) draw(text string) {
+	buf := bytes.Buffer{}
+	for i, line := range strings.Split(text, "\n") {
+		if i >= len(ui.lines) || line == ui.lines[i] {
+			continue
+		}
+		ui.lines[i] = line
+		fmt.Fprintf(&buf, "\x1b[%d;1H%v\x1b[K", i+1, line)
+	}
+	termOut.Lock()
+	defer termOut.Unlock()
+	os.Stdout.Write(buf.Bytes())
+}
+
+func (ui *termUI) send() {
+	ui.cmds <- ui.cmd
+	ui.cmd.typed = nil
+	ui.cmd.listBasic = false
+	ui.cmd.screenshot = false
+	ui.cmd.record = false
+	ui.cmd.scroll = 0
+	ui.cmd.nextTheme = false
+	ui.cmd.snapshotMode = 'x'
+}
+
+// press sends a button press and its release
+func (ui *termUI) press(button *bool) {
+	*button = true
+	ui.send()
+	*button = false
+	ui.send()
+}
+
+// handleKeys acts on one read's worth of keys, returning
+// false when it's time to quit
+func (ui *termUI) handleKeys(keys []byte) bool {
+	typed := []byte{}
+	for len(keys) > 0 {
+		b := keys[0]
+		keys = keys[1:]
+
+		switch {
+		case ui.ctrlA:
+			ui.ctrlA = false
+			if b == 'q' {
+				return false
+			}
+			if b == 1 {
+				typed = append(typed, b)
+			} else {
+				ui.ctrlKey(b)
+			}
+		case ui.snapshotMode != 0:
+			if b >= '1' && b <= '9' {
+				ui.cmd.snapshotMode, ui.cmd.snapshotNum = ui.snapshotMode, rune(b)
+				ui.send()
+			} else {
+				showStatus("snapshot cancelled")
+			}
+			ui.snapshotMode = 0
+		case b == 1:
+			ui.ctrlA = true
+		case b == 0x1b && len(keys) > 0:
+			seqLen := termKeySeqLen(keys)
+			if seqLen == 0 {
+				typed = append(typed, b)
+			} else if fKey, ok := termKeys[string(keys[:seqLen])]; ok {
+				ui.fKey(fKey)
+			} else if lines, ok := termScrollKeys[string(keys[:seqLen])]; ok {
+				ui.cmd.scroll = lines
+				ui.send()
+			}
+			keys = keys[seqLen:]
+		case b == '\n':
+			typed = append(typed, '\r')
+		case b == 0x7f:
+			typed = append(typed, 8)
+		case b < 0x80:
+			typed = append(typed, b)
+		}
+	}
+	if len(typed) > 0 {
+		ui.cmd.typed = typed
+		ui.send()
+	}
+	return true
+}
+
+// termKeySeqLen finds the end of the escape sequence at
+// the start of keys, i.e. what comes after the ESC
+func termKeySeqLen(keys []byte) int {
+	switch {
+	case keys[0] == 'O' && len(keys) > 1:
+		return 2
+	case keys[0] != '[':
+		return 0 // a lone ESC, then whatever was typed next
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i] == '[' && i == 1 {
+			continue
+		}
+		// a final byte ends it
+		if keys[i] >= 0x40 && keys[i] <= 0x7e {
+			return i + 1
+		}
+	}
+	return len(keys)
+}
+
+func (ui *termUI) fKey(n int) {
+	switch n {
+	case 1:
+		ui.press(&ui.cmd.input.ResetButton)
+	case 2:
+		ui.press(&ui.cmd.input.ClearScreenButton)
+	case 4:
+		ui.askSnapshot('m')
+	case 9:
+		ui.askSnapshot('l')
+	case 5, 6:
+		ui.cmd.speed = changeSpeed(ui.cmd.speed, n == 6)
+		ui.send()
+	case 7:
+		ui.cmd.listBasic = true
+		ui.send()
+	case 3:
+		ui.cmd.nextTheme = true
+		ui.send()
+	case 8:
+		ui.cmd.record = true
+		ui.send()
+	case 11:
+		ui.cmd.hyperMode = toggleTurbo(ui.cmd.hyperMode)
+		ui.send()
+	case 12:
+		ui.cmd.screenshot = true
+		ui.send()
+	}
+}
+
+func (ui *termUI) ctrlKey(b byte) {
+	switch b {
+	case 'r':
+		ui.fKey(1)
+	case 'c':
+		ui.fKey(2)
+	case 's':
+		ui.fKey(4)
+	case 'l':
+		ui.fKey(9)
+	case '-':
+		ui.fKey(5)
+	case '+', '=':
+		ui.fKey(6)
+	case 'b':
+		ui.fKey(7)
+	case 't':
+		ui.fKey(11)
+	case 'h':
+		ui.fKey(3)
+	case 'g':
+		ui.fKey(8)
+	case 'p':
+		ui.fKey(12)
+	default:
+		showStatus(termCtrlHelp)
+	}
+}
+
+func (ui *termUI) askSnapshot(mode rune) {
+	ui.snapshotMode = mode
+	if mode == 'm' {
+		showStatus("save snapshot to slot 1-9?")
+	} else {
+		showStatus("load snapshot from slot 1-9?")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/theinternetftw/a1go"
+
+	"fmt"
+	"os"
+)
+
+// startTranscript appends everything emu prints to filename, as it's
+// printed, like a printer hooked up to the display. It's plain text,
+// with a newline for each CR, and no breaks where the display wraps.
+func startTranscript(emu a1go.Emulator, filename string) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	emu.WatchOutput(func(char byte) {
+		if file == nil {
+			return
+		}
+		if char == '\r' {
+			char = '\n'
+		}
+		// unbuffered, so nothing's lost however a1go exits
+		if _, err := file.Write([]byte{char}); err != nil {
+			showStatus(fmt.Sprint("transcript stopped: ", err))
+			file.Close()
+			file = nil
+		}
+	})
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"github.com/theinternetftw/a1go"
+
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"net/http"
+	"sync"
+)
+
+//go:embed web.html
+var webPage []byte
+
+// webMsg is what the page sends: a Type of "key" (with Text), "reset",
+// "clear", "save" or "load" (with Slot), "turbo", "speed" (with Faster),
+// "screenshot", "record", "listBasic", "theme" (to the next one), or "scroll" (with Lines,
+// back through the scrollback, or forward if negative)
+type webMsg struct {
+	Type   string
+	Text   string
+	Slot   int
+	Faster bool
+	Lines  int
+}
+
+// webUpdate is what the page is sent. Colors are CSS, from the theme.
+type webUpdate struct {
+	Screen     string `json:",omitempty"`
+	Status     string `json:",omitempty"`
+	Foreground string `json:",omitempty"`
+	Background string `json:",omitempty"`
+}
+
+// webUI serves a page that shows the screen as text, streamed over a
+// websocket, and sends keys and button presses back. Every page open
+// shares the one session.
+type webUI struct {
+	cmds chan<- emuCmd
+
+	// cmdMutex is held while sending cmds, which can show statuses,
+	// so the clients get a mutex of their own
+	cmdMutex sync.Mutex
+	cmd      emuCmd
+
+	clientsMutex sync.Mutex
+	screen       string
+	theme        a1go.Theme
+	clients      map[*wsConn]bool
+}
+
+func runWeb(emu a1go.Emulator, cfg settings) {
+	cmds := make(chan emuCmd)
+	frames := make(chan emuFrame, 1)
+	ui := &webUI{
+		cmds:    cmds,
+		cmd:     emuCmd{hyperMode: cfg.turbo, speed: cfg.speed, snapshotMode: 'x'},
+		clients: map[*wsConn]bool{},
+	}
+	showStatus = func(msg string) {
+		fmt.Println(msg)
+		ui.broadcast(webUpdate{Status: msg})
+	}
+	go runEmu(emu, cfg, cmds, frames)
+	go func() {
+		for frame := range frames {
+			ui.clientsMutex.Lock()
+			changed := frame.text != ui.screen
+			themeChanged := frame.theme != ui.theme
+			ui.screen, ui.theme = frame.text, frame.theme
+			ui.clientsMutex.Unlock()
+			if themeChanged {
+				ui.broadcast(webThemeUpdate(frame.theme))
+			}
+			if changed {
+				ui.broadcast(webUpdate{Screen: frame.text})
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webPage)
+	})
+	mux.HandleFunc("/ws", ui.serveWS)
+	fmt.Printf("web frontend at http://%v/\n", cfg.webAddr)
+	dieIf(http.ListenAndServe(cfg.webAddr, mux))
+}
+
+func (ui *webUI) serveWS(w http.ResponseWriter, r *http.Request) {
+	ws, err := wsUpgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	ui.clientsMutex.Lock()
+	ui.clients[ws] = true
+	screen, theme := ui.screen, ui.theme
+	ui.clientsMutex.Unlock()
+	defer func() {
+		ui.clientsMutex.Lock()
+		delete(ui.clients, ws)
+		ui.clientsMutex.Unlock()
+	}()
+	ui.send(ws, webThemeUpdate(theme))
+	ui.send(ws, webUpdate{Screen: screen})
+
+	for {
+		msgBytes, err := ws.readMessage()
+		if err != nil {
+			return
+		}
+		var msg webMsg
+		if err := json.Unmarshal(msgBytes, &msg); err != nil {
+			ui.send(ws, webUpdate{Status: fmt.Sprint("bad message: ", err)})
+			continue
+		}
+		ui.handleMsg(msg)
+	}
+}
+
+func (ui *webUI) handleMsg(msg webMsg) {
+	ui.cmdMutex.Lock()
+	defer ui.cmdMutex.Unlock()
+	switch msg.Type {
+	case "key":
+		ui.cmd.typed = []byte(msg.Text)
+		ui.sendCmd()
+	case "reset", "clear":
+		button := &ui.cmd.input.ResetButton
+		if msg.Type == "clear" {
+			button = &ui.cmd.input.ClearScreenButton
+		}
+		*button = true
+		ui.sendCmd()
+		*button = false
+		ui.sendCmd()
+	case "save", "load":
+		if msg.Slot < 1 || msg.Slot > 9 {
+			return
+		}
+		ui.cmd.snapshotMode = 'm'
+		if msg.Type == "load" {
+			ui.cmd.snapshotMode = 'l'
+		}
+		ui.cmd.snapshotNum = rune('0' + msg.Slot)
+		ui.sendCmd()
+	case "turbo":
+		ui.cmd.hyperMode = toggleTurbo(ui.cmd.hyperMode)
+		ui.sendCmd()
+	case "speed":
+		ui.cmd.speed = changeSpeed(ui.cmd.speed, msg.Faster)
+		ui.sendCmd()
+	case "screenshot":
+		ui.cmd.screenshot = true
+		ui.sendCmd()
+	case "record":
+		ui.cmd.record = true
+		ui.sendCmd()
+	case "listBasic":
+		ui.cmd.listBasic = true
+		ui.sendCmd()
+	case "theme":
+		ui.cmd.nextTheme = true
+		ui.sendCmd()
+	case "scroll":
+		ui.cmd.scroll = msg.Lines
+		ui.sendCmd()
+	}
+}
+
+// sendCmd passes the current cmd on to the emu, with ui.cmdMutex held
+func (ui *webUI) sendCmd() {
+	ui.cmds <- ui.cmd
+	ui.cmd.typed = nil
+	ui.cmd.listBasic = false
+	ui.cmd.screenshot = false
+	ui.cmd.record = false
+	ui.cmd.scroll = 0
+	ui.cmd.nextTheme = false
+	ui.cmd.snapshotMode = 'x'
+}
+
+func webThemeUpdate(theme a1go.Theme) webUpdate {
+	css := func(c color.RGBA) string {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return webUpdate{Foreground: css(theme.Foreground), Background: css(theme.Background)}
+}
+
+func (ui *webUI) send(ws *wsConn, update webUpdate) {
+	updateBytes, err := json.Marshal(update)
+	if err != nil {
+		panic(err)
+	}
+	ws.writeText(updateBytes)
+}
+
+func (ui *webUI) broadcast(update webUpdate) {
+	ui.clientsMutex.Lock()
+	clients := []*wsConn{}
+	for ws := range ui.clients {
+		clients = append(clients, ws)
+	}
+	ui.clientsMutex.Unlock()
+	for _, ws := range clients {
+		ui.send(ws, update)
+	}
+}
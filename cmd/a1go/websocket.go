@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Just enough of RFC 6455 for the web frontend: text messages, pings
+// and closes, with no extensions or fragmented sends.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xa
+)
+
+const wsMaxMessageLen = 64 * 1024
+
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	writeMutex sync.Mutex
+}
+
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, "websocket only", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a websocket request")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't hijack", http.StatusInternalServerError)
+		return nil, fmt.Errorf("can't hijack connection")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n", accept)
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+// readMessage returns the next text message, answering pings on the way
+func (ws *wsConn) readMessage() ([]byte, error) {
+	msg := []byte{}
+	for {
+		op, fin, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case wsOpClose:
+			ws.writeFrame(wsOpClose, nil)
+			return nil, io.EOF
+		case wsOpPing:
+			ws.writeFrame(wsOpPong, payload)
+			continue
+		case wsOpPong:
+			continue
+		}
+		msg = append(msg, payload...)
+		if len(msg) > wsMaxMessageLen {
+			return nil, fmt.Errorf("websocket message too long")
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (ws *wsConn) readFrame() (byte, bool, []byte, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(ws.r, hdr); err != nil {
+		return 0, false, nil, err
+	}
+	fin, op := hdr[0]&0x80 != 0, hdr[0]&0xf
+	masked, length := hdr[1]&0x80 != 0, uint64(hdr[1]&0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(ws.r, ext); err != nil {
+			return 0, false, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(ws.r, ext); err != nil {
+			return 0, false, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > wsMaxMessageLen {
+		return 0, false, nil, fmt.Errorf("websocket frame too long")
+	}
+	mask := make([]byte, 4)
+	if masked {
+		if _, err := io.ReadFull(ws.r, mask); err != nil {
+			return 0, false, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.r, payload); err != nil {
+		return 0, false, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return op, fin, payload, nil
+}
+
+func (ws *wsConn) writeText(msg []byte) error {
+	return ws.writeFrame(wsOpText, msg)
+}
+
+func (ws *wsConn) writeFrame(op byte, payload []byte) error {
+	ws.writeMutex.Lock()
+	defer ws.writeMutex.Unlock()
+	hdr := []byte{0x80 | op}
+	switch {
+	case len(payload) < 126:
+		hdr = append(hdr, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		hdr = append(hdr, 126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		hdr = append(hdr, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(len(payload)))
+	}
+	if _, err := ws.conn.Write(append(hdr, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (ws *wsConn) Close() error {
+	return ws.conn.Close()
+}
@@ -0,0 +1,178 @@
+//go:build !nogui
+// +build !nogui
+
+package main
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/theinternetftw/a1go"
+	"github.com/theinternetftw/glimmer"
+)
+
+// the window frontend is left out of -tags nogui builds, so they
+// don't need ebiten's native dependencies
+func init() {
+	frontends["window"] = runWindow
+}
+
+func runWindow(emu a1go.Emulator, cfg settings) {
+	screenW := 240
+	screenH := 192
+	renderW, renderH := screenW, screenH
+	var crt *a1go.CRTFilter
+	if cfg.crt != nil {
+		var err error
+		crt, err = a1go.NewCRTFilter(screenW, screenH, *cfg.crt)
+		dieIf(err)
+		renderW, renderH = crt.Size()
+	}
+	glimmer.InitDisplayLoop(glimmer.InitDisplayLoopOptions{
+		WindowTitle:  "a1go",
+		WindowWidth:  screenW*2 + 40,
+		WindowHeight: screenH*2 + 40,
+		RenderWidth:  renderW,
+		RenderHeight: renderH,
+		InitCallback: func(sharedState *glimmer.WindowState) {
+			startEmu(sharedState, emu, cfg, crt)
+		},
+	})
+}
+
+// startEmu runs the emu, and draws its frames through crt, if not nil
+func startEmu(window *glimmer.WindowState, emu a1go.Emulator, cfg settings, crt *a1go.CRTFilter) {
+
+	frameTimer := glimmer.MakeFrameTimer()
+
+	snapInProgress := false
+
+	numDown := 'x'
+	lastNumDown := 'x'
+	snapshotMode := 'x'
+
+	hyperMode := cfg.turbo
+	speed := cfg.speed
+	lastF5, lastF6, lastF7, lastF8, lastF11, lastF12 := false, false, false, false, false, false
+	lastF3, lastPageUp, lastPageDown := false, false, false
+	// wheel is mouse wheel movement not yet scrolled by, as touchpads
+	// can send fractions of a notch
+	wheel := 0.0
+
+	// lastPix is the last frame, for the crt filter to fade out
+	var lastPix []byte
+
+	cmds := make(chan emuCmd)
+	frames := make(chan emuFrame, 1)
+	go runEmu(emu, cfg, cmds, frames)
+
+	for {
+		cmd := emuCmd{snapshotMode: 'x'}
+
+		window.InputMutex.Lock()
+		{
+
+			switch {
+			case window.CodeIsDown(glimmer.KeyCodeF1):
+				cmd.input.ResetButton = true
+			case window.CodeIsDown(glimmer.KeyCodeF2):
+				cmd.input.ClearScreenButton = true
+			}
+
+			f5, f6, f11 := window.CodeIsDown(glimmer.KeyCodeF5), window.CodeIsDown(glimmer.KeyCodeF6), window.CodeIsDown(glimmer.KeyCodeF11)
+			f7, f8, f12 := window.CodeIsDown(glimmer.KeyCodeF7), window.CodeIsDown(glimmer.KeyCodeF8), window.CodeIsDown(glimmer.KeyCodeF12)
+			cmd.listBasic = f7 && !lastF7
+			cmd.record = f8 && !lastF8
+			cmd.screenshot = f12 && !lastF12
+			if f11 && !lastF11 {
+				hyperMode = toggleTurbo(hyperMode)
+			}
+			if f5 && !lastF5 || f6 && !lastF6 {
+				speed = changeSpeed(speed, f6)
+			}
+			lastF5, lastF6, lastF7, lastF8, lastF11, lastF12 = f5, f6, f7, f8, f11, f12
+
+			f3 := window.CodeIsDown(glimmer.KeyCodeF3)
+			cmd.nextTheme = f3 && !lastF3
+			lastF3 = f3
+
+			pageUp, pageDown := window.CodeIsDown(glimmer.KeyCodePageUp), window.CodeIsDown(glimmer.KeyCodePageDown)
+			if pageUp && !lastPageUp {
+				cmd.scroll += scrollPage
+			}
+			if pageDown && !lastPageDown {
+				cmd.scroll -= scrollPage
+			}
+			lastPageUp, lastPageDown = pageUp, pageDown
+			// glimmer doesn't do the mouse, but ebiten's wheel is safe from any goroutine
+			_, wheelY := ebiten.Wheel()
+			wheel += wheelY * scrollWheelLines
+			cmd.scroll += int(wheel)
+			wheel -= float64(int(wheel))
+
+			if window.CodeIsDown(glimmer.KeyCodeF4) {
+				snapshotMode = 'm'
+			} else if window.CodeIsDown(glimmer.KeyCodeF9) {
+				snapshotMode = 'l'
+			} else {
+				snapInProgress = false
+			}
+
+			numDown = 'x'
+			for r := '0'; r <= '9'; r++ {
+				if window.CharIsDown(r) {
+					numDown = r
+					break
+				}
+			}
+			if lastNumDown != 'x' {
+				if !window.CharIsDown(lastNumDown) {
+					lastNumDown = 'x'
+				}
+			}
+
+			if snapshotMode == 'x' && lastNumDown == 'x' {
+				window.CopyKeyCharArray(cmd.input.Keys[:])
+				cmd.input.Keys['\r'] = window.CodeIsDown(glimmer.KeyCodeEnter)
+			}
+		}
+		window.InputMutex.Unlock()
+
+		cmd.hyperMode = hyperMode
+		cmd.speed = speed
+
+		if numDown > '0' && numDown <= '9' {
+			if snapshotMode != 'x' && !snapInProgress {
+				snapInProgress = true
+				lastNumDown = numDown
+				cmd.snapshotMode = snapshotMode
+				cmd.snapshotNum = numDown
+				snapshotMode = 'x'
+			}
+		}
+
+		cmds <- cmd
+
+		select {
+		case frame := <-frames:
+			lastPix = frame.pix
+			if crt != nil {
+				frame.pix = crt.Apply(frame.pix)
+			}
+			window.RenderMutex.Lock()
+			copy(window.Pix, frame.pix)
+			window.RenderMutex.Unlock()
+			frameTimer.MarkRenderComplete()
+		default:
+			// the phosphor keeps fading with nothing new to show
+			if crt != nil && crt.Decaying() {
+				pix := crt.Apply(lastPix)
+				window.RenderMutex.Lock()
+				copy(window.Pix, pix)
+				window.RenderMutex.Unlock()
+			}
+		}
+
+		<-window.DrawNotifier
+		frameTimer.MarkFrameComplete()
+		frameTimer.PrintStatsEveryXFrames(60 * 5)
+	}
+}
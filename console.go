@@ -0,0 +1,89 @@
+package a1go
+
+import "fmt"
+
+// consoleLink bridges the keyboard and display to a host link, so the
+// apple-1 can be used from a terminal or driven by tools like expect.
+// It's a frontend setting rather than machine state, so it's kept out
+// of snapshots and carried over when one is loaded.
+type consoleLink struct {
+	link *hostLink
+	col  int
+
+	lastKey byte
+}
+
+const consoleCols = 40
+
+func (emu *emuState) openConsole(spec string) error {
+	link, err := openHostLink(spec)
+	if err != nil {
+		return fmt.Errorf("could not open console: %v", err)
+	}
+	emu.console = &consoleLink{link: link}
+	fmt.Println("console: connect to", link.Desc)
+	return nil
+}
+
+// updateConsoleInput feeds the next key from the console to the
+// keyboard, once the last one has been read. Autokey input goes first.
+func (emu *emuState) updateConsoleInput() {
+	if emu.console == nil || emu.NewKeyWasPressed || len(emu.autokeyInput) > 0 {
+		return
+	}
+	for {
+		b, ok := emu.console.link.recv()
+		if !ok {
+			return
+		}
+		if key, ok := emu.console.keyFromHost(b); ok {
+			emu.NewKeyInput = key
+			emu.NewKeyWasPressed = true
+			return
+		}
+	}
+}
+
+// keyFromHost translates a byte from the host into what an apple-1
+// keyboard would send: uppercase, CR for enter, and _ for rubout.
+func (c *consoleLink) keyFromHost(b byte) (byte, bool) {
+	last := c.lastKey
+	c.lastKey = b
+	switch {
+	case b == '\n' && last == '\r', b == 0, b > 127:
+		return 0, false
+	case b == '\n':
+		return '\r', true
+	case b == 8 || b == 0x7f:
+		return '_', true
+	case b >= 'a' && b <= 'z':
+		return b - ('a' - 'A'), true
+	}
+	return b, true
+}
+
+// display sends a char written to the display on to the host,
+// wrapping lines where the display does.
+func (c *consoleLink) display(char byte, lowercase bool) {
+	if char == '\r' || char == '\n' {
+		c.newline()
+		return
+	}
+	if char == '\t' {
+		char = ' '
+	}
+	if char < 32 {
+		return
+	}
+	c.link.send(byte(displayChar(rune(char), lowercase)))
+	c.col++
+	if c.col >= consoleCols {
+		c.newline()
+	}
+}
+
+func (c *consoleLink) newline() {
+	c.link.send('\r')
+	c.link.send('\n')
+	c.col = 0
+}
@@ -0,0 +1,91 @@
+package a1go
+
+import (
+	"fmt"
+
+	"github.com/theinternetftw/cpugo/virt6502"
+)
+
+// CPUType picks which cpu core an Emulator runs
+type CPUType string
+
+const (
+	// CPU6502 is the NMOS 6502 of an original Apple-1
+	CPU6502 CPUType = "6502"
+	// CPU65C02 is the CMOS 65C02 (WDC flavor, with the bit ops)
+	// of the Replica-1 and many other modern clones
+	CPU65C02 CPUType = "65c02"
+)
+
+// Registers are the cpu's programmer-visible registers
+type Registers struct {
+	PC            uint16
+	A, X, Y, S, P byte
+}
+
+func (r Registers) String() string {
+	return fmt.Sprintf("PC:%04x A:%02x X:%02x Y:%02x S:%02x P:%02x", r.PC, r.A, r.X, r.Y, r.S, r.P)
+}
+
+// CPU is a 6502-family cpu core. Every core keeps its registers and
+// interrupt lines in a virt6502.Virt6502, which is what gets saved in
+// snapshots, so cores can be swapped without changing the snapshot
+// format.
+type CPU interface {
+	// Step runs one instruction, handling any pending interrupt first
+	Step()
+	// Reset raises RESET, handled on the next Step
+	Reset()
+	// IRQ raises IRQ for the next Step. Level-triggered devices
+	// should call it before every Step while their line is held.
+	IRQ()
+	// NMI raises NMI, handled on the next Step
+	NMI()
+
+	Registers() Registers
+	SetRegisters(Registers)
+}
+
+// cpuHaltState is 65C02 WAI/STP state, kept in emuState for snapshots
+type cpuHaltState struct {
+	Waiting bool
+	Stopped bool
+}
+
+// newCPUCore builds a core around vc. peek reads memory without side
+// effects, for cores that look at an opcode before running it.
+func newCPUCore(cpuType CPUType, vc *virt6502.Virt6502, halt *cpuHaltState, peek func(addr uint16) byte) (CPU, error) {
+	switch cpuType {
+	case "", CPU6502:
+		return &nmos6502{vc}, nil
+	case CPU65C02:
+		return &cmos65c02{vc: vc, halt: halt, peek: peek}, nil
+	default:
+		return nil, fmt.Errorf("unknown cpu type %q (known types: %v, %v)", cpuType, CPU6502, CPU65C02)
+	}
+}
+
+// nmos6502 is a thin wrapper around virt6502
+type nmos6502 struct {
+	vc *virt6502.Virt6502
+}
+
+func (c *nmos6502) Step()  { c.vc.Step() }
+func (c *nmos6502) Reset() { c.vc.RESET = true }
+func (c *nmos6502) IRQ()   { c.vc.IRQ = true }
+func (c *nmos6502) NMI()   { c.vc.NMI = true }
+
+func (c *nmos6502) Registers() Registers {
+	return registersOf(c.vc)
+}
+func (c *nmos6502) SetRegisters(r Registers) {
+	setRegistersOf(c.vc, r)
+}
+
+func registersOf(vc *virt6502.Virt6502) Registers {
+	return Registers{PC: vc.PC, A: vc.A, X: vc.X, Y: vc.Y, S: vc.S, P: vc.P}
+}
+
+func setRegistersOf(vc *virt6502.Virt6502, r Registers) {
+	vc.PC, vc.A, vc.X, vc.Y, vc.S, vc.P = r.PC, r.A, r.X, r.Y, r.S, r.P
+}
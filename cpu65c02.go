@@ -0,0 +1,493 @@
+package a1go
+
+import "github.com/theinternetftw/cpugo/virt6502"
+
+// cmos65c02 runs the opcodes the 65C02 added or changed itself, and hands
+// everything the two chips agree on to virt6502. Interrupts are handled
+// here too, since the 65C02 clears the decimal flag on entry.
+type cmos65c02 struct {
+	vc   *virt6502.Virt6502
+	halt *cpuHaltState
+	// peek reads without bus side effects, so that looking at the
+	// opcode before the real fetch doesn't touch I/O twice
+	peek func(addr uint16) byte
+}
+
+// cmosOwnsOpcode is true for opcodes the 65C02 core runs itself
+var cmosOwnsOpcode = func() [256]bool {
+	var owns [256]bool
+	for i := range owns {
+		owns[i] = virt6502.IsUndocumentedOpcode(byte(i))
+	}
+	// JMP (abs) page wrap fix
+	owns[0x6c] = true
+	// ADC/SBC, for the 65C02's decimal mode flags
+	for _, op := range []byte{0x61, 0x65, 0x69, 0x6d, 0x71, 0x75, 0x79, 0x7d} {
+		owns[op] = true
+		owns[op+0x80] = true
+	}
+	return owns
+}()
+
+func (c *cmos65c02) Reset() { c.vc.RESET = true }
+func (c *cmos65c02) IRQ()   { c.vc.IRQ = true }
+func (c *cmos65c02) NMI()   { c.vc.NMI = true }
+
+func (c *cmos65c02) Registers() Registers {
+	return registersOf(c.vc)
+}
+func (c *cmos65c02) SetRegisters(r Registers) {
+	setRegistersOf(c.vc, r)
+}
+
+func (c *cmos65c02) Step() {
+	vc := c.vc
+
+	if c.halt.Stopped {
+		if !vc.RESET {
+			vc.RunCycles(1)
+			return
+		}
+		c.halt.Stopped = false
+	}
+	if c.halt.Waiting {
+		if !vc.RESET && !vc.NMI && !vc.IRQ {
+			vc.RunCycles(1)
+			return
+		}
+		c.halt.Waiting = false
+	}
+
+	c.handleInterrupts()
+
+	opcode := c.peek(vc.PC)
+	if !cmosOwnsOpcode[opcode] {
+		// nothing's pending now, so virt6502's own
+		// interrupt check just updates LastStepsP
+		vc.Step()
+		if opcode == 0x00 {
+			// BRK
+			vc.P &^= virt6502.FlagDecimal
+		}
+		return
+	}
+
+	vc.Steps++
+	c.fetch()
+	c.runOpcode(opcode)
+}
+
+func (c *cmos65c02) handleInterrupts() {
+	vc := c.vc
+	if vc.RESET {
+		vc.RESET = false
+		for i := 0; i < 3; i++ {
+			c.read(0x100 + uint16(vc.S))
+			vc.S--
+		}
+		vc.P |= virt6502.FlagIrqDisabled
+		vc.P &^= virt6502.FlagDecimal
+		vc.PC = c.read16(0xfffc)
+	} else if vc.NMI {
+		vc.NMI = false
+		c.interrupt(0xfffa)
+	} else if vc.IRQ {
+		vc.IRQ = false
+		if vc.LastStepsP&virt6502.FlagIrqDisabled == 0 {
+			c.interrupt(0xfffe)
+		}
+	}
+	vc.LastStepsP = vc.P
+}
+
+func (c *cmos65c02) interrupt(vector uint16) {
+	vc := c.vc
+	c.read(vc.PC)
+	c.read(vc.PC)
+	c.push(byte(vc.PC >> 8))
+	c.push(byte(vc.PC))
+	c.push(vc.P | virt6502.FlagAlwaysSet)
+	vc.P |= virt6502.FlagIrqDisabled
+	vc.P &^= virt6502.FlagDecimal
+	vc.PC = c.read16(vector)
+}
+
+// bus cycles
+
+func (c *cmos65c02) read(addr uint16) byte {
+	b := c.vc.Read(addr)
+	c.vc.RunCycles(1)
+	return b
+}
+func (c *cmos65c02) write(addr uint16, val byte) {
+	c.vc.Write(addr, val)
+	c.vc.RunCycles(1)
+}
+func (c *cmos65c02) read16(addr uint16) uint16 {
+	lo := c.read(addr)
+	hi := c.read(addr + 1)
+	return uint16(hi)<<8 | uint16(lo)
+}
+func (c *cmos65c02) fetch() byte {
+	b := c.read(c.vc.PC)
+	c.vc.PC++
+	return b
+}
+func (c *cmos65c02) fetch16() uint16 {
+	lo := c.fetch()
+	hi := c.fetch()
+	return uint16(hi)<<8 | uint16(lo)
+}
+func (c *cmos65c02) push(val byte) {
+	c.write(0x100+uint16(c.vc.S), val)
+	c.vc.S--
+}
+func (c *cmos65c02) pull() byte {
+	c.vc.S++
+	return c.read(0x100 + uint16(c.vc.S))
+}
+
+// extraCycle is an internal cycle. The real chip re-reads
+// something harmless here, which isn't worth modeling.
+func (c *cmos65c02) extraCycle() {
+	c.vc.RunCycles(1)
+}
+
+// addressing modes
+
+func (c *cmos65c02) zeroPage() uint16 {
+	return uint16(c.fetch())
+}
+func (c *cmos65c02) zeroPageIndexed(idx byte) uint16 {
+	addr := c.fetch() + idx
+	c.extraCycle()
+	return uint16(addr)
+}
+func (c *cmos65c02) absolute() uint16 {
+	return c.fetch16()
+}
+
+// absoluteIndexed costs an extra cycle on page cross,
+// or always, if it's for a write or a read-mod-write.
+func (c *cmos65c02) absoluteIndexed(idx byte, alwaysFixup bool) uint16 {
+	base := c.fetch16()
+	addr := base + uint16(idx)
+	if alwaysFixup || base&0xff00 != addr&0xff00 {
+		c.extraCycle()
+	}
+	return addr
+}
+func (c *cmos65c02) zeroPagePointer(zpAddr byte) uint16 {
+	lo := c.read(uint16(zpAddr))
+	hi := c.read(uint16(zpAddr + 1))
+	return uint16(hi)<<8 | uint16(lo)
+}
+func (c *cmos65c02) xPreIndexed() uint16 {
+	zpAddr := c.fetch() + c.vc.X
+	c.extraCycle()
+	return c.zeroPagePointer(zpAddr)
+}
+func (c *cmos65c02) yPostIndexed(alwaysFixup bool) uint16 {
+	base := c.zeroPagePointer(c.fetch())
+	addr := base + uint16(c.vc.Y)
+	if alwaysFixup || base&0xff00 != addr&0xff00 {
+		c.extraCycle()
+	}
+	return addr
+}
+func (c *cmos65c02) zeroPageIndirect() uint16 {
+	return c.zeroPagePointer(c.fetch())
+}
+
+// operandAddr decodes the addressing mode of the ALU ops in block 1
+// (ORA/AND/EOR/ADC/STA/LDA/CMP/SBC), plus the 65C02's (zp) mode, which
+// lives at the block's otherwise-unused xx2 slots.
+func (c *cmos65c02) operandAddr(opcode byte, isWrite bool) (uint16, bool) {
+	switch opcode & 0x1f {
+	case 0x01:
+		return c.xPreIndexed(), true
+	case 0x05:
+		return c.zeroPage(), true
+	case 0x09:
+		return 0, false // immediate
+	case 0x0d:
+		return c.absolute(), true
+	case 0x11:
+		return c.yPostIndexed(isWrite), true
+	case 0x12:
+		return c.zeroPageIndirect(), true
+	case 0x15:
+		return c.zeroPageIndexed(c.vc.X), true
+	case 0x19:
+		return c.absoluteIndexed(c.vc.Y, isWrite), true
+	case 0x1d:
+		return c.absoluteIndexed(c.vc.X, isWrite), true
+	}
+	panic("bad block 1 addressing mode")
+}
+
+func (c *cmos65c02) readOperand(opcode byte) byte {
+	if addr, isMem := c.operandAddr(opcode, false); isMem {
+		return c.read(addr)
+	}
+	return c.fetch()
+}
+
+func (c *cmos65c02) setFlag(test bool, flag byte) {
+	if test {
+		c.vc.P |= flag
+	} else {
+		c.vc.P &^= flag
+	}
+}
+func (c *cmos65c02) setZeroNeg(val byte) {
+	c.setFlag(val == 0, virt6502.FlagZero)
+	c.setFlag(val&0x80 != 0, virt6502.FlagNeg)
+}
+
+func (c *cmos65c02) readModWrite(addr uint16, fn func(byte) byte) {
+	val := c.read(addr)
+	c.extraCycle()
+	c.write(addr, fn(val))
+}
+
+func (c *cmos65c02) branch(test bool) {
+	offset := int8(c.fetch())
+	if test {
+		c.extraCycle()
+		newPC := uint16(int(c.vc.PC) + int(offset))
+		if newPC&0xff00 != c.vc.PC&0xff00 {
+			c.extraCycle()
+		}
+		c.vc.PC = newPC
+	}
+}
+
+func (c *cmos65c02) nop(operandBytes int, cycles int) {
+	for i := 0; i < operandBytes; i++ {
+		c.fetch()
+		cycles--
+	}
+	for ; cycles > 1; cycles-- {
+		c.extraCycle()
+	}
+}
+
+func (c *cmos65c02) runOpcode(opcode byte) {
+	vc := c.vc
+
+	switch {
+	case opcode&0x0f == 0x07:
+		// RMB0-7/SMB0-7
+		bit := byte(1) << ((opcode >> 4) & 7)
+		addr := c.zeroPage()
+		c.readModWrite(addr, func(val byte) byte {
+			if opcode&0x80 == 0 {
+				return val &^ bit
+			}
+			return val | bit
+		})
+		return
+	case opcode&0x0f == 0x0f:
+		// BBR0-7/BBS0-7
+		bit := byte(1) << ((opcode >> 4) & 7)
+		val := c.read(c.zeroPage())
+		c.extraCycle()
+		isSet := val&bit != 0
+		c.branch(isSet == (opcode&0x80 != 0))
+		return
+	}
+
+	switch opcode {
+
+	case 0x12, 0x32, 0x52, 0xb2, 0xd2: // ORA/AND/EOR/LDA/CMP (zp)
+		val := c.readOperand(opcode)
+		switch opcode {
+		case 0x12:
+			vc.A |= val
+			c.setZeroNeg(vc.A)
+		case 0x32:
+			vc.A &= val
+			c.setZeroNeg(vc.A)
+		case 0x52:
+			vc.A ^= val
+			c.setZeroNeg(vc.A)
+		case 0xb2:
+			vc.A = val
+			c.setZeroNeg(vc.A)
+		case 0xd2:
+			c.setZeroNeg(vc.A - val)
+			c.setFlag(vc.A >= val, virt6502.FlagCarry)
+		}
+	case 0x92: // STA (zp)
+		addr, _ := c.operandAddr(opcode, true)
+		c.write(addr, vc.A)
+
+	case 0x61, 0x65, 0x69, 0x6d, 0x71, 0x72, 0x75, 0x79, 0x7d:
+		c.adc(c.readOperand(opcode))
+	case 0xe1, 0xe5, 0xe9, 0xed, 0xf1, 0xf2, 0xf5, 0xf9, 0xfd:
+		c.sbc(c.readOperand(opcode))
+
+	case 0x80: // BRA
+		c.branch(true)
+
+	case 0xda: // PHX
+		c.read(vc.PC)
+		c.push(vc.X)
+	case 0x5a: // PHY
+		c.read(vc.PC)
+		c.push(vc.Y)
+	case 0xfa: // PLX
+		c.read(vc.PC)
+		c.extraCycle()
+		vc.X = c.pull()
+		c.setZeroNeg(vc.X)
+	case 0x7a: // PLY
+		c.read(vc.PC)
+		c.extraCycle()
+		vc.Y = c.pull()
+		c.setZeroNeg(vc.Y)
+
+	case 0x64: // STZ zp
+		c.write(c.zeroPage(), 0)
+	case 0x74: // STZ zp,X
+		c.write(c.zeroPageIndexed(vc.X), 0)
+	case 0x9c: // STZ abs
+		c.write(c.absolute(), 0)
+	case 0x9e: // STZ abs,X
+		c.write(c.absoluteIndexed(vc.X, true), 0)
+
+	case 0x04, 0x0c, 0x14, 0x1c: // TSB/TRB zp/abs
+		var addr uint16
+		if opcode&0x08 == 0 {
+			addr = c.zeroPage()
+		} else {
+			addr = c.absolute()
+		}
+		c.readModWrite(addr, func(val byte) byte {
+			c.setFlag(vc.A&val == 0, virt6502.FlagZero)
+			if opcode&0x10 == 0 {
+				return val | vc.A
+			}
+			return val &^ vc.A
+		})
+
+	case 0x1a: // INC A
+		c.read(vc.PC)
+		vc.A++
+		c.setZeroNeg(vc.A)
+	case 0x3a: // DEC A
+		c.read(vc.PC)
+		vc.A--
+		c.setZeroNeg(vc.A)
+
+	case 0x89: // BIT imm, only sets Z
+		val := c.fetch()
+		c.setFlag(vc.A&val == 0, virt6502.FlagZero)
+	case 0x34: // BIT zp,X
+		c.bit(c.read(c.zeroPageIndexed(vc.X)))
+	case 0x3c: // BIT abs,X
+		c.bit(c.read(c.absoluteIndexed(vc.X, false)))
+
+	case 0x6c: // JMP (abs), without the NMOS page wrap bug
+		ptr := c.fetch16()
+		c.extraCycle()
+		vc.PC = c.read16(ptr)
+	case 0x7c: // JMP (abs,X)
+		ptr := c.fetch16() + uint16(vc.X)
+		c.extraCycle()
+		vc.PC = c.read16(ptr)
+
+	case 0xcb: // WAI
+		c.read(vc.PC)
+		c.extraCycle()
+		c.halt.Waiting = true
+	case 0xdb: // STP
+		c.read(vc.PC)
+		c.extraCycle()
+		c.halt.Stopped = true
+
+	// everything else the NMOS chip didn't define is a NOP of some size
+
+	case 0x02, 0x22, 0x42, 0x62, 0x82, 0xc2, 0xe2:
+		c.nop(1, 2)
+	case 0x44:
+		c.nop(1, 3)
+	case 0x54, 0xd4, 0xf4:
+		c.nop(1, 4)
+	case 0x5c:
+		c.nop(2, 8)
+	case 0xdc, 0xfc:
+		c.nop(2, 4)
+	default:
+		// the x3 and xB columns
+		c.nop(0, 1)
+	}
+}
+
+func (c *cmos65c02) bit(val byte) {
+	c.vc.P &^= 0xc0
+	c.vc.P |= val & 0xc0
+	c.setFlag(c.vc.A&val == 0, virt6502.FlagZero)
+}
+
+func (c *cmos65c02) carry() int {
+	return int(c.vc.P & virt6502.FlagCarry)
+}
+
+func (c *cmos65c02) decimalMode() bool {
+	return c.vc.P&virt6502.FlagDecimal != 0
+}
+
+// adc and sbc follow Bruce Clark's "Decimal Mode" tutorial at 6502.org.
+// Unlike the NMOS chip, the 65C02 sets N and Z from the decimal result,
+// and spends an extra cycle doing so.
+func (c *cmos65c02) adc(val byte) {
+	vc := c.vc
+	a, b := int(vc.A), int(val)
+	if !c.decimalMode() {
+		sum := a + b + c.carry()
+		c.setFlag(sum > 0xff, virt6502.FlagCarry)
+		c.setFlag((a^sum)&(b^sum)&0x80 != 0, virt6502.FlagOverflow)
+		vc.A = byte(sum)
+		c.setZeroNeg(vc.A)
+		return
+	}
+	lo := a&0x0f + b&0x0f + c.carry()
+	if lo >= 0x0a {
+		lo = (lo+0x06)&0x0f + 0x10
+	}
+	signed := int(int8(vc.A&0xf0)) + int(int8(val&0xf0)) + lo
+	c.setFlag(signed < -128 || signed > 127, virt6502.FlagOverflow)
+	sum := a&0xf0 + b&0xf0 + lo
+	if sum >= 0xa0 {
+		sum += 0x60
+	}
+	c.setFlag(sum >= 0x100, virt6502.FlagCarry)
+	vc.A = byte(sum)
+	c.setZeroNeg(vc.A)
+	c.extraCycle()
+}
+
+func (c *cmos65c02) sbc(val byte) {
+	vc := c.vc
+	a, b, borrow := int(vc.A), int(val), 1-c.carry()
+	diff := a - b - borrow
+	c.setFlag(diff >= 0, virt6502.FlagCarry)
+	c.setFlag((a^b)&(a^diff)&0x80 != 0, virt6502.FlagOverflow)
+	if !c.decimalMode() {
+		vc.A = byte(diff)
+		c.setZeroNeg(vc.A)
+		return
+	}
+	lo := a&0x0f - b&0x0f - borrow
+	if diff < 0 {
+		diff -= 0x60
+	}
+	if lo < 0 {
+		diff -= 0x06
+	}
+	vc.A = byte(diff)
+	c.setZeroNeg(vc.A)
+	c.extraCycle()
+}
@@ -0,0 +1,440 @@
+package a1go
+
+import (
+	"testing"
+
+	"github.com/theinternetftw/cpugo/virt6502"
+)
+
+const (
+	flagC = virt6502.FlagCarry
+	flagZ = virt6502.FlagZero
+	flagI = virt6502.FlagIrqDisabled
+	flagD = virt6502.FlagDecimal
+	flagV = virt6502.FlagOverflow
+	flagN = virt6502.FlagNeg
+	// flag0 is the P of a fresh test cpu, with just the always set bit
+	flag0 = virt6502.FlagAlwaysSet
+)
+
+// cpuTestOrigin is where cpu tests put their code
+const cpuTestOrigin = 0x0300
+
+// newCPUTestEmu makes an emulator with the given core, out of reset
+// and ready to run code at cpuTestOrigin
+func newCPUTestEmu(t *testing.T, cpu CPUType) *emuState {
+	t.Helper()
+	emu, err := newStateWithOptions(Options{CPU: cpu})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emu.core.Step()
+	emu.core.SetRegisters(Registers{PC: cpuTestOrigin, S: 0xff, P: flag0})
+	return emu
+}
+
+type cpuTest struct {
+	name  string
+	code  []byte
+	steps int
+	regs  Registers
+	mem   map[uint16]byte
+
+	wantRegs   Registers
+	wantMem    map[uint16]byte
+	wantCycles uint64
+}
+
+func runCPUTests(t *testing.T, cpu CPUType, tests []cpuTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			emu := newCPUTestEmu(t, cpu)
+			for addr, val := range test.mem {
+				emu.write(addr, val)
+			}
+			emu.loadBinaryToMem(cpuTestOrigin, test.code)
+			regs := test.regs
+			regs.PC, regs.S, regs.P = cpuTestOrigin, 0xff, regs.P|flag0
+			emu.core.SetRegisters(regs)
+
+			start := emu.Cycles
+			steps := test.steps
+			if steps == 0 {
+				steps = 1
+			}
+			for i := 0; i < steps; i++ {
+				emu.core.Step()
+			}
+
+			want := test.wantRegs
+			if want.S == 0 {
+				want.S = 0xff
+			}
+			want.P |= flag0
+			if got := emu.core.Registers(); got != want {
+				t.Errorf("registers are %v, want %v", got, want)
+			}
+			for addr, val := range test.wantMem {
+				if got := emu.peek(addr); got != val {
+					t.Errorf("$%04X is %02x, want %02x", addr, got, val)
+				}
+			}
+			if got := emu.Cycles - start; got != test.wantCycles {
+				t.Errorf("took %v cycles, want %v", got, test.wantCycles)
+			}
+		})
+	}
+}
+
+func TestCPU65C02Opcodes(t *testing.T) {
+	runCPUTests(t, CPU65C02, []cpuTest{
+		{
+			name:       "BRA",
+			code:       []byte{0x80, 0x10},
+			wantRegs:   Registers{PC: 0x0312},
+			wantCycles: 3,
+		},
+		{
+			name:       "BRA back across a page",
+			code:       []byte{0x80, 0xf0},
+			wantRegs:   Registers{PC: 0x02f2},
+			wantCycles: 4,
+		},
+		{
+			name:       "PHX then PLY",
+			code:       []byte{0xda, 0x7a},
+			steps:      2,
+			regs:       Registers{X: 0x80},
+			wantRegs:   Registers{PC: 0x0302, X: 0x80, Y: 0x80, P: flagN},
+			wantMem:    map[uint16]byte{0x01ff: 0x80},
+			wantCycles: 7,
+		},
+		{
+			name:       "PHY then PLX",
+			code:       []byte{0x5a, 0xfa},
+			steps:      2,
+			regs:       Registers{X: 0x01},
+			wantRegs:   Registers{PC: 0x0302, P: flagZ},
+			wantCycles: 7,
+		},
+		{
+			name:       "STZ zp",
+			code:       []byte{0x64, 0x10},
+			mem:        map[uint16]byte{0x0010: 0xff},
+			wantRegs:   Registers{PC: 0x0302},
+			wantMem:    map[uint16]byte{0x0010: 0x00},
+			wantCycles: 3,
+		},
+		{
+			name:       "STZ zp,X",
+			code:       []byte{0x74, 0xff},
+			regs:       Registers{X: 0x11},
+			mem:        map[uint16]byte{0x0010: 0xff},
+			wantRegs:   Registers{PC: 0x0302, X: 0x11},
+			wantMem:    map[uint16]byte{0x0010: 0x00},
+			wantCycles: 4,
+		},
+		{
+			name:       "STZ abs",
+			code:       []byte{0x9c, 0x00, 0x02},
+			mem:        map[uint16]byte{0x0200: 0xff},
+			wantRegs:   Registers{PC: 0x0303},
+			wantMem:    map[uint16]byte{0x0200: 0x00},
+			wantCycles: 4,
+		},
+		{
+			name:       "STZ abs,X",
+			code:       []byte{0x9e, 0x00, 0x02},
+			regs:       Registers{X: 0x05},
+			mem:        map[uint16]byte{0x0205: 0xff},
+			wantRegs:   Registers{PC: 0x0303, X: 0x05},
+			wantMem:    map[uint16]byte{0x0205: 0x00},
+			wantCycles: 5,
+		},
+		{
+			name:       "TSB zp",
+			code:       []byte{0x04, 0x10},
+			regs:       Registers{A: 0x0f},
+			mem:        map[uint16]byte{0x0010: 0xf0},
+			wantRegs:   Registers{PC: 0x0302, A: 0x0f, P: flagZ},
+			wantMem:    map[uint16]byte{0x0010: 0xff},
+			wantCycles: 5,
+		},
+		{
+			name:       "TRB abs",
+			code:       []byte{0x1c, 0x00, 0x02},
+			regs:       Registers{A: 0x0f},
+			mem:        map[uint16]byte{0x0200: 0x3c},
+			wantRegs:   Registers{PC: 0x0303, A: 0x0f},
+			wantMem:    map[uint16]byte{0x0200: 0x30},
+			wantCycles: 6,
+		},
+		{
+			name:       "INC A",
+			code:       []byte{0x1a},
+			regs:       Registers{A: 0xff},
+			wantRegs:   Registers{PC: 0x0301, P: flagZ},
+			wantCycles: 2,
+		},
+		{
+			name:       "DEC A",
+			code:       []byte{0x3a},
+			wantRegs:   Registers{PC: 0x0301, A: 0xff, P: flagN},
+			wantCycles: 2,
+		},
+		{
+			name:       "LDA (zp)",
+			code:       []byte{0xb2, 0x10},
+			mem:        map[uint16]byte{0x0010: 0x00, 0x0011: 0x02, 0x0200: 0x80},
+			wantRegs:   Registers{PC: 0x0302, A: 0x80, P: flagN},
+			wantCycles: 5,
+		},
+		{
+			name:       "STA (zp)",
+			code:       []byte{0x92, 0x10},
+			regs:       Registers{A: 0x42},
+			mem:        map[uint16]byte{0x0010: 0x34, 0x0011: 0x02},
+			wantRegs:   Registers{PC: 0x0302, A: 0x42},
+			wantMem:    map[uint16]byte{0x0234: 0x42},
+			wantCycles: 5,
+		},
+		{
+			name:       "CMP (zp)",
+			code:       []byte{0xd2, 0x10},
+			regs:       Registers{A: 0x42},
+			mem:        map[uint16]byte{0x0010: 0x00, 0x0011: 0x02, 0x0200: 0x42},
+			wantRegs:   Registers{PC: 0x0302, A: 0x42, P: flagZ | flagC},
+			wantCycles: 5,
+		},
+		{
+			name:       "BIT imm only sets Z",
+			code:       []byte{0x89, 0x01},
+			regs:       Registers{A: 0x02, P: flagN | flagV},
+			wantRegs:   Registers{PC: 0x0302, A: 0x02, P: flagN | flagV | flagZ},
+			wantCycles: 2,
+		},
+		{
+			name:       "BIT zp,X",
+			code:       []byte{0x34, 0x10},
+			regs:       Registers{A: 0xc0, X: 0x01},
+			mem:        map[uint16]byte{0x0011: 0xc0},
+			wantRegs:   Registers{PC: 0x0302, A: 0xc0, X: 0x01, P: flagN | flagV},
+			wantCycles: 4,
+		},
+		{
+			name:       "BIT abs,X",
+			code:       []byte{0x3c, 0x00, 0x02},
+			regs:       Registers{A: 0x01, X: 0x02},
+			mem:        map[uint16]byte{0x0202: 0x40},
+			wantRegs:   Registers{PC: 0x0303, A: 0x01, X: 0x02, P: flagV | flagZ},
+			wantCycles: 4,
+		},
+		{
+			name:       "JMP (abs) doesn't wrap in the page",
+			code:       []byte{0x6c, 0xff, 0x04},
+			mem:        map[uint16]byte{0x04ff: 0x34, 0x0500: 0x12, 0x0400: 0x56},
+			wantRegs:   Registers{PC: 0x1234},
+			wantCycles: 6,
+		},
+		{
+			name:       "JMP (abs,X)",
+			code:       []byte{0x7c, 0x00, 0x02},
+			regs:       Registers{X: 0x02},
+			mem:        map[uint16]byte{0x0202: 0x34, 0x0203: 0x12},
+			wantRegs:   Registers{PC: 0x1234, X: 0x02},
+			wantCycles: 6,
+		},
+		{
+			name:       "RMB3",
+			code:       []byte{0x37, 0x10},
+			mem:        map[uint16]byte{0x0010: 0xff},
+			wantRegs:   Registers{PC: 0x0302},
+			wantMem:    map[uint16]byte{0x0010: 0xf7},
+			wantCycles: 5,
+		},
+		{
+			name:       "SMB7",
+			code:       []byte{0xf7, 0x10},
+			wantRegs:   Registers{PC: 0x0302},
+			wantMem:    map[uint16]byte{0x0010: 0x80},
+			wantCycles: 5,
+		},
+		{
+			name:       "BBR0 taken",
+			code:       []byte{0x0f, 0x10, 0x05},
+			mem:        map[uint16]byte{0x0010: 0xfe},
+			wantRegs:   Registers{PC: 0x0308},
+			wantCycles: 6,
+		},
+		{
+			name:       "BBS0 not taken",
+			code:       []byte{0x8f, 0x10, 0x05},
+			mem:        map[uint16]byte{0x0010: 0xfe},
+			wantRegs:   Registers{PC: 0x0303},
+			wantCycles: 5,
+		},
+		{
+			name:       "BBS6 taken",
+			code:       []byte{0xef, 0x10, 0xfd},
+			mem:        map[uint16]byte{0x0010: 0x40},
+			wantRegs:   Registers{PC: 0x0300},
+			wantCycles: 6,
+		},
+		{
+			name:       "ADC binary",
+			code:       []byte{0x69, 0x50},
+			regs:       Registers{A: 0x50},
+			wantRegs:   Registers{PC: 0x0302, A: 0xa0, P: flagN | flagV},
+			wantCycles: 2,
+		},
+		{
+			name:       "ADC decimal",
+			code:       []byte{0x69, 0x19},
+			regs:       Registers{A: 0x28, P: flagD},
+			wantRegs:   Registers{PC: 0x0302, A: 0x47, P: flagD},
+			wantCycles: 3,
+		},
+		{
+			name:       "ADC decimal sets Z from the result",
+			code:       []byte{0x69, 0x01},
+			regs:       Registers{A: 0x99, P: flagD},
+			wantRegs:   Registers{PC: 0x0302, A: 0x00, P: flagD | flagZ | flagC},
+			wantCycles: 3,
+		},
+		{
+			name:       "ADC (zp) decimal",
+			code:       []byte{0x72, 0x10},
+			regs:       Registers{A: 0x45, P: flagD | flagC},
+			mem:        map[uint16]byte{0x0010: 0x00, 0x0011: 0x02, 0x0200: 0x54},
+			wantRegs:   Registers{PC: 0x0302, A: 0x00, P: flagD | flagZ | flagC | flagV},
+			wantCycles: 6,
+		},
+		{
+			name:       "SBC decimal",
+			code:       []byte{0xe9, 0x01},
+			regs:       Registers{P: flagD | flagC},
+			wantRegs:   Registers{PC: 0x0302, A: 0x99, P: flagD | flagN},
+			wantCycles: 3,
+		},
+		{
+			name:       "SBC (zp) binary",
+			code:       []byte{0xf2, 0x10},
+			regs:       Registers{A: 0x50, P: flagC},
+			mem:        map[uint16]byte{0x0010: 0x00, 0x0011: 0x02, 0x0200: 0x10},
+			wantRegs:   Registers{PC: 0x0302, A: 0x40, P: flagC},
+			wantCycles: 5,
+		},
+		{
+			name:       "BRK clears D",
+			code:       []byte{0x00},
+			regs:       Registers{P: flagD},
+			wantRegs:   Registers{PC: 0x0000, S: 0xfc, P: flagI},
+			wantMem:    map[uint16]byte{0x01ff: 0x03, 0x01fe: 0x02},
+			wantCycles: 7,
+		},
+		{
+			name:       "2 byte NOP",
+			code:       []byte{0x02, 0xff},
+			wantRegs:   Registers{PC: 0x0302},
+			wantCycles: 2,
+		},
+		{
+			name:       "1 cycle NOP",
+			code:       []byte{0x03},
+			wantRegs:   Registers{PC: 0x0301},
+			wantCycles: 1,
+		},
+		{
+			name:       "8 cycle NOP",
+			code:       []byte{0x5c, 0x00, 0x00},
+			wantRegs:   Registers{PC: 0x0303},
+			wantCycles: 8,
+		},
+	})
+}
+
+// the NMOS core keeps the old chip's quirks that the 65C02 fixed
+func TestCPU6502Quirks(t *testing.T) {
+	runCPUTests(t, CPU6502, []cpuTest{
+		{
+			name:       "JMP (abs) wraps in the page",
+			code:       []byte{0x6c, 0xff, 0x04},
+			mem:        map[uint16]byte{0x04ff: 0x34, 0x0500: 0x12, 0x0400: 0x56},
+			wantRegs:   Registers{PC: 0x5634},
+			wantCycles: 5,
+		},
+		{
+			name:       "ADC decimal takes no extra cycle",
+			code:       []byte{0x69, 0x19},
+			regs:       Registers{A: 0x28, P: flagD},
+			wantRegs:   Registers{PC: 0x0302, A: 0x47, P: flagD},
+			wantCycles: 2,
+		},
+		{
+			name:       "BRK leaves D alone",
+			code:       []byte{0x00},
+			regs:       Registers{P: flagD},
+			wantRegs:   Registers{PC: 0x0000, S: 0xfc, P: flagD | flagI},
+			wantCycles: 7,
+		},
+	})
+}
+
+func TestCPU65C02WaitAndStop(t *testing.T) {
+	emu := newCPUTestEmu(t, CPU65C02)
+	// SEI, WAI, then STP
+	emu.loadBinaryToMem(cpuTestOrigin, []byte{0x78, 0xcb, 0xdb})
+
+	emu.core.Step()
+	emu.core.Step()
+	if !emu.CPUHalt.Waiting {
+		t.Fatal("not waiting after WAI")
+	}
+	for i := 0; i < 10; i++ {
+		emu.core.Step()
+	}
+	if pc := emu.core.Registers().PC; pc != 0x0302 {
+		t.Fatalf("ran on to $%04X while waiting", pc)
+	}
+	// with I set, an IRQ just ends the wait
+	emu.core.IRQ()
+	emu.core.Step()
+	if emu.CPUHalt.Waiting || !emu.CPUHalt.Stopped {
+		t.Fatalf("after an IRQ, waiting is %v and stopped %v, want the STP run", emu.CPUHalt.Waiting, emu.CPUHalt.Stopped)
+	}
+
+	emu.core.IRQ()
+	emu.core.NMI()
+	for i := 0; i < 10; i++ {
+		emu.core.Step()
+	}
+	if pc := emu.core.Registers().PC; pc != 0x0303 {
+		t.Fatalf("ran on to $%04X while stopped", pc)
+	}
+	emu.core.Reset()
+	emu.core.Step()
+	if emu.CPUHalt.Stopped {
+		t.Fatal("still stopped after reset")
+	}
+}
+
+func TestCPU65C02FetchesOpcodeOnce(t *testing.T) {
+	// one opcode virt6502 runs, one the 65C02 core runs itself
+	for _, code := range [][]byte{{0xea}, {0x1a}} {
+		emu := newCPUTestEmu(t, CPU65C02)
+		emu.loadBinaryToMem(cpuTestOrigin, code)
+		reads := 0
+		read := emu.CPU.Read
+		emu.CPU.Read = func(addr uint16) byte {
+			if addr == cpuTestOrigin {
+				reads++
+			}
+			return read(addr)
+		}
+		emu.core.Step()
+		// a second read would ack I/O twice, e.g. the CFFA1's data register
+		if reads != 1 {
+			t.Errorf("opcode %02x was read from the bus %v times, want once", code[0], reads)
+		}
+	}
+}
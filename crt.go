@@ -0,0 +1,233 @@
+package a1go
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CRTOptions sets up a CRTFilter. Each effect is 0 for off up to 1 for
+// as strong as it goes.
+type CRTOptions struct {
+	// Scale is how many times bigger than the screen the filter draws,
+	// 2-6, so there's room for scanlines and glow between pixels
+	Scale int
+	// Scanlines darkens the gap at the bottom of each line
+	Scanlines float64
+	// Bloom makes lit pixels glow out sideways, as the beam spreads
+	Bloom float64
+	// Persistence is how much of each frame is left on the next,
+	// for the slow fade of the phosphor
+	Persistence float64
+	// Blur softens everything a little, as the beam isn't a point
+	Blur float64
+}
+
+// DefaultCRTOptions looks like a decent monitor
+var DefaultCRTOptions = CRTOptions{
+	Scale:       3,
+	Scanlines:   0.5,
+	Bloom:       0.3,
+	Persistence: 0.4,
+	Blur:        0.3,
+}
+
+const (
+	minCRTScale = 2
+	maxCRTScale = 6
+)
+
+// ParseCRTOptions reads options like "scanlines=0.6,persistence=0",
+// starting from DefaultCRTOptions. "on" or "" is just the defaults.
+func ParseCRTOptions(spec string) (CRTOptions, error) {
+	opts := DefaultCRTOptions
+	if spec == "" || spec == "on" {
+		return opts, nil
+	}
+	for _, setting := range strings.Split(spec, ",") {
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return opts, fmt.Errorf("bad crt setting %q, want NAME=VALUE", setting)
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if name == "scale" {
+			scale, err := strconv.Atoi(value)
+			if err != nil {
+				return opts, fmt.Errorf("bad crt scale %q", value)
+			}
+			opts.Scale = scale
+			continue
+		}
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return opts, fmt.Errorf("bad crt %v %q", name, value)
+		}
+		switch name {
+		case "scanlines":
+			opts.Scanlines = amount
+		case "bloom":
+			opts.Bloom = amount
+		case "persistence":
+			opts.Persistence = amount
+		case "blur":
+			opts.Blur = amount
+		default:
+			return opts, fmt.Errorf("unknown crt setting %q, want scale, scanlines, bloom, persistence or blur", name)
+		}
+	}
+	return opts, opts.validate()
+}
+
+func (opts CRTOptions) validate() error {
+	if opts.Scale < minCRTScale || opts.Scale > maxCRTScale {
+		return fmt.Errorf("bad crt scale %v, want %v-%v", opts.Scale, minCRTScale, maxCRTScale)
+	}
+	for _, amount := range []float64{opts.Scanlines, opts.Bloom, opts.Persistence, opts.Blur} {
+		if amount < 0 || amount > 1 {
+			return fmt.Errorf("bad crt setting %v, want 0-1", amount)
+		}
+	}
+	return nil
+}
+
+// CRTFilter draws framebuffers as a CRT would show them, on the cpu.
+// It remembers past frames for persistence, so use one per display.
+type CRTFilter struct {
+	opts   CRTOptions
+	w, h   int
+	ow, oh int
+
+	// phosphor is how lit each subpixel of the screen is, after persistence
+	phosphor []float32
+	// decaying is set while the phosphor is still fading from past frames
+	decaying bool
+
+	row, blurred []float32
+	out          []byte
+}
+
+// NewCRTFilter makes a filter for w x h framebuffers
+func NewCRTFilter(w, h int, opts CRTOptions) (*CRTFilter, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	ow, oh := w*opts.Scale, h*opts.Scale
+	return &CRTFilter{
+		opts:     opts,
+		w:        w,
+		h:        h,
+		ow:       ow,
+		oh:       oh,
+		phosphor: make([]float32, w*h*3),
+		row:      make([]float32, (ow+opts.Scale*2)*3),
+		blurred:  make([]float32, ow*oh*3),
+		out:      make([]byte, ow*oh*4),
+	}, nil
+}
+
+// Size is the size of what Apply returns
+func (f *CRTFilter) Size() (int, int) {
+	return f.ow, f.oh
+}
+
+// Decaying says if the last frame is still fading out, so Apply
+// should be called again, even with nothing new on screen
+func (f *CRTFilter) Decaying() bool {
+	return f.decaying
+}
+
+// Apply draws the next frame from fb, an RGBA framebuffer like
+// Emulator.Framebuffer returns. The result is reused by the next call.
+func (f *CRTFilter) Apply(fb []byte) []byte {
+	f.persist(fb)
+	f.blurAndBloom()
+	f.blurDownAndScanlines()
+	return f.out
+}
+
+// persist mixes the new frame into what's left of the old ones
+func (f *CRTFilter) persist(fb []byte) {
+	keep := float32(f.opts.Persistence)
+	f.decaying = false
+	for i := 0; i < f.w*f.h; i++ {
+		for c := 0; c < 3; c++ {
+			lit := float32(fb[i*4+c]) / 0xff
+			faded := f.phosphor[i*3+c] * keep
+			if faded > lit {
+				lit = faded
+				f.decaying = f.decaying || faded > 1.0/0xff
+			}
+			f.phosphor[i*3+c] = lit
+		}
+	}
+}
+
+// blurAndBloom scales up each line, blurs it sideways and spreads a
+// glow out to a pixel's width. The rows of a line all come out the
+// same, so it's done once a line.
+func (f *CRTFilter) blurAndBloom() {
+	scale := f.opts.Scale
+	blur, bloom := float32(f.opts.Blur), float32(f.opts.Bloom)/float32(scale)
+	// the glow fades with distance
+	glowWeights := make([]float32, scale+1)
+	for d := 1; d <= scale; d++ {
+		glowWeights[d] = bloom * float32(scale+1-d) / float32(scale+1)
+	}
+	stride := f.ow * 3
+	// row is padded with black at both ends, so the taps don't run off
+	pad := scale * 3
+	for y := 0; y < f.h; y++ {
+		src := f.phosphor[y*f.w*3:]
+		row := f.row[pad : pad+stride]
+		for x := 0; x < f.ow; x++ {
+			copy(row[x*3:x*3+3], src[(x/scale)*3:])
+		}
+		out := f.blurred[y*scale*stride : (y*scale+1)*stride]
+		for i := range out {
+			at := pad + i
+			v := f.row[at]*(1-blur) + (f.row[at-3]+f.row[at+3])*blur/2
+			for d := 1; d <= scale; d++ {
+				v += (f.row[at-d*3] + f.row[at+d*3]) * glowWeights[d]
+			}
+			out[i] = v
+		}
+		for sy := 1; sy < scale; sy++ {
+			copy(f.blurred[(y*scale+sy)*stride:], out)
+		}
+	}
+}
+
+// blurDownAndScanlines blurs down, more gently than across so the
+// scanlines still show, darkens the last row of each line, and
+// writes out the result
+func (f *CRTFilter) blurDownAndScanlines() {
+	vblur := float32(f.opts.Blur) / 2
+	stride := f.ow * 3
+	for y := 0; y < f.oh; y++ {
+		brightness := float32(0xff)
+		if y%f.opts.Scale == f.opts.Scale-1 {
+			brightness *= 1 - float32(f.opts.Scanlines)
+		}
+		row := f.blurred[y*stride : (y+1)*stride]
+		above, below := row, row
+		if y > 0 {
+			above = f.blurred[(y-1)*stride : y*stride]
+		}
+		if y < f.oh-1 {
+			below = f.blurred[(y+1)*stride : (y+2)*stride]
+		}
+		dst := f.out[y*f.ow*4:]
+		for x := 0; x < f.ow; x++ {
+			for c := 0; c < 3; c++ {
+				i := x*3 + c
+				v := row[i]*(1-vblur) + (above[i]+below[i])*vblur/2
+				v *= brightness
+				if v > 0xff {
+					v = 0xff
+				}
+				dst[x*4+c] = byte(v + 0.5)
+			}
+			dst[x*4+3] = 0xff
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package a1go
+
+import "testing"
+
+// grayFrame makes a w x h RGBA framebuffer from a gray level per pixel
+func grayFrame(w, h int, levels ...byte) []byte {
+	fb := make([]byte, w*h*4)
+	for i, level := range levels {
+		fb[i*4], fb[i*4+1], fb[i*4+2], fb[i*4+3] = level, level, level, 0xff
+	}
+	return fb
+}
+
+// grayLevels is the red channel of each pixel of out, checking the
+// others match it and it's opaque
+func grayLevels(t *testing.T, out []byte) []byte {
+	t.Helper()
+	levels := make([]byte, len(out)/4)
+	for i := range levels {
+		r, g, b, a := out[i*4], out[i*4+1], out[i*4+2], out[i*4+3]
+		if g != r || b != r || a != 0xff {
+			t.Fatalf("pixel %v is %v,%v,%v,%v, want an opaque gray", i, r, g, b, a)
+		}
+		levels[i] = r
+	}
+	return levels
+}
+
+func newTestCRTFilter(t *testing.T, w, h int, opts CRTOptions) *CRTFilter {
+	t.Helper()
+	f, err := NewCRTFilter(w, h, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestCRTFilterEffects(t *testing.T) {
+	tests := []struct {
+		name string
+		opts CRTOptions
+		w, h int
+		in   []byte
+		// want is the output, a row at a time
+		want []byte
+	}{
+		{
+			name: "off",
+			opts: CRTOptions{Scale: 2},
+			w:    2, h: 1,
+			in: []byte{0xff, 0x40},
+			want: []byte{
+				0xff, 0xff, 0x40, 0x40,
+				0xff, 0xff, 0x40, 0x40,
+			},
+		},
+		{
+			name: "scanlines",
+			opts: CRTOptions{Scale: 2, Scanlines: 0.5},
+			w:    1, h: 2,
+			in: []byte{0xff, 0x80},
+			want: []byte{
+				0xff, 0xff,
+				0x80, 0x80,
+				0x80, 0x80,
+				0x40, 0x40,
+			},
+		},
+		{
+			name: "bloom",
+			opts: CRTOptions{Scale: 2, Bloom: 0.5},
+			w:    3, h: 1,
+			in: []byte{0, 0xff, 0},
+			want: []byte{
+				21, 64, 0xff, 0xff, 64, 21,
+				21, 64, 0xff, 0xff, 64, 21,
+			},
+		},
+		{
+			name: "blur",
+			opts: CRTOptions{Scale: 2, Blur: 0.5},
+			w:    1, h: 2,
+			in: []byte{0xff, 0},
+			want: []byte{
+				191, 191,
+				167, 167,
+				24, 24,
+				0, 0,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newTestCRTFilter(t, test.w, test.h, test.opts)
+			if w, h := f.Size(); w != test.w*test.opts.Scale || h != test.h*test.opts.Scale {
+				t.Fatalf("size is %vx%v, want %vx%v", w, h, test.w*test.opts.Scale, test.h*test.opts.Scale)
+			}
+			got := grayLevels(t, f.Apply(grayFrame(test.w, test.h, test.in...)))
+			if string(got) != string(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCRTFilterKeepsColors(t *testing.T) {
+	f := newTestCRTFilter(t, 1, 1, CRTOptions{Scale: 2, Scanlines: 0.5})
+	out := f.Apply([]byte{0xff, 0x80, 0, 0xff})
+	want := []byte{
+		0xff, 0x80, 0, 0xff, 0xff, 0x80, 0, 0xff,
+		0x80, 0x40, 0, 0xff, 0x80, 0x40, 0, 0xff,
+	}
+	if string(out) != string(want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+}
+
+func TestCRTFilterPersistence(t *testing.T) {
+	f := newTestCRTFilter(t, 1, 1, CRTOptions{Scale: 2, Persistence: 0.5})
+	frames := []struct {
+		in       byte
+		want     byte
+		decaying bool
+	}{
+		{0xff, 0xff, false},
+		{0, 0x80, true},
+		{0, 0x40, true},
+		{0x10, 0x20, true},
+		{0, 0x10, true},
+		{0xff, 0xff, false},
+	}
+	for i, frame := range frames {
+		got := grayLevels(t, f.Apply(grayFrame(1, 1, frame.in)))
+		for _, level := range got {
+			if level != frame.want {
+				t.Fatalf("frame %v: got %v, want all %v", i, got, frame.want)
+			}
+		}
+		if f.Decaying() != frame.decaying {
+			t.Errorf("frame %v: decaying is %v, want %v", i, f.Decaying(), frame.decaying)
+		}
+	}
+
+	// it fades out for good after enough black frames
+	for i := 0; i < 16; i++ {
+		f.Apply(grayFrame(1, 1, 0))
+	}
+	if f.Decaying() {
+		t.Error("still decaying after 16 black frames")
+	}
+	if got := grayLevels(t, f.Apply(grayFrame(1, 1, 0))); got[0] != 0 {
+		t.Errorf("faded out to %v, want 0", got[0])
+	}
+}
+
+func TestParseCRTOptions(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    CRTOptions
+		wantErr bool
+	}{
+		{spec: "", want: DefaultCRTOptions},
+		{spec: "on", want: DefaultCRTOptions},
+		{spec: "scanlines=0.6, persistence=0", want: CRTOptions{Scale: 3, Scanlines: 0.6, Bloom: 0.3, Persistence: 0, Blur: 0.3}},
+		{spec: "scale=5,bloom=1,blur=0", want: CRTOptions{Scale: 5, Scanlines: 0.5, Bloom: 1, Persistence: 0.4, Blur: 0}},
+		{spec: "scanlines", wantErr: true},
+		{spec: "scanlines=lots", wantErr: true},
+		{spec: "bloom=1.5", wantErr: true},
+		{spec: "blur=-0.1", wantErr: true},
+		{spec: "scale=1", wantErr: true},
+		{spec: "scale=2.5", wantErr: true},
+		{spec: "sparkle=1", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseCRTOptions(test.spec)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseCRTOptions(%q) = %+v, want an error", test.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCRTOptions(%q): %v", test.spec, err)
+		} else if got != test.want {
+			t.Errorf("ParseCRTOptions(%q) = %+v, want %+v", test.spec, got, test.want)
+		}
+	}
+}
@@ -1,18 +1,98 @@
 package a1go
 
+import (
+	"io"
+)
+
 // Emulator exposes the public facing fns for an emulation session
 type Emulator interface {
+	// Step runs exactly one instruction. Unlike RunFrame and RunCycles,
+	// it never skips ahead while the cpu waits on a keypress, so it's
+	// what debuggers and tests should single step with.
 	Step()
 
+	// RunFrame updates input, then runs until the end of the current frame
+	RunFrame(input Input)
+	// RunCycles runs instructions until at least the given number of cycles have passed
+	RunCycles(cycles uint64)
+	// ExitRequested reports if guest code asked the host to exit, via
+	// the host services device, and with what status
+	ExitRequested() (int, bool)
+	// IsIdle reports if the last frame was skipped through while
+	// the cpu waited on a keypress. Frontends can sleep until new
+	// input arrives when this is true.
+	IsIdle() bool
+
+	// ClockHz is the emulated cpu's clock speed
+	ClockHz() int
+	// CycleCount is the number of cycles run since power-on
+	CycleCount() uint64
+
+	// CPUType is the cpu core this session runs
+	CPUType() CPUType
+	// Registers returns the cpu's current registers
+	Registers() Registers
+	// SetRegisters sets the cpu's registers, e.g. to call a routine
+	SetRegisters(r Registers)
+	// RAMLayout returns the populated RAM ranges
+	RAMLayout() []AddrRange
+
+	// ReadMem reads a byte for a debugger: I/O reads don't ack
+	// keypresses, cards only show their ROM, and holes read as 0xff
+	ReadMem(addr uint16) byte
+	// WriteMem writes a byte as the cpu would, except that writes
+	// to holes are dropped
+	WriteMem(addr uint16, val byte)
 	LoadBinaryToMem(addr uint16, bin []byte) error
+	// LoadROM maps in a ROM image. Images in RAM areas are copied into RAM.
+	LoadROM(addr uint16, rom []byte) error
+	// LoadMonitorROM replaces the built-in Woz Monitor with an image
+	// ending at $FFFF, from one page up to all of $F000-$FFFF.
+	// ROMs loaded over it, e.g. by ROMManager.LoadSet, don't replace
+	// it. Takes effect on the next reset.
+	LoadMonitorROM(rom []byte) error
+	// LoadBasicProgram tokenizes an Integer BASIC program's text and puts
+	// it in memory ready to LIST or RUN. If BASIC hasn't been started yet,
+	// enter it with a warm start (E2B3R) to keep the program.
+	LoadBasicProgram(src []byte) error
+	// ListBasicProgram returns the text of the Integer BASIC program in memory
+	ListBasicProgram() (string, error)
 
 	MakeSnapshot() []byte
+	// LoadSnapshot makes a new emulator from a snapshot, to replace
+	// this one. Cards' host files are handed over to it, or closed.
 	LoadSnapshot([]byte) (Emulator, error)
 
 	Framebuffer() []byte
 	FlipRequested() bool
+	// Screenshot writes the screen as a PNG, each pixel scaled up to a
+	// scale x scale block, in the theme's colors
+	Screenshot(w io.Writer, scale int) error
+	// SetTheme sets the colors for Framebuffer, screenshots and the
+	// like, white on black by default
+	SetTheme(t Theme)
+	Theme() Theme
+	// ScreenSize is the size of the Framebuffer in pixels
+	ScreenSize() (int, int)
+	// ScreenText returns what's on screen as 24 lines of up to 40 chars
+	ScreenText() string
+	// ScrollbackLines is how many lines have scrolled off the top of
+	// the screen and can be looked back on, up to the last 1000
+	ScrollbackLines() int
+	// ScrollbackText and ScrollbackFramebuffer are like ScreenText and
+	// Framebuffer, with the screen scrolled back by the given number of
+	// lines. They don't change what's on screen.
+	ScrollbackText(back int) string
+	ScrollbackFramebuffer(back int) []byte
+	// WatchOutput calls fn with each char the cpu writes to the display,
+	// as the display shows it, with '\r' for newlines. Watchers carry
+	// over to emulators made by LoadSnapshot.
+	WatchOutput(fn func(char byte))
 
 	UpdateInput(input Input)
+	// Type queues text to be typed in, as with autokey input: lowercase
+	// is typed as uppercase, and backspace as the _ rubout.
+	Type(text []byte)
 }
 
 // Input covers all outside info sent to the Emulator
@@ -27,6 +107,10 @@ func (emu *emuState) UpdateInput(input Input) {
 	emu.updateInput(input)
 }
 
+func (emu *emuState) Type(text []byte) {
+	emu.autokeyInput = append(emu.autokeyInput, text...)
+}
+
 // NewEmulator creates an emulation session
 func NewEmulator() Emulator {
 	return newState()
@@ -34,13 +118,80 @@ func NewEmulator() Emulator {
 
 // NewEmulatorWithAutokeyInput creates an emulation session with input to be autokeyed in from the start
 func NewEmulatorWithAutokeyInput(input []byte) Emulator {
-	return newStateWithAutokeyInput(input)
+	emu := newState()
+	emu.autokeyInput = input
+	return emu
+}
+
+// Options configures a new emulation session
+type Options struct {
+	// ClockHz is the cpu clock speed. Zero means a stock Apple-1's ~1.023MHz.
+	ClockHz int
+	// CPU picks the cpu core. Empty means CPU6502.
+	CPU CPUType
+	// AutokeyInput is typed in from the start, as if by a very fast typist
+	AutokeyInput []byte
+	// RefreshStealing stalls the cpu for DRAM refresh the way a real
+	// board does, so it runs at ~0.96MHz effective instead of ~1.023MHz.
+	RefreshStealing bool
+	// MonitorROM replaces the built-in Woz Monitor, see LoadMonitorROM
+	MonitorROM []byte
+	// RAM is where RAM is populated. Nil means everywhere it can be,
+	// i.e. $0000-$BFFF and $E000-$EFFF.
+	RAM []AddrRange
+	// Peripherals are the expansion cards to install
+	Peripherals []PeripheralConfig
+	// Console bridges the keyboard and display to a host link as well,
+	// as a spec like "tcp::6501" or "pty", see HostLinkSpecHelp
+	Console string
+	// Font replaces the built-in 2513 character generator, see LoadFont
+	Font *Font
+	// LowercaseMod shows lowercase as lowercase, like some clones do,
+	// instead of as uppercase like a stock Apple-1
+	LowercaseMod bool
+	// HostOutput is where the host services card prints. Nil means
+	// stdout, which suits headless runs, but frontends that draw on
+	// the terminal should give their own.
+	HostOutput io.Writer
+}
+
+// NewEmulatorWithOptions creates an emulation session configured by opts
+func NewEmulatorWithOptions(opts Options) (Emulator, error) {
+	emu, err := newStateWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return emu, nil
+}
+
+func (emu *emuState) ReadMem(addr uint16) byte {
+	return emu.peek(addr)
+}
+
+func (emu *emuState) WriteMem(addr uint16, val byte) {
+	emu.poke(addr, val)
 }
 
 func (emu *emuState) LoadBinaryToMem(addr uint16, bin []byte) error {
 	return emu.loadBinaryToMem(addr, bin)
 }
 
+func (emu *emuState) LoadROM(addr uint16, rom []byte) error {
+	return emu.loadROM(addr, rom)
+}
+
+func (emu *emuState) LoadMonitorROM(rom []byte) error {
+	return emu.loadMonitorROM(rom)
+}
+
+func (emu *emuState) LoadBasicProgram(src []byte) error {
+	return emu.loadBasicProgram(src)
+}
+
+func (emu *emuState) ListBasicProgram() (string, error) {
+	return emu.listBasicProgram()
+}
+
 func (emu *emuState) MakeSnapshot() []byte {
 	return emu.makeSnapshot()
 }
@@ -60,6 +211,89 @@ func (emu *emuState) FlipRequested() bool {
 	return emu.flipRequested()
 }
 
+func (emu *emuState) Screenshot(w io.Writer, scale int) error {
+	return emu.screenshot(w, scale)
+}
+
+func (emu *emuState) SetTheme(t Theme) {
+	emu.theme = t
+	emu.Terminal.flipRequested = true
+}
+
+func (emu *emuState) Theme() Theme {
+	return emu.currentTheme()
+}
+
+func (emu *emuState) ScreenSize() (int, int) {
+	return emu.Terminal.W, emu.Terminal.H
+}
+
+func (emu *emuState) ScreenText() string {
+	return emu.Terminal.text()
+}
+
+func (emu *emuState) ScrollbackLines() int {
+	return len(emu.Terminal.history)
+}
+
+func (emu *emuState) ScrollbackText(back int) string {
+	return rowsText(emu.Terminal.scrolledBack(back))
+}
+
+func (emu *emuState) ScrollbackFramebuffer(back int) []byte {
+	return emu.themedScrollbackFramebuffer(back)
+}
+
+func (emu *emuState) WatchOutput(fn func(char byte)) {
+	emu.outputWatchers = append(emu.outputWatchers, fn)
+}
+
 func (emu *emuState) Step() {
-	emu.step()
+	emu.stepInstruction()
+}
+
+func (emu *emuState) RunFrame(input Input) {
+	emu.runFrame(input)
+}
+
+func (emu *emuState) RunCycles(cycles uint64) {
+	emu.runForCycles(cycles)
+}
+
+func (emu *emuState) ExitRequested() (int, bool) {
+	if emu.HostSvc == nil || !emu.HostSvc.Exited {
+		return 0, false
+	}
+	return emu.HostSvc.ExitCode, true
+}
+
+func (emu *emuState) IsIdle() bool {
+	return emu.idle.frameWasIdle
+}
+
+func (emu *emuState) ClockHz() int {
+	return emu.clockHz()
+}
+
+func (emu *emuState) CycleCount() uint64 {
+	return emu.Cycles
+}
+
+func (emu *emuState) CPUType() CPUType {
+	if emu.CPUVariant == "" {
+		return CPU6502
+	}
+	return emu.CPUVariant
+}
+
+func (emu *emuState) Registers() Registers {
+	return emu.core.Registers()
+}
+
+func (emu *emuState) SetRegisters(r Registers) {
+	emu.core.SetRegisters(r)
+}
+
+func (emu *emuState) RAMLayout() []AddrRange {
+	return emu.Mem.ramLayout()
 }
@@ -0,0 +1,310 @@
+package a1go
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Font is a character generator's glyphs, for the display to draw
+// chars with in place of the built-in 2513's. See Options.Font.
+type Font struct {
+	font font
+}
+
+// the display's layout has room for 5x7 glyphs and no bigger
+const (
+	glyphW = 5
+	glyphH = 7
+)
+
+// FontFormatHelp describes the files LoadFont takes
+const FontFormatHelp = `font files:
+  *.png  a glyph sheet of the 96 chars from space to DEL, 16 across
+         and 6 down. Each glyph is the top left 5x7 of its cell, so
+         cells can have spacing, and light pixels are lit.
+  *.bdf  a BDF font, with glyphs no bigger than 5x7 once placed in
+         the font's bounding box
+  other  a raw character ROM dump: 512 bytes, 8 rows a char, of the
+         64 chars of a 2513, in its order ('@' to '_', then ' ' to
+         '?'), or 1024 bytes of 128 chars in ASCII order, for clone
+         ROMs with lowercase. Whichever 5 bits and 7 rows are used,
+         and which way round, is worked out from the glyphs.`
+
+// LoadFont loads a font file, with the format picked by the
+// extension, see FontFormatHelp
+func LoadFont(path string) (*Font, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f *Font
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		f, err = ParsePNGFont(bytes.NewReader(data))
+	case ".bdf":
+		f, err = ParseBDFFont(bytes.NewReader(data))
+	default:
+		f, err = ParseCharROM(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return f, nil
+}
+
+// glyphCell is a glyph as loaded, before it's cut down to 5x7
+type glyphCell struct {
+	w, h int
+	pix  []bool
+}
+
+func (c glyphCell) at(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.w && y < c.h && c.pix[y*c.w+x]
+}
+
+// makeFont takes the top left 5x7 of each cell as its glyph
+func makeFont(cells map[rune]glyphCell) (*Font, error) {
+	f := &Font{font{w: glyphW, h: glyphH, glyphs: map[rune][]byte{}}}
+	for char, cell := range cells {
+		glyph := make([]byte, glyphW*glyphH)
+		for y := 0; y < cell.h; y++ {
+			for x := 0; x < cell.w; x++ {
+				if !cell.at(x, y) {
+					continue
+				}
+				if x >= glyphW || y >= glyphH {
+					return nil, fmt.Errorf("glyph %q is bigger than %vx%v", char, glyphW, glyphH)
+				}
+				glyph[y*glyphW+x] = 1
+			}
+		}
+		f.font.glyphs[char] = glyph
+	}
+	if len(f.font.glyphs) == 0 {
+		return nil, fmt.Errorf("no glyphs found")
+	}
+	return f, nil
+}
+
+// ParseCharROM reads a raw character ROM dump, see FontFormatHelp
+func ParseCharROM(rom []byte) (*Font, error) {
+	var charFor func(i int) (rune, bool)
+	switch len(rom) {
+	case 64 * 8:
+		charFor = func(i int) (rune, bool) {
+			return a1KeyMap[rune(i)], true
+		}
+	case 128 * 8:
+		charFor = func(i int) (rune, bool) {
+			return rune(i), i >= ' '
+		}
+	default:
+		return nil, fmt.Errorf("char rom is %v bytes, want 512 (64 chars) or 1024 (128 chars) at 8 bytes a char", len(rom))
+	}
+
+	// which bits are used, and which rows
+	used := byte(0)
+	for _, b := range rom {
+		used |= b
+	}
+	lowBit := 0
+	for lowBit < 8 && used&(1<<uint(lowBit)) == 0 {
+		lowBit++
+	}
+	if lowBit+glyphW < 8 && used>>uint(lowBit+glyphW) != 0 {
+		return nil, fmt.Errorf("char rom uses more than %v bits a row", glyphW)
+	}
+	if lowBit > 8-glyphW {
+		lowBit = 8 - glyphW
+	}
+	rowUsed := func(row int) bool {
+		for i := row; i < len(rom); i += 8 {
+			if rom[i]>>uint(lowBit)&0x1f != 0 {
+				return true
+			}
+		}
+		return false
+	}
+	firstRow := 0
+	if !rowUsed(0) {
+		firstRow = 1
+	} else if rowUsed(7) {
+		return nil, fmt.Errorf("char rom uses all 8 rows, want 7")
+	}
+
+	// an L's upright is on its left, so that's which way round it is
+	lIndex := 'L' - '@'
+	if len(rom) == 128*8 {
+		lIndex = 'L'
+	}
+	lHigh, lLow := 0, 0
+	for row := 0; row < glyphH; row++ {
+		b := rom[int(lIndex)*8+firstRow+row] >> uint(lowBit)
+		lHigh += int(b >> 4 & 1)
+		lLow += int(b & 1)
+	}
+	highIsLeft := lHigh >= lLow
+
+	cells := map[rune]glyphCell{}
+	for i := 0; i < len(rom)/8; i++ {
+		char, ok := charFor(i)
+		if !ok {
+			continue
+		}
+		cell := glyphCell{w: glyphW, h: glyphH, pix: make([]bool, glyphW*glyphH)}
+		for row := 0; row < glyphH; row++ {
+			b := rom[i*8+firstRow+row] >> uint(lowBit)
+			for x := 0; x < glyphW; x++ {
+				bit := uint(x)
+				if highIsLeft {
+					bit = uint(glyphW - 1 - x)
+				}
+				cell.pix[row*glyphW+x] = b>>bit&1 == 1
+			}
+		}
+		cells[char] = cell
+	}
+	return makeFont(cells)
+}
+
+// ParsePNGFont reads a PNG glyph sheet, see FontFormatHelp
+func ParsePNGFont(r io.Reader) (*Font, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	const across, down = 16, 6
+	bounds := img.Bounds()
+	cellW, cellH := bounds.Dx()/across, bounds.Dy()/down
+	if cellW*across != bounds.Dx() || cellH*down != bounds.Dy() || cellW < glyphW || cellH < glyphH {
+		return nil, fmt.Errorf("glyph sheet is %vx%v, want 16x6 cells of at least %vx%v", bounds.Dx(), bounds.Dy(), glyphW, glyphH)
+	}
+	cells := map[rune]glyphCell{}
+	for i := 0; i < across*down; i++ {
+		cell := glyphCell{w: cellW, h: cellH, pix: make([]bool, cellW*cellH)}
+		left, top := bounds.Min.X+(i%across)*cellW, bounds.Min.Y+(i/across)*cellH
+		for y := 0; y < cellH; y++ {
+			for x := 0; x < cellW; x++ {
+				r, g, b, _ := img.At(left+x, top+y).RGBA()
+				cell.pix[y*cellW+x] = (r+g+b)/3 >= 0x8000
+			}
+		}
+		cells[rune(' '+i)] = cell
+	}
+	return makeFont(cells)
+}
+
+// ParseBDFFont reads a BDF font, see FontFormatHelp
+func ParseBDFFont(r io.Reader) (*Font, error) {
+	var boxW, boxH, boxX, boxY int
+	cells := map[rune]glyphCell{}
+
+	var char rune
+	var bbxW, bbxH, bbxX, bbxY int
+	var bitmap []string
+	inBitmap := false
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		ints := func(n int) ([]int, error) {
+			if len(fields) < n+1 {
+				return nil, fmt.Errorf("line %v: %v needs %v numbers", lineNum, fields[0], n)
+			}
+			vals := make([]int, n)
+			for i := range vals {
+				v, err := strconv.Atoi(fields[i+1])
+				if err != nil {
+					return nil, fmt.Errorf("line %v: bad number %q", lineNum, fields[i+1])
+				}
+				vals[i] = v
+			}
+			return vals, nil
+		}
+		switch {
+		case inBitmap && fields[0] == "ENDCHAR":
+			inBitmap = false
+			if char < 0 {
+				continue
+			}
+			cell, err := bdfCell(bitmap, bbxW, bbxH, bbxX-boxX, (boxH+boxY)-(bbxH+bbxY), boxW, boxH)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %v", lineNum, err)
+			}
+			cells[char] = cell
+		case inBitmap:
+			bitmap = append(bitmap, fields[0])
+		case fields[0] == "FONTBOUNDINGBOX":
+			vals, err := ints(4)
+			if err != nil {
+				return nil, err
+			}
+			boxW, boxH, boxX, boxY = vals[0], vals[1], vals[2], vals[3]
+		case fields[0] == "STARTCHAR":
+			char, bbxW, bbxH, bbxX, bbxY = -1, boxW, boxH, boxX, boxY
+		case fields[0] == "ENCODING":
+			vals, err := ints(1)
+			if err != nil {
+				return nil, err
+			}
+			// only ASCII matters to the Apple-1
+			if vals[0] >= ' ' && vals[0] < 0x7f {
+				char = rune(vals[0])
+			}
+		case fields[0] == "BBX":
+			vals, err := ints(4)
+			if err != nil {
+				return nil, err
+			}
+			bbxW, bbxH, bbxX, bbxY = vals[0], vals[1], vals[2], vals[3]
+		case fields[0] == "BITMAP":
+			inBitmap, bitmap = true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if boxW == 0 || boxH == 0 {
+		return nil, fmt.Errorf("not a BDF font, no FONTBOUNDINGBOX")
+	}
+	return makeFont(cells)
+}
+
+// bdfCell places a glyph's bitmap, w x h with its top left at x, y,
+// in a boxW x boxH cell
+func bdfCell(bitmap []string, w, h, x, y, boxW, boxH int) (glyphCell, error) {
+	cell := glyphCell{w: boxW, h: boxH, pix: make([]bool, boxW*boxH)}
+	for row, hex := range bitmap {
+		if row >= h {
+			break
+		}
+		bits, err := strconv.ParseUint(hex, 16, 64)
+		if err != nil || len(hex) > 16 {
+			return cell, fmt.Errorf("bad bitmap row %q", hex)
+		}
+		rowBits := len(hex) * 4
+		for col := 0; col < w && col < rowBits; col++ {
+			if bits>>uint(rowBits-1-col)&1 == 0 {
+				continue
+			}
+			cx, cy := x+col, y+row
+			if cx < 0 || cy < 0 || cx >= boxW || cy >= boxH {
+				return cell, fmt.Errorf("glyph is outside the font's bounding box")
+			}
+			cell.pix[cy*boxW+cx] = true
+		}
+	}
+	return cell, nil
+}
@@ -0,0 +1,305 @@
+package a1go
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lowerA is a glyph the built-in font doesn't have, for clone ROMs
+var lowerA = []byte{
+	0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0,
+	0, 1, 1, 1, 0,
+	0, 0, 0, 0, 1,
+	0, 1, 1, 1, 1,
+	1, 0, 0, 0, 1,
+	0, 1, 1, 1, 1,
+}
+
+// testCharROM lays out the built-in font (and lowerA, if there's room)
+// as a char ROM: n chars of 8 rows, each row's 5 pixels at bits
+// lowBit up, starting firstRow down, and left to right either from the
+// high bit or the low bit
+func testCharROM(n, lowBit, firstRow int, highIsLeft bool) []byte {
+	rom := make([]byte, n*8)
+	for i := 0; i < n; i++ {
+		char := rune(i)
+		if n == 64 {
+			char = a1KeyMap[rune(i)]
+		}
+		glyph, ok := a1Font5x7.glyphs[char]
+		if char == 'a' {
+			glyph, ok = lowerA, true
+		}
+		if !ok {
+			continue
+		}
+		for y := 0; y < glyphH; y++ {
+			row := byte(0)
+			for x := 0; x < glyphW; x++ {
+				if glyph[y*glyphW+x] == 0 {
+					continue
+				}
+				bit := x
+				if highIsLeft {
+					bit = glyphW - 1 - x
+				}
+				row |= 1 << uint(bit)
+			}
+			rom[i*8+firstRow+y] = row << uint(lowBit)
+		}
+	}
+	return rom
+}
+
+// checkBuiltinGlyphs checks f has the built-in font's glyphs
+func checkBuiltinGlyphs(t *testing.T, f *Font) {
+	t.Helper()
+	for char, want := range a1Font5x7.glyphs {
+		if got := f.font.glyphs[char]; !bytes.Equal(got, want) {
+			t.Errorf("glyph %q is %v, want %v", char, got, want)
+		}
+	}
+}
+
+func TestParseCharROM(t *testing.T) {
+	tests := []struct {
+		name       string
+		chars      int
+		lowBit     int
+		firstRow   int
+		highIsLeft bool
+	}{
+		{"2513 layout", 64, 0, 0, true},
+		{"high bits, second row down", 64, 3, 1, true},
+		{"mirrored", 64, 1, 0, false},
+		{"ascii order with lowercase", 128, 2, 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := ParseCharROM(testCharROM(test.chars, test.lowBit, test.firstRow, test.highIsLeft))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkBuiltinGlyphs(t, f)
+			_, hasLowerA := f.font.glyphs['a']
+			if wantLowerA := test.chars == 128; hasLowerA != wantLowerA {
+				t.Errorf("has 'a' is %v, want %v", hasLowerA, wantLowerA)
+			}
+			if hasLowerA && !bytes.Equal(f.font.glyphs['a'], lowerA) {
+				t.Errorf("glyph 'a' is %v, want %v", f.font.glyphs['a'], lowerA)
+			}
+		})
+	}
+}
+
+func TestParseCharROMErrors(t *testing.T) {
+	eightRows := testCharROM(64, 0, 0, true)
+	eightRows[7] = 0x01
+	sixBits := testCharROM(64, 0, 0, true)
+	sixBits[0] = 0x20
+
+	tests := []struct {
+		name    string
+		rom     []byte
+		wantErr string
+	}{
+		{"wrong size", make([]byte, 2048), "want 512"},
+		{"8 rows", eightRows, "all 8 rows"},
+		{"6 bits", sixBits, "more than 5 bits"},
+	}
+	for _, test := range tests {
+		if _, err := ParseCharROM(test.rom); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%v: got error %v, want one mentioning %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+// testPNGSheet draws the built-in font as a glyph sheet of cellW x
+// cellH cells, plus a stray pixel at the given spot in the cell for '~'
+func testPNGSheet(t *testing.T, cellW, cellH, strayX, strayY int) []byte {
+	img := image.NewGray(image.Rect(0, 0, cellW*16, cellH*6))
+	for i := 0; i < 96; i++ {
+		left, top := (i%16)*cellW, (i/16)*cellH
+		if glyph, ok := a1Font5x7.glyphs[rune(' '+i)]; ok {
+			for y := 0; y < glyphH; y++ {
+				for x := 0; x < glyphW; x++ {
+					if glyph[y*glyphW+x] != 0 {
+						img.SetGray(left+x, top+y, color.Gray{0xff})
+					}
+				}
+			}
+		}
+		if rune(' '+i) == '~' && strayX >= 0 {
+			img.SetGray(left+strayX, top+strayY, color.Gray{0xc0})
+		}
+	}
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParsePNGFont(t *testing.T) {
+	f, err := ParsePNGFont(bytes.NewReader(testPNGSheet(t, 6, 8, 4, 6)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBuiltinGlyphs(t, f)
+	tilde := make([]byte, glyphW*glyphH)
+	tilde[6*glyphW+4] = 1
+	if !bytes.Equal(f.font.glyphs['~'], tilde) {
+		t.Errorf("glyph '~' is %v, want %v", f.font.glyphs['~'], tilde)
+	}
+
+	tests := []struct {
+		name    string
+		png     []byte
+		wantErr string
+	}{
+		{"not a png", []byte("GIF89a"), "EOF"},
+		{"cells too small", testPNGSheet(t, 4, 8, -1, 0), "want 16x6 cells"},
+		{"glyph too wide", testPNGSheet(t, 6, 8, 5, 0), "bigger than 5x7"},
+		{"glyph too tall", testPNGSheet(t, 6, 8, 0, 7), "bigger than 5x7"},
+	}
+	for _, test := range tests {
+		if _, err := ParsePNGFont(bytes.NewReader(test.png)); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%v: got error %v, want one mentioning %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+const testBDF = `STARTFONT 2.1
+FONT -test-fixed
+SIZE 7 75 75
+FONTBOUNDINGBOX 5 7 0 -1
+CHARS 3
+STARTCHAR A
+ENCODING 65
+BBX 5 7 0 -1
+BITMAP
+20
+50
+88
+88
+F8
+88
+88
+ENDCHAR
+STARTCHAR period
+ENCODING 46
+BBX 1 1 2 -1
+BITMAP
+80
+ENDCHAR
+STARTCHAR snowman
+ENCODING 9731
+BBX 5 7 0 -1
+BITMAP
+F8
+F8
+F8
+F8
+F8
+F8
+F8
+ENDCHAR
+ENDFONT
+`
+
+func TestParseBDFFont(t *testing.T) {
+	f, err := ParseBDFFont(strings.NewReader(testBDF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[rune][]byte{
+		'A': {
+			0, 0, 1, 0, 0,
+			0, 1, 0, 1, 0,
+			1, 0, 0, 0, 1,
+			1, 0, 0, 0, 1,
+			1, 1, 1, 1, 1,
+			1, 0, 0, 0, 1,
+			1, 0, 0, 0, 1,
+		},
+		// placed by its BBX, on the baseline
+		'.': {
+			0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0,
+			0, 0, 1, 0, 0,
+		},
+	}
+	if len(f.font.glyphs) != len(want) {
+		t.Errorf("got %v glyphs, want %v (non-ascii ones are skipped)", len(f.font.glyphs), len(want))
+	}
+	for char, glyph := range want {
+		if !bytes.Equal(f.font.glyphs[char], glyph) {
+			t.Errorf("glyph %q is %v, want %v", char, f.font.glyphs[char], glyph)
+		}
+	}
+}
+
+func TestParseBDFFontErrors(t *testing.T) {
+	tests := []struct {
+		name, bdf, wantErr string
+	}{
+		{"no bounding box", "STARTFONT 2.1\nENDFONT\n", "no FONTBOUNDINGBOX"},
+		{"bad number", "FONTBOUNDINGBOX 5 x 0 0\n", `bad number "x"`},
+		{"short BBX", "FONTBOUNDINGBOX 5 7 0 0\nSTARTCHAR A\nBBX 5 7\n", "BBX needs 4 numbers"},
+		{"bad bitmap", "FONTBOUNDINGBOX 5 7 0 0\nSTARTCHAR A\nENCODING 65\nBITMAP\nZZ\nENDCHAR\n", `bad bitmap row "ZZ"`},
+		{"outside the box", "FONTBOUNDINGBOX 5 7 0 0\nSTARTCHAR A\nENCODING 65\nBBX 6 1 0 0\nBITMAP\n04\nENDCHAR\n", "outside the font's bounding box"},
+		{"too big", "FONTBOUNDINGBOX 6 7 0 0\nSTARTCHAR A\nENCODING 65\nBITMAP\n04\nENDCHAR\n", "bigger than 5x7"},
+		{"no glyphs", "FONTBOUNDINGBOX 5 7 0 0\n", "no glyphs"},
+	}
+	for _, test := range tests {
+		if _, err := ParseBDFFont(strings.NewReader(test.bdf)); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%v: got error %v, want one mentioning %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestLoadFont(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"font.PNG": testPNGSheet(t, 5, 7, -1, 0),
+		"font.bdf": []byte(testBDF),
+		"2513.bin": testCharROM(64, 0, 0, true),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := LoadFont(path)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if len(f.font.glyphs['A']) != glyphW*glyphH {
+			t.Errorf("%v: no 'A' glyph", name)
+		}
+	}
+
+	// errors say which file
+	bad := filepath.Join(dir, "bad.bdf")
+	if err := ioutil.WriteFile(bad, []byte("not a font"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFont(bad); err == nil || !strings.HasPrefix(err.Error(), bad+": ") {
+		t.Errorf("got error %v, want one starting with the path", err)
+	}
+	if _, err := LoadFont(filepath.Join(dir, "missing.bin")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
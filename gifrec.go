@@ -0,0 +1,182 @@
+package a1go
+
+import (
+	"bufio"
+	"bytes"
+	"compress/lzw"
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// GIF delays are in centiseconds, and most viewers slow anything
+// under 2cs right down, so frames are never shown for less than that.
+const gifMinDelay = 2
+
+// GIFRecorder streams the screen to an animated GIF, a frame at a time,
+// so long recordings don't pile up in memory. Frames are timed in
+// emulated time, so recordings made in turbo play back at normal speed.
+type GIFRecorder struct {
+	w          *bufio.Writer
+	scale      int
+	w16, h16   uint16
+	elideDups  bool
+	frameCount int
+
+	// time is emulated time so far, in centiseconds
+	time      float64
+	lastCycle uint64
+
+	// pending is the latest frame, written once it's known how long it's shown for
+	pending      []byte
+	pendingStart float64
+
+	err error
+}
+
+// NewGIFRecorder starts a GIF recording of emu's screen, scaled up by
+// scale, in its theme's colors. With elideDuplicates, frames that
+// don't change anything are folded into the one before.
+func NewGIFRecorder(w io.Writer, emu Emulator, scale int, elideDuplicates bool) (*GIFRecorder, error) {
+	if scale < 1 || scale > maxScreenshotScale {
+		return nil, fmt.Errorf("bad recording scale %v, want 1-%v", scale, maxScreenshotScale)
+	}
+	width, height := emu.ScreenSize()
+	r := &GIFRecorder{
+		w:         bufio.NewWriter(w),
+		scale:     scale,
+		w16:       uint16(width * scale),
+		h16:       uint16(height * scale),
+		elideDups: elideDuplicates,
+		lastCycle: emu.CycleCount(),
+	}
+	theme := emu.Theme()
+
+	r.write([]byte("GIF89a"))
+	// logical screen, with a 2 color global color table
+	r.write16(r.w16)
+	r.write16(r.h16)
+	r.write([]byte{0xf0, 0, 0})
+	fg, bg := theme.Foreground, theme.Background
+	r.write([]byte{bg.R, bg.G, bg.B, fg.R, fg.G, fg.B})
+	// loop forever
+	r.write([]byte{0x21, 0xff, 11})
+	r.write([]byte("NETSCAPE2.0"))
+	r.write([]byte{3, 1, 0, 0, 0})
+	return r, r.err
+}
+
+func (r *GIFRecorder) write(b []byte) {
+	if r.err == nil {
+		_, r.err = r.w.Write(b)
+	}
+}
+
+func (r *GIFRecorder) write16(v uint16) {
+	r.write([]byte{byte(v), byte(v >> 8)})
+}
+
+// Capture adds the screen as it is now. Call it whenever emu's
+// FlipRequested says there's something new to show.
+func (r *GIFRecorder) Capture(emu Emulator) error {
+	// a snapshot load can turn back the clock, so just count what's
+	// gone by since the last capture
+	cycle := emu.CycleCount()
+	if cycle > r.lastCycle {
+		r.time += float64(cycle-r.lastCycle) * 100 / float64(emu.ClockHz())
+	}
+	r.lastCycle = cycle
+
+	pix := r.screenPix(emu.Framebuffer(), emu.Theme().Background)
+	switch {
+	case r.pending == nil:
+		r.pending, r.pendingStart = pix, r.time
+	case r.elideDups && bytes.Equal(pix, r.pending):
+	case r.time-r.pendingStart < gifMinDelay:
+		// too soon to show another frame, so this one replaces it
+		r.pending = pix
+	default:
+		r.writeFrame(r.pending, int(r.time+0.5)-int(r.pendingStart+0.5))
+		r.pending, r.pendingStart = pix, r.time
+	}
+	return r.err
+}
+
+// Close writes out the last frame and ends the GIF. It doesn't close
+// the writer the recorder was made with.
+func (r *GIFRecorder) Close() error {
+	if r.pending != nil {
+		r.writeFrame(r.pending, int(r.time+0.5)-int(r.pendingStart+0.5))
+		r.pending = nil
+	}
+	r.write([]byte{0x3b})
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+// Frames is how many frames have been written so far
+func (r *GIFRecorder) Frames() int {
+	return r.frameCount
+}
+
+// screenPix turns the framebuffer into color indexes, scaled up,
+// with anything but the background lit
+func (r *GIFRecorder) screenPix(fb []byte, bg color.RGBA) []byte {
+	w, h, scale := int(r.w16)/r.scale, int(r.h16)/r.scale, r.scale
+	pix := make([]byte, int(r.w16)*int(r.h16))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := (y*w + x) * 4
+			if fb[i] == bg.R && fb[i+1] == bg.G && fb[i+2] == bg.B {
+				continue
+			}
+			for sy := 0; sy < scale; sy++ {
+				row := pix[((y*scale+sy)*w+x)*scale:]
+				for sx := 0; sx < scale; sx++ {
+					row[sx] = 1
+				}
+			}
+		}
+	}
+	return pix
+}
+
+func (r *GIFRecorder) writeFrame(pix []byte, delay int) {
+	if delay < gifMinDelay {
+		delay = gifMinDelay
+	}
+	if delay > 0xffff {
+		delay = 0xffff
+	}
+	// graphic control extension, for the delay
+	r.write([]byte{0x21, 0xf9, 4, 0x04})
+	r.write16(uint16(delay))
+	r.write([]byte{0, 0})
+	// image descriptor, the whole screen with no local color table
+	r.write([]byte{0x2c, 0, 0, 0, 0})
+	r.write16(r.w16)
+	r.write16(r.h16)
+	r.write([]byte{0})
+
+	// 2 is the smallest code size GIF allows
+	const litWidth = 2
+	compressed := bytes.Buffer{}
+	lzwWriter := lzw.NewWriter(&compressed, lzw.LSB, litWidth)
+	lzwWriter.Write(pix)
+	lzwWriter.Close()
+	r.write([]byte{litWidth})
+	data := compressed.Bytes()
+	for len(data) > 0 {
+		n := len(data)
+		if n > 255 {
+			n = 255
+		}
+		r.write([]byte{byte(n)})
+		r.write(data[:n])
+		data = data[n:]
+	}
+	r.write([]byte{0})
+	r.frameCount++
+}
@@ -3,19 +3,19 @@ module github.com/theinternetftw/a1go
 go 1.18
 
 require (
+	github.com/hajimehoshi/ebiten/v2 v2.6.3
 	github.com/pkg/profile v1.2.1
 	github.com/theinternetftw/cpugo/virt6502 v0.0.1
 	github.com/theinternetftw/glimmer v0.1.2
+	golang.org/x/sys v0.12.0
 )
 
 require (
 	github.com/ebitengine/oto/v3 v3.1.0 // indirect
 	github.com/ebitengine/purego v0.5.0 // indirect
-	github.com/hajimehoshi/ebiten/v2 v2.6.3 // indirect
 	github.com/jezek/xgb v1.1.0 // indirect
 	golang.org/x/exp/shiny v0.0.0-20230817173708-d852ddb80c63 // indirect
 	golang.org/x/image v0.12.0 // indirect
 	golang.org/x/mobile v0.0.0-20230922142353-e2f452493d57 // indirect
 	golang.org/x/sync v0.3.0 // indirect
-	golang.org/x/sys v0.12.0 // indirect
 )
@@ -0,0 +1,224 @@
+package a1go
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// hostLink is a byte pipe to the outside world: a TCP listener that
+// takes one client at a time, or a pseudo-terminal. Bytes are queued
+// both ways, so the emulator never blocks on the host.
+type hostLink struct {
+	spec string
+	// Desc says where to connect, e.g. "tcp 127.0.0.1:6502" or "/dev/pts/3"
+	Desc string
+	// telnet links talk clients into character-at-a-time mode with no
+	// local echo, and strip telnet commands from what they send
+	telnet bool
+
+	in  chan byte
+	out chan byte
+
+	// dropped counts the bytes send has dropped since the host last
+	// took one, with the queue full
+	dropped int
+}
+
+const hostLinkQueueLen = 64 * 1024
+
+// hostLinks are kept open by spec, so a snapshot load can pick up the
+// same listener or pty instead of fighting its predecessor for it.
+var hostLinks = struct {
+	sync.Mutex
+	bySpec map[string]*hostLink
+}{bySpec: map[string]*hostLink{}}
+
+// HostLinkSpecHelp describes the link specs openHostLink understands
+const HostLinkSpecHelp = `"tcp:ADDR" (e.g. tcp::6502 or tcp:127.0.0.1:6502), "telnet:ADDR" (tcp, but set up for telnet clients) or "pty"`
+
+// openHostLink opens, or finds already open, a link from a spec: see
+// HostLinkSpecHelp.
+func openHostLink(spec string) (*hostLink, error) {
+	hostLinks.Lock()
+	defer hostLinks.Unlock()
+	if link, ok := hostLinks.bySpec[spec]; ok {
+		return link, nil
+	}
+	link := &hostLink{
+		spec: spec,
+		in:   make(chan byte, hostLinkQueueLen),
+		out:  make(chan byte, hostLinkQueueLen),
+	}
+	switch {
+	case strings.HasPrefix(spec, "tcp:") || strings.HasPrefix(spec, "telnet:"):
+		scheme, addr, _ := strings.Cut(spec, ":")
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		link.telnet = scheme == "telnet"
+		link.Desc = scheme + " " + listener.Addr().String()
+		go link.serveTCP(listener)
+	case spec == "pty":
+		master, slaveName, err := openPTY()
+		if err != nil {
+			return nil, err
+		}
+		link.Desc = slaveName
+		go link.pump(master)
+	default:
+		return nil, fmt.Errorf("bad host link %q, want %v", spec, HostLinkSpecHelp)
+	}
+	hostLinks.bySpec[spec] = link
+	return link, nil
+}
+
+func (l *hostLink) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		if l.telnet {
+			conn.Write(telnetGreeting)
+		}
+		l.pump(conn)
+		conn.Close()
+	}
+}
+
+// pump moves bytes until the other end goes away
+func (l *hostLink) pump(rw io.ReadWriter) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case b := <-l.out:
+				if _, err := rw.Write([]byte{b}); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	buf := make([]byte, 4096)
+	var filter telnetFilter
+	for {
+		n, err := rw.Read(buf)
+		for _, b := range buf[:n] {
+			if l.telnet && filter.skip(b) {
+				continue
+			}
+			l.in <- b
+		}
+		if err != nil {
+			close(done)
+			return
+		}
+	}
+}
+
+// recv returns the next byte from the host, if there is one
+func (l *hostLink) recv() (byte, bool) {
+	select {
+	case b := <-l.in:
+		return b, true
+	default:
+		return 0, false
+	}
+}
+
+// pending reports if there are bytes from the host waiting
+func (l *hostLink) pending() bool {
+	return len(l.in) > 0
+}
+
+// send queues a byte for the host, dropping it if the queue is full,
+// e.g. with nobody connected. Drops are reported when they start and
+// when they end.
+func (l *hostLink) send(b byte) {
+	select {
+	case l.out <- b:
+		if l.dropped > 0 {
+			fmt.Printf("%v: host caught up, %v bytes were dropped\n", l.Desc, l.dropped)
+			l.dropped = 0
+		}
+	default:
+		if l.dropped == 0 {
+			fmt.Printf("%v: host isn't taking bytes, dropping them\n", l.Desc)
+		}
+		l.dropped++
+	}
+}
+
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptEcho = 1
+	telnetOptSGA  = 3
+)
+
+// telnetGreeting says we echo and don't need go-aheads, which puts
+// most clients into character mode with local echo off
+var telnetGreeting = []byte{
+	telnetIAC, telnetWILL, telnetOptEcho,
+	telnetIAC, telnetWILL, telnetOptSGA,
+}
+
+// telnetFilter drops telnet commands and option negotiation from a
+// client's byte stream
+type telnetFilter struct {
+	state int
+}
+
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOpt
+	telnetStateSub
+	telnetStateSubIAC
+)
+
+// skip reports if b is part of a telnet command
+func (f *telnetFilter) skip(b byte) bool {
+	switch f.state {
+	case telnetStateIAC:
+		switch {
+		case b == telnetIAC:
+			f.state = telnetStateData
+			return false // escaped 0xff
+		case b == telnetSB:
+			f.state = telnetStateSub
+		case b >= telnetWILL && b <= telnetDONT:
+			f.state = telnetStateOpt
+		default:
+			f.state = telnetStateData
+		}
+	case telnetStateOpt:
+		f.state = telnetStateData
+	case telnetStateSub:
+		if b == telnetIAC {
+			f.state = telnetStateSubIAC
+		}
+	case telnetStateSubIAC:
+		if b == telnetSE {
+			f.state = telnetStateData
+		} else {
+			f.state = telnetStateSub
+		}
+	default:
+		if b != telnetIAC {
+			return false
+		}
+		f.state = telnetStateIAC
+	}
+	return true
+}
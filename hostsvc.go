@@ -0,0 +1,291 @@
+package a1go
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The host services card is a paravirtual device, not a real one: a
+// page of registers that guest code fills in before writing a command
+// to hostSvcCmd. Commands run at once, and leave hostSvcStatus zero on
+// success. Buffers are read and written in guest memory directly, as
+// if by DMA.
+const (
+	hostSvcCmd     = 0x00 // write a command to run it
+	hostSvcStatus  = 0x01 // zero if the last command worked
+	hostSvcAddrLo  = 0x02 // guest buffer
+	hostSvcAddrHi  = 0x03
+	hostSvcLenLo   = 0x04 // guest buffer length
+	hostSvcLenHi   = 0x05
+	hostSvcHandle  = 0x06 // file handle, set by open
+	hostSvcArg     = 0x07 // open mode, or exit status
+	hostSvcCountLo = 0x08 // bytes read or written
+	hostSvcCountHi = 0x09
+	hostSvcPutc    = 0x0a // write a byte here to print it on the host
+	hostSvcTime    = 0x10 // time cmd fills in year lo/hi, month, day, hour, minute, second
+	hostSvcUnix    = 0x18 // and unix time, 4 bytes, little-endian
+
+	hostSvcRegCount = 0x20
+
+	hostSvcDefaultAddr = 0xc300
+	hostSvcMaxFiles    = 8
+)
+
+// host services commands
+const (
+	hostSvcCmdPrint = 1 // print the buffer on the host
+	hostSvcCmdOpen  = 2 // open the file named in the buffer, mode in arg, sets handle
+	hostSvcCmdClose = 3 // close handle
+	hostSvcCmdRead  = 4 // read from handle into the buffer, sets count (0 at EOF)
+	hostSvcCmdWrite = 5 // write the buffer to handle, sets count
+	hostSvcCmdTime  = 6 // fill in the time registers
+	hostSvcCmdExit  = 7 // ask the host to exit, with arg as the status
+)
+
+// open modes, for hostSvcArg
+const (
+	hostSvcModeRead   = 0
+	hostSvcModeWrite  = 1 // created or truncated
+	hostSvcModeAppend = 2
+)
+
+// status codes
+const (
+	hostSvcOK         = 0
+	hostSvcErrCmd     = 1 // unknown command
+	hostSvcErrHandle  = 2 // bad or closed handle
+	hostSvcErrName    = 3 // bad filename, e.g. outside the host dir
+	hostSvcErrIO      = 4 // the host couldn't do it, e.g. no such file
+	hostSvcErrTooMany = 5 // too many open files
+	hostSvcErrNoFiles = 6 // files weren't turned on
+)
+
+// hostSvcCard gives guest code a channel to the host, for tests and
+// dev tools: host files, printing, the time, and an exit status. Open
+// files aren't in snapshots, so their handles don't survive a load:
+// they're closed when the emulator is replaced.
+// Printing goes to Options.HostOutput.
+type hostSvcCard struct {
+	Addr uint16
+	// Dir is the host dir files are opened in. Empty means no files.
+	Dir string
+
+	Regs [hostSvcRegCount]byte
+
+	// Exited is set by the exit command, with the status in ExitCode
+	Exited   bool
+	ExitCode int
+
+	files [hostSvcMaxFiles]*os.File
+}
+
+func installHostSvc(emu *emuState, args map[string]string) error {
+	h := &hostSvcCard{Addr: hostSvcDefaultAddr, Dir: args["dir"]}
+	if addrStr, ok := args["addr"]; ok {
+		addr, err := strconv.ParseUint(strings.TrimPrefix(addrStr, "$"), 16, 16)
+		if err != nil {
+			return fmt.Errorf("bad addr %q: %v", addrStr, err)
+		}
+		h.Addr = uint16(addr)
+	}
+	if h.Addr >= 0xd000 && h.Addr < 0xe000 {
+		return fmt.Errorf("addr $%04X is in the PIA's I/O space", h.Addr)
+	}
+	emu.HostSvc = h
+	return nil
+}
+
+func (h *hostSvcCard) addrRange() AddrRange {
+	page := h.Addr &^ 0xff
+	return AddrRange{page, page | 0xff}
+}
+
+// hostOutput is where the host services card prints
+func (emu *emuState) hostOutput() io.Writer {
+	if emu.hostOut == nil {
+		return os.Stdout
+	}
+	return emu.hostOut
+}
+
+// handOverHost closes the open files, as handles don't survive a load
+func (h *hostSvcCard) handOverHost(next []card) {
+	for i, f := range h.files {
+		if f != nil {
+			f.Close()
+			h.files[i] = nil
+		}
+	}
+}
+
+func (h *hostSvcCard) read(emu *emuState, addr uint16) byte {
+	return h.Regs[addr&(hostSvcRegCount-1)]
+}
+
+func (h *hostSvcCard) write(emu *emuState, addr uint16, val byte) {
+	reg := addr & (hostSvcRegCount - 1)
+	switch reg {
+	case hostSvcCmd:
+		h.Regs[hostSvcStatus] = h.run(emu, val)
+	case hostSvcPutc:
+		emu.hostOutput().Write([]byte{hostChar(val)})
+	default:
+		h.Regs[reg] = val
+	}
+}
+
+// hostChar turns an Apple-1 char into the host's: high bit off, and
+// CR as the host's newline
+func hostChar(c byte) byte {
+	c &= 0x7f
+	if c == '\r' {
+		c = '\n'
+	}
+	return c
+}
+
+func (h *hostSvcCard) reg16(lo int) uint16 {
+	return uint16(h.Regs[lo]) | uint16(h.Regs[lo+1])<<8
+}
+
+func (h *hostSvcCard) setReg16(lo int, val uint16) {
+	h.Regs[lo], h.Regs[lo+1] = byte(val), byte(val>>8)
+}
+
+// buffer copies the guest buffer out of memory
+func (h *hostSvcCard) buffer(emu *emuState) []byte {
+	addr, n := h.reg16(hostSvcAddrLo), int(h.reg16(hostSvcLenLo))
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = emu.peek(addr + uint16(i))
+	}
+	return buf
+}
+
+func (h *hostSvcCard) run(emu *emuState, cmd byte) byte {
+	switch cmd {
+	case hostSvcCmdPrint:
+		buf := h.buffer(emu)
+		for i := range buf {
+			buf[i] = hostChar(buf[i])
+		}
+		emu.hostOutput().Write(buf)
+	case hostSvcCmdOpen:
+		return h.open(string(stripHighBits(h.buffer(emu))), h.Regs[hostSvcArg])
+	case hostSvcCmdClose:
+		f, status := h.file()
+		if status != hostSvcOK {
+			return status
+		}
+		h.files[h.Regs[hostSvcHandle]] = nil
+		if err := f.Close(); err != nil {
+			return hostSvcErrIO
+		}
+	case hostSvcCmdRead:
+		f, status := h.file()
+		if status != hostSvcOK {
+			return status
+		}
+		buf := make([]byte, h.reg16(hostSvcLenLo))
+		n, err := io.ReadFull(f, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return hostSvcErrIO
+		}
+		addr := h.reg16(hostSvcAddrLo)
+		for i := 0; i < n; i++ {
+			emu.poke(addr+uint16(i), buf[i])
+		}
+		h.setReg16(hostSvcCountLo, uint16(n))
+	case hostSvcCmdWrite:
+		f, status := h.file()
+		if status != hostSvcOK {
+			return status
+		}
+		n, err := f.Write(h.buffer(emu))
+		h.setReg16(hostSvcCountLo, uint16(n))
+		if err != nil {
+			return hostSvcErrIO
+		}
+	case hostSvcCmdTime:
+		now := time.Now()
+		h.setReg16(hostSvcTime, uint16(now.Year()))
+		h.Regs[hostSvcTime+2] = byte(now.Month())
+		h.Regs[hostSvcTime+3] = byte(now.Day())
+		h.Regs[hostSvcTime+4] = byte(now.Hour())
+		h.Regs[hostSvcTime+5] = byte(now.Minute())
+		h.Regs[hostSvcTime+6] = byte(now.Second())
+		unix := uint32(now.Unix())
+		for i := 0; i < 4; i++ {
+			h.Regs[hostSvcUnix+i] = byte(unix >> (8 * uint(i)))
+		}
+	case hostSvcCmdExit:
+		h.Exited = true
+		h.ExitCode = int(h.Regs[hostSvcArg])
+	default:
+		return hostSvcErrCmd
+	}
+	return hostSvcOK
+}
+
+func stripHighBits(b []byte) []byte {
+	for i := range b {
+		b[i] &= 0x7f
+	}
+	return b
+}
+
+// file returns the open file for the handle register
+func (h *hostSvcCard) file() (*os.File, byte) {
+	handle := int(h.Regs[hostSvcHandle])
+	if handle >= len(h.files) || h.files[handle] == nil {
+		return nil, hostSvcErrHandle
+	}
+	return h.files[handle], hostSvcOK
+}
+
+// isPlainFileName says if a name from guest code is just a file name,
+// with no path in it, so guest code can't use it to wander the host
+func isPlainFileName(name string) bool {
+	return name != "" && name == filepath.Base(name) && name != "." && name != ".." && !strings.ContainsAny(name, `/\:`)
+}
+
+func (h *hostSvcCard) open(name string, mode byte) byte {
+	if h.Dir == "" {
+		return hostSvcErrNoFiles
+	}
+	if !isPlainFileName(name) {
+		return hostSvcErrName
+	}
+	handle := -1
+	for i, f := range h.files {
+		if f == nil {
+			handle = i
+			break
+		}
+	}
+	if handle < 0 {
+		return hostSvcErrTooMany
+	}
+	flags := os.O_RDONLY
+	switch mode {
+	case hostSvcModeRead:
+	case hostSvcModeWrite:
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case hostSvcModeAppend:
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	default:
+		return hostSvcErrCmd
+	}
+	f, err := os.OpenFile(filepath.Join(h.Dir, name), flags, 0644)
+	if err != nil {
+		return hostSvcErrIO
+	}
+	h.files[handle] = f
+	h.Regs[hostSvcHandle] = byte(handle)
+	return hostSvcOK
+}
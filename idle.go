@@ -0,0 +1,77 @@
+package a1go
+
+// idlePollThreshold is how many identical, write-free polls of the
+// keyboard status we need to see before calling the cpu idle.
+// Identical means from the same PC with the same registers, so a loop
+// that counts down in a register between polls, like a keyboard
+// timeout, isn't mistaken for idle and doesn't have its time skipped.
+const idlePollThreshold = 3
+
+// idleDetector notices when the cpu is spinning in the classic
+//
+//	LDA $D011
+//	BPL *-3
+//
+// keyboard wait loop (or anything else that keeps polling $D011 from
+// the same spot without touching memory or registers), so we can skip
+// ahead to the end of the frame. Keys from the frontend only come in
+// between frames, so skipping is safe as long as nothing else is due
+// this frame: see workPending.
+type idleDetector struct {
+	lastPollPC   uint16
+	lastPollRegs [4]byte
+	polls        int
+	sawWrite     bool
+
+	spinning     bool
+	frameWasIdle bool
+}
+
+func (d *idleDetector) sawKeyPoll(emu *emuState) {
+	if emu.NewKeyWasPressed || len(emu.autokeyInput) > 0 {
+		d.polls = 0
+		return
+	}
+	regs := [4]byte{emu.CPU.A, emu.CPU.X, emu.CPU.Y, emu.CPU.S}
+	if emu.CPU.PC == d.lastPollPC && regs == d.lastPollRegs && !d.sawWrite {
+		d.polls++
+	} else {
+		d.polls = 0
+	}
+	d.lastPollPC, d.lastPollRegs = emu.CPU.PC, regs
+	d.sawWrite = false
+	if d.polls >= idlePollThreshold {
+		d.spinning = true
+	}
+}
+
+// workPending says if anything the cpu could see is still to come:
+// input waiting on the console link, a char on its way to the display,
+// or a card that's busy. Until it's done, the cpu can't be called idle.
+func (emu *emuState) workPending() bool {
+	if emu.console != nil && emu.console.link.pending() {
+		return true
+	}
+	if emu.KeyDisplayRequested {
+		return true
+	}
+	for _, w := range emu.cycleWatchers {
+		if w.busy() {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *idleDetector) reset() {
+	d.spinning = false
+	d.polls = 0
+}
+
+func (emu *emuState) skipToFrameEnd() {
+	emu.idle.reset()
+	emu.idle.frameWasIdle = true
+	if frameLen := emu.clocksPerFrame(); emu.FrameCounter < frameLen {
+		emu.tick(frameLen - emu.FrameCounter)
+	}
+}
@@ -0,0 +1,36 @@
+package a1go
+
+import "testing"
+
+func TestIdleDetector(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     []byte
+		wantIdle bool
+	}{
+		// LDA $D011, BPL *-3
+		{"key wait", []byte{0xad, 0x11, 0xd0, 0x10, 0xfb}, true},
+		// LDX #0, LDA $D011, BMI key, DEX, BNE *-6, JMP $0300, key: JMP key
+		{"key wait with a timeout", []byte{0xa2, 0x00, 0xad, 0x11, 0xd0, 0x30, 0x06, 0xca, 0xd0, 0xf8, 0x4c, 0x00, 0x03, 0x4c, 0x0d, 0x03}, false},
+		// LDA $D011, STA $10, BPL *-5
+		{"key wait that writes", []byte{0xad, 0x11, 0xd0, 0x85, 0x10, 0x10, 0xf9}, false},
+	}
+	for _, test := range tests {
+		emu := newState()
+		emu.runFrame(Input{})
+		emu.loadBinaryToMem(0x0300, test.code)
+		regs := emu.Registers()
+		regs.PC = 0x0300
+		emu.SetRegisters(regs)
+		emu.runFrame(Input{})
+		start := emu.Cycles
+		emu.runFrame(Input{})
+		if emu.IsIdle() != test.wantIdle {
+			t.Errorf("%v: idle is %v, want %v", test.name, emu.IsIdle(), test.wantIdle)
+		}
+		// skipping ahead still takes a frame's worth of cycles
+		if ran := emu.Cycles - start; ran < emu.clocksPerFrame()-10 {
+			t.Errorf("%v: frame ran %v cycles, want %v", test.name, ran, emu.clocksPerFrame())
+		}
+	}
+}
@@ -8,6 +8,16 @@ const ramBank2Size = 0x1000
 type mem struct {
 	RAMBank1 [ramBank1Size]byte
 	RAMBank2 [ramBank2Size]byte
+
+	// Unpopulated marks RAM pages with no chips in them, see ram.go.
+	// The zero value is a fully populated board.
+	Unpopulated [256]bool
+
+	// ROMs are images mapped in outside of RAM, see rom.go
+	ROMs []romImage
+	// Monitor is the user's replacement for the Woz Monitor, if any.
+	// It wins over ROMs, so loading a rom set doesn't undo it.
+	Monitor romImage
 }
 
 var monitorROM = [256]byte{
@@ -48,26 +58,48 @@ var monitorROM = [256]byte{
 func (emu *emuState) read(addr uint16) byte {
 	var val byte
 	switch {
+	case emu.cardPages[addr>>8] != nil:
+		val = emu.cardPages[addr>>8].read(emu, addr)
+
 	case addr < ramBank1Size:
-		val = emu.Mem.RAMBank1[addr]
+		if emu.Mem.Unpopulated[addr>>8] {
+			val = emu.Mem.unpopulatedRead(addr)
+		} else {
+			val = emu.Mem.RAMBank1[addr]
+		}
 
 	case addr == 0xd010:
 		val = 0x80 | emu.NewKeyInput
 		emu.NewKeyWasPressed = false
 	case addr == 0xd011:
 		val = boolBit(emu.NewKeyWasPressed, 7)
+		emu.idle.sawKeyPoll(emu)
 	case addr == 0xd012:
 		val = boolBit(!emu.ReadyToDisplay, 7) | emu.NextKeyToDisplay
 
 	case addr >= 0xe000 && addr < 0xf000:
-		val = emu.Mem.RAMBank2[addr-0xe000]
+		if emu.Mem.Unpopulated[addr>>8] {
+			val = emu.Mem.unpopulatedRead(addr)
+		} else {
+			val = emu.Mem.RAMBank2[addr-0xe000]
+		}
 
 	case addr >= 0xf000 && addr < 0xff00:
 		val = 0xff // unused ROM
+		if romVal, ok := emu.Mem.romByte(addr); ok {
+			val = romVal
+		}
 	case addr >= 0xff00:
 		val = monitorROM[addr-0xff00]
+		if romVal, ok := emu.Mem.romByte(addr); ok {
+			val = romVal
+		}
 	default:
-		emuErr(fmt.Sprintf("unimplemented read: 0x%04x", addr))
+		if romVal, ok := emu.Mem.romByte(addr); ok {
+			val = romVal
+		} else {
+			emuErr(fmt.Sprintf("unimplemented read: 0x%04x", addr))
+		}
 	}
 	if showMemReads {
 		fmt.Printf("read(0x%04x) = 0x%02x\n", addr, val)
@@ -76,9 +108,15 @@ func (emu *emuState) read(addr uint16) byte {
 }
 
 func (emu *emuState) write(addr uint16, val byte) {
+	emu.idle.sawWrite = true
 	switch {
+	case emu.cardPages[addr>>8] != nil:
+		emu.cardPages[addr>>8].write(emu, addr, val)
+
 	case addr < ramBank1Size:
-		emu.Mem.RAMBank1[addr] = val
+		if !emu.Mem.Unpopulated[addr>>8] {
+			emu.Mem.RAMBank1[addr] = val
+		}
 
 	case addr == 0xd011:
 		// ctrl for PIA setup after RESET, ignored here
@@ -87,6 +125,7 @@ func (emu *emuState) write(addr uint16, val byte) {
 			emu.NextKeyToDisplay = val & 0x7f
 			emu.KeyDisplayRequested = true
 			emu.ReadyToDisplay = false
+			emu.DisplayCountdown = emu.clocksPerFrame()
 		} else {
 			if val == 0x7f {
 				emu.DisplayBeenInitted = true
@@ -97,15 +136,58 @@ func (emu *emuState) write(addr uint16, val byte) {
 		// ctrl for PIA setup after RESET, ignored here
 
 	case addr >= 0xe000 && addr < 0xf000:
-		emu.Mem.RAMBank2[addr-0xe000] = val
+		if !emu.Mem.Unpopulated[addr>>8] {
+			emu.Mem.RAMBank2[addr-0xe000] = val
+		}
 
 	case addr >= 0xf000:
 		// nop, this is ROM
 
 	default:
-		emuErr(fmt.Sprintf("unimplemented: write(0x%04x, 0x%02x)", addr, val))
+		if _, ok := emu.Mem.romByte(addr); !ok {
+			emuErr(fmt.Sprintf("unimplemented: write(0x%04x, 0x%02x)", addr, val))
+		}
 	}
 	if showMemWrites {
 		fmt.Printf("write(0x%04x, 0x%02x)\n", addr, val)
 	}
 }
+
+// peek reads memory the way a debugger would: without acking keypresses
+// or poking cards (only their ROM shows), and with holes reading as 0xff
+// instead of stopping the emulator.
+func (emu *emuState) peek(addr uint16) byte {
+	switch {
+	case emu.cardPages[addr>>8] != nil:
+	case addr == 0xd010:
+		return 0x80 | emu.NewKeyInput
+	case addr == 0xd011:
+		return boolBit(emu.NewKeyWasPressed, 7)
+	case addr == 0xd012:
+		return boolBit(!emu.ReadyToDisplay, 7) | emu.NextKeyToDisplay
+	case addr < ramBank1Size || addr >= 0xe000:
+		return emu.read(addr)
+	}
+	if romVal, ok := emu.Mem.romByte(addr); ok {
+		return romVal
+	}
+	return 0xff
+}
+
+// poke writes memory the way a debugger would, dropping writes to
+// holes instead of stopping the emulator
+func (emu *emuState) poke(addr uint16, val byte) {
+	mapped := emu.cardPages[addr>>8] != nil || addr < ramBank1Size || addr >= 0xe000 || (addr >= 0xd011 && addr <= 0xd013)
+	if _, ok := emu.Mem.romByte(addr); ok || mapped {
+		emu.write(addr, val)
+	}
+}
+
+// unpopulatedRead is a read from a RAM page with no chips in it. That's
+// either a ROM mapped in its place or just a floating bus.
+func (m *mem) unpopulatedRead(addr uint16) byte {
+	if romVal, ok := m.romByte(addr); ok {
+		return romVal
+	}
+	return 0xff
+}
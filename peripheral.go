@@ -0,0 +1,230 @@
+package a1go
+
+import (
+	"fmt"
+	"sort"
+)
+
+// card is an expansion card on the bus. Each card's state is its own
+// emuState field, so it's saved in snapshots, and attachCards maps
+// the installed ones into the address space.
+type card interface {
+	// addrRange is the pages the card answers for. Cards win
+	// over anything else mapped there, RAM included.
+	addrRange() AddrRange
+	read(emu *emuState, addr uint16) byte
+	write(emu *emuState, addr uint16, val byte)
+}
+
+// PeripheralConfig installs an expansion card, see PeripheralTypes
+type PeripheralConfig struct {
+	Type string
+	// Args are card specific settings, e.g. a disk image path
+	Args map[string]string `json:",omitempty"`
+}
+
+// PeripheralType describes a card a1go can emulate
+type PeripheralType struct {
+	Name        string
+	Description string
+	// Args lists the settings the card takes, and what they mean
+	Args map[string]string
+	// ROM names the card's firmware for a ROMManager, if it has any
+	ROM string
+}
+
+type peripheralInstaller func(emu *emuState, args map[string]string) error
+
+var peripheralTypes = map[string]struct {
+	info    PeripheralType
+	install peripheralInstaller
+}{
+	"aci": {
+		PeripheralType{
+			Name:        "aci",
+			Description: "Apple Cassette Interface I/O at $C000-$C0FF, for the ACI rom at $C100 (no tape is attached yet)",
+			ROM:         "ACI",
+		},
+		installACI,
+	},
+	"cffa1": {
+		PeripheralType{
+			Name:        "cffa1",
+			Description: "CFFA1 CompactFlash card: firmware at $9000-$AFDF, ATA registers at $AFE0-$AFFF",
+			Args: map[string]string{
+				"image": "ProDOS-order disk image (.po, .hdv or .2mg) to use as the CF card",
+				"dir":   "host dir to use as the CF card, as a ProDOS volume",
+			},
+			ROM: "CFFA1",
+		},
+		installCFFA1,
+	},
+	"acia": {
+		PeripheralType{
+			Name:        "acia",
+			Description: "6850 ACIA serial card, bridged to a host tcp port or pty",
+			Args: map[string]string{
+				"link": "host end of the serial line: " + HostLinkSpecHelp,
+				"addr": "hex address of the card's page (default C200)",
+				"baud": "baud rate at the divide-by-16 setting (default 9600)",
+				"irq":  "true to wire the card to the cpu's IRQ line",
+			},
+		},
+		installACIA,
+	},
+	"hostsvc": {
+		PeripheralType{
+			Name:        "hostsvc",
+			Description: "host services trap device (not a real card): guest code can print on the host, use host files, get the time, and exit",
+			Args: map[string]string{
+				"addr": "hex address of the device's page (default C300)",
+				"dir":  "host dir guest code can open files in (default none)",
+			},
+		},
+		installHostSvc,
+	},
+}
+
+// hostBacked cards keep their data in host files, which aren't in
+// snapshots, so they're opened whenever cards are attached.
+type hostBacked interface {
+	openHost() error
+}
+
+// hostHandover cards have host state that a snapshot load would
+// otherwise leak or lose. handOverHost gives it to the same kind of
+// card among next, the new emulator's, or lets it go if none can take
+// it. It's called before next's cards are attached.
+type hostHandover interface {
+	handOverHost(next []card)
+}
+
+// frameWatcher cards are told when each frame ends
+type frameWatcher interface {
+	endFrame()
+}
+
+// cycleWatcher cards are told as each cycle goes by. They're busy
+// while they have work under way that the cpu would see the end of,
+// so the cpu mustn't be skipped ahead then, see idle.go.
+type cycleWatcher interface {
+	tick(emu *emuState, cycles uint64)
+	busy() bool
+}
+
+// irqSource cards can pull the cpu's IRQ line
+type irqSource interface {
+	irq() bool
+}
+
+// PeripheralTypes lists the cards a1go can emulate, sorted by name
+func PeripheralTypes() []PeripheralType {
+	types := []PeripheralType{}
+	for _, t := range peripheralTypes {
+		types = append(types, t.info)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+func (emu *emuState) installPeripheral(cfg PeripheralConfig) error {
+	t, ok := peripheralTypes[cfg.Type]
+	if !ok {
+		names := []string{}
+		for _, known := range PeripheralTypes() {
+			names = append(names, known.Name)
+		}
+		return fmt.Errorf("unknown peripheral %q (known peripherals: %v)", cfg.Type, names)
+	}
+	for arg := range cfg.Args {
+		if _, ok := t.info.Args[arg]; !ok {
+			return fmt.Errorf("peripheral %v has no %q setting", cfg.Type, arg)
+		}
+	}
+	if err := t.install(emu, cfg.Args); err != nil {
+		return fmt.Errorf("could not install %v: %v", cfg.Type, err)
+	}
+	return emu.attachCards()
+}
+
+// installedCards lists the installed cards
+func (emu *emuState) installedCards() []card {
+	cards := []card{}
+	if emu.ACI != nil {
+		cards = append(cards, emu.ACI)
+	}
+	if emu.CFFA1 != nil {
+		cards = append(cards, emu.CFFA1)
+	}
+	if emu.ACIA != nil {
+		cards = append(cards, emu.ACIA)
+	}
+	if emu.HostSvc != nil {
+		cards = append(cards, emu.HostSvc)
+	}
+	return cards
+}
+
+// attachCards maps the installed cards in, after installing
+// a card or loading a snapshot
+func (emu *emuState) attachCards() error {
+	emu.cardPages = [256]card{}
+	emu.cards = emu.installedCards()
+	emu.cycleWatchers, emu.irqSources = nil, nil
+	for _, c := range emu.cards {
+		if w, ok := c.(cycleWatcher); ok {
+			emu.cycleWatchers = append(emu.cycleWatchers, w)
+		}
+		if s, ok := c.(irqSource); ok {
+			emu.irqSources = append(emu.irqSources, s)
+		}
+		if h, ok := c.(hostBacked); ok {
+			if err := h.openHost(); err != nil {
+				return err
+			}
+		}
+		r := c.addrRange()
+		for page := int(r.Start >> 8); page <= int(r.End>>8); page++ {
+			if emu.cardPages[page] != nil {
+				return fmt.Errorf("two cards both want page $%02X00", page)
+			}
+			emu.cardPages[page] = c
+		}
+	}
+	return nil
+}
+
+// handOverHost passes the host side of emu's cards on to next's,
+// see hostHandover
+func (emu *emuState) handOverHost(next *emuState) {
+	nextCards := next.installedCards()
+	for _, c := range emu.installedCards() {
+		if h, ok := c.(hostHandover); ok {
+			h.handOverHost(nextCards)
+		}
+	}
+}
+
+func (emu *emuState) endFrameCards() {
+	for _, c := range emu.cards {
+		if f, ok := c.(frameWatcher); ok {
+			f.endFrame()
+		}
+	}
+}
+
+func (emu *emuState) tickCards(cycles uint64) {
+	for _, w := range emu.cycleWatchers {
+		w.tick(emu, cycles)
+	}
+}
+
+// irqAsserted reports if any card is pulling the IRQ line
+func (emu *emuState) irqAsserted() bool {
+	for _, s := range emu.irqSources {
+		if s.irq() {
+			return true
+		}
+	}
+	return false
+}
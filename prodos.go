@@ -0,0 +1,307 @@
+package a1go
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Just enough ProDOS to turn a host dir into a volume and back again.
+// Only the volume directory is used: subdirectories aren't imported,
+// and files in subdirectories made on the Apple-1 side aren't exported.
+
+const (
+	prodosBlockSize       = 512
+	prodosVolDirKeyBlock  = 2
+	prodosVolDirBlocks    = 4
+	prodosBitmapBlock     = prodosVolDirKeyBlock + prodosVolDirBlocks
+	prodosEntryLen        = 0x27
+	prodosEntriesPerBlock = 0x0d
+	prodosMaxNameLen      = 15
+
+	prodosSeedling = 1
+	prodosSapling  = 2
+	prodosTree     = 3
+	prodosVolHdr   = 0xf
+
+	prodosTypeBIN = 0x06
+)
+
+// prodosFile is a file in the volume directory
+type prodosFile struct {
+	name    string
+	fileTyp byte
+	auxType uint16
+	modTime time.Time
+	data    []byte
+}
+
+// hostName is the CiderPress-style host filename that keeps the
+// ProDOS file type and aux type, e.g. HELLO#F80800
+func (f *prodosFile) hostName() string {
+	return fmt.Sprintf("%v#%02X%04X", f.name, f.fileTyp, f.auxType)
+}
+
+// parseHostName splits a host filename into a ProDOS name, type and aux
+// type. Names without a #TTAAAA suffix become plain binary files.
+func parseHostName(hostName string) (string, byte, uint16) {
+	name, fileTyp, auxType := hostName, byte(prodosTypeBIN), uint16(0)
+	if hash := strings.LastIndex(hostName, "#"); hash >= 0 && len(hostName)-hash == 7 {
+		if typeAux, err := strconv.ParseUint(hostName[hash+1:], 16, 32); err == nil {
+			name = hostName[:hash]
+			fileTyp, auxType = byte(typeAux>>16), uint16(typeAux)
+		}
+	}
+	return prodosName(name), fileTyp, auxType
+}
+
+// prodosName makes a legal ProDOS name: up to 15 uppercase letters,
+// digits and periods, starting with a letter.
+func prodosName(name string) string {
+	out := []byte{}
+	for _, c := range []byte(strings.ToUpper(name)) {
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '.' {
+			out = append(out, c)
+		} else {
+			out = append(out, '.')
+		}
+	}
+	if len(out) == 0 || out[0] < 'A' || out[0] > 'Z' {
+		out = append([]byte{'A'}, out...)
+	}
+	if len(out) > prodosMaxNameLen {
+		out = out[:prodosMaxNameLen]
+	}
+	return string(out)
+}
+
+func prodosDateTime(t time.Time) []byte {
+	if t.IsZero() || t.Year() < 1940 || t.Year() > 2039 {
+		return []byte{0, 0, 0, 0}
+	}
+	// years past 99 wrap, which is what ProDOS 2.x expects for 2000-2039
+	date := uint16(t.Year()%100)<<9 | uint16(t.Month())<<5 | uint16(t.Day())
+	return []byte{byte(date), byte(date >> 8), byte(t.Minute()), byte(t.Hour())}
+}
+
+func prodosBlockPtr(img []byte, block int, idx int) int {
+	return int(img[block*prodosBlockSize+idx]) | int(img[block*prodosBlockSize+256+idx])<<8
+}
+
+func setProdosBlockPtr(img []byte, block int, idx int, ptr int) {
+	img[block*prodosBlockSize+idx] = byte(ptr)
+	img[block*prodosBlockSize+256+idx] = byte(ptr >> 8)
+}
+
+// prodosVolumeBuilder lays files out in a new ProDOS volume image
+type prodosVolumeBuilder struct {
+	img       []byte
+	nextBlock int
+}
+
+func (b *prodosVolumeBuilder) alloc() (int, error) {
+	if (b.nextBlock+1)*prodosBlockSize > len(b.img) {
+		return 0, fmt.Errorf("volume is full")
+	}
+	b.nextBlock++
+	return b.nextBlock - 1, nil
+}
+
+// writeFile stores data as a seedling, sapling or tree,
+// returning the storage type, key block and blocks used
+func (b *prodosVolumeBuilder) writeFile(data []byte) (int, int, int, error) {
+	nDataBlocks := (len(data) + prodosBlockSize - 1) / prodosBlockSize
+	if nDataBlocks == 0 {
+		nDataBlocks = 1
+	}
+	if nDataBlocks > 256*128 {
+		return 0, 0, 0, fmt.Errorf("file too big for ProDOS")
+	}
+	dataBlocks := []int{}
+	used := 0
+	for i := 0; i < nDataBlocks; i++ {
+		block, err := b.alloc()
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		start := i * prodosBlockSize
+		if start < len(data) {
+			copy(b.img[block*prodosBlockSize:(block+1)*prodosBlockSize], data[start:])
+		}
+		dataBlocks = append(dataBlocks, block)
+		used++
+	}
+	if nDataBlocks == 1 {
+		return prodosSeedling, dataBlocks[0], used, nil
+	}
+	indexBlocks := []int{}
+	for i := 0; i < nDataBlocks; i += 256 {
+		index, err := b.alloc()
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for j := i; j < nDataBlocks && j < i+256; j++ {
+			setProdosBlockPtr(b.img, index, j-i, dataBlocks[j])
+		}
+		indexBlocks = append(indexBlocks, index)
+		used++
+	}
+	if len(indexBlocks) == 1 {
+		return prodosSapling, indexBlocks[0], used, nil
+	}
+	master, err := b.alloc()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for i, index := range indexBlocks {
+		setProdosBlockPtr(b.img, master, i, index)
+	}
+	return prodosTree, master, used + 1, nil
+}
+
+func buildProdosVolume(volName string, files []prodosFile, totalBlocks int) ([]byte, error) {
+	maxFiles := prodosVolDirBlocks*prodosEntriesPerBlock - 1
+	if len(files) > maxFiles {
+		return nil, fmt.Errorf("too many files for a ProDOS volume directory (%v, max %v)", len(files), maxFiles)
+	}
+	bitmapBlocks := (totalBlocks + 4095) / 4096
+	b := prodosVolumeBuilder{
+		img:       make([]byte, totalBlocks*prodosBlockSize),
+		nextBlock: prodosBitmapBlock + bitmapBlocks,
+	}
+
+	for i := 0; i < prodosVolDirBlocks; i++ {
+		block := (prodosVolDirKeyBlock + i) * prodosBlockSize
+		prev, next := 0, 0
+		if i > 0 {
+			prev = prodosVolDirKeyBlock + i - 1
+		}
+		if i < prodosVolDirBlocks-1 {
+			next = prodosVolDirKeyBlock + i + 1
+		}
+		binary.LittleEndian.PutUint16(b.img[block:], uint16(prev))
+		binary.LittleEndian.PutUint16(b.img[block+2:], uint16(next))
+	}
+
+	hdr := b.img[prodosVolDirKeyBlock*prodosBlockSize+4:]
+	volName = prodosName(volName)
+	hdr[0] = prodosVolHdr<<4 | byte(len(volName))
+	copy(hdr[1:16], volName)
+	copy(hdr[24:28], prodosDateTime(time.Now()))
+	hdr[30] = 0xc3
+	hdr[31] = prodosEntryLen
+	hdr[32] = prodosEntriesPerBlock
+	binary.LittleEndian.PutUint16(hdr[33:], uint16(len(files)))
+	binary.LittleEndian.PutUint16(hdr[35:], prodosBitmapBlock)
+	binary.LittleEndian.PutUint16(hdr[37:], uint16(totalBlocks))
+
+	for i, f := range files {
+		storage, key, used, err := b.writeFile(f.data)
+		if err != nil {
+			return nil, fmt.Errorf("could not add %v: %v", f.name, err)
+		}
+		entryNum := i + 1
+		block := prodosVolDirKeyBlock + entryNum/prodosEntriesPerBlock
+		e := b.img[block*prodosBlockSize+4+(entryNum%prodosEntriesPerBlock)*prodosEntryLen:]
+		e[0] = byte(storage)<<4 | byte(len(f.name))
+		copy(e[1:16], f.name)
+		e[16] = f.fileTyp
+		binary.LittleEndian.PutUint16(e[17:], uint16(key))
+		binary.LittleEndian.PutUint16(e[19:], uint16(used))
+		e[21], e[22], e[23] = byte(len(f.data)), byte(len(f.data)>>8), byte(len(f.data)>>16)
+		copy(e[24:28], prodosDateTime(f.modTime))
+		e[30] = 0xe3
+		binary.LittleEndian.PutUint16(e[31:], f.auxType)
+		copy(e[33:37], prodosDateTime(f.modTime))
+		binary.LittleEndian.PutUint16(e[37:], prodosVolDirKeyBlock)
+	}
+
+	// bitmap: 1 is free
+	for block := b.nextBlock; block < totalBlocks; block++ {
+		b.img[prodosBitmapBlock*prodosBlockSize+block/8] |= 0x80 >> uint(block%8)
+	}
+	return b.img, nil
+}
+
+// readProdosFiles lists the files in a volume's directory,
+// skipping anything that doesn't look right.
+func readProdosFiles(img []byte) ([]prodosFile, error) {
+	nBlocks := len(img) / prodosBlockSize
+	validBlock := func(block int) bool { return block > 0 && block < nBlocks }
+
+	files := []prodosFile{}
+	seen := map[int]bool{}
+	for block := prodosVolDirKeyBlock; validBlock(block) && !seen[block]; {
+		seen[block] = true
+		blockBytes := img[block*prodosBlockSize : (block+1)*prodosBlockSize]
+		for i := 0; i < prodosEntriesPerBlock; i++ {
+			e := blockBytes[4+i*prodosEntryLen:]
+			storage, nameLen := int(e[0]>>4), int(e[0]&0xf)
+			if storage < prodosSeedling || storage > prodosTree || nameLen == 0 {
+				continue
+			}
+			eof := int(e[21]) | int(e[22])<<8 | int(e[23])<<16
+			key := int(binary.LittleEndian.Uint16(e[17:]))
+			data, err := readProdosFileData(img, storage, key, eof)
+			if err != nil {
+				return nil, fmt.Errorf("could not read %v: %v", string(e[1:1+nameLen]), err)
+			}
+			files = append(files, prodosFile{
+				name:    string(e[1 : 1+nameLen]),
+				fileTyp: e[16],
+				auxType: binary.LittleEndian.Uint16(e[31:]),
+				data:    data,
+			})
+		}
+		block = int(binary.LittleEndian.Uint16(blockBytes[2:]))
+	}
+	return files, nil
+}
+
+func readProdosFileData(img []byte, storage, key, eof int) ([]byte, error) {
+	nBlocks := len(img) / prodosBlockSize
+	data := make([]byte, eof)
+	copyBlock := func(fileBlock, diskBlock int) error {
+		start := fileBlock * prodosBlockSize
+		if start >= eof || diskBlock == 0 {
+			return nil // past eof, or sparse
+		}
+		if diskBlock >= nBlocks {
+			return fmt.Errorf("block %v is past the end of the volume", diskBlock)
+		}
+		copy(data[start:], img[diskBlock*prodosBlockSize:(diskBlock+1)*prodosBlockSize])
+		return nil
+	}
+	copyIndex := func(indexNum, index int) error {
+		if index == 0 {
+			return nil
+		}
+		if index >= nBlocks {
+			return fmt.Errorf("index block %v is past the end of the volume", index)
+		}
+		for i := 0; i < 256; i++ {
+			if err := copyBlock(indexNum*256+i, prodosBlockPtr(img, index, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch storage {
+	case prodosSeedling:
+		return data, copyBlock(0, key)
+	case prodosSapling:
+		return data, copyIndex(0, key)
+	default:
+		if key >= nBlocks {
+			return nil, fmt.Errorf("master index block %v is past the end of the volume", key)
+		}
+		for i := 0; i < 128; i++ {
+			if err := copyIndex(i, prodosBlockPtr(img, key, i)); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+}
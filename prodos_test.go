@@ -0,0 +1,282 @@
+package a1go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProdosName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"HELLO", "HELLO"},
+		{"hello.bas", "HELLO.BAS"},
+		{"my file", "MY.FILE"},
+		{"2048", "A2048"},
+		{"", "A"},
+		{".profile", "A.PROFILE"},
+		{"averyveryverylongname", "AVERYVERYVERYLO"},
+	}
+	for _, test := range tests {
+		if got := prodosName(test.in); got != test.want {
+			t.Errorf("prodosName(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseHostName(t *testing.T) {
+	tests := []struct {
+		hostName string
+		name     string
+		fileTyp  byte
+		auxType  uint16
+	}{
+		{"HELLO#F80800", "HELLO", 0xf8, 0x0800},
+		{"game.bin", "GAME.BIN", prodosTypeBIN, 0},
+		{"ODD#F808", "ODD.F808", prodosTypeBIN, 0},
+		{"BAD#ZZ0000", "BAD.ZZ0000", prodosTypeBIN, 0},
+	}
+	for _, test := range tests {
+		name, fileTyp, auxType := parseHostName(test.hostName)
+		if name != test.name || fileTyp != test.fileTyp || auxType != test.auxType {
+			t.Errorf("parseHostName(%q) = %q, %02x, %04x, want %q, %02x, %04x",
+				test.hostName, name, fileTyp, auxType, test.name, test.fileTyp, test.auxType)
+		}
+	}
+
+	f := prodosFile{name: "HELLO", fileTyp: 0xf8, auxType: 0x0800}
+	if got := f.hostName(); got != "HELLO#F80800" {
+		t.Errorf("hostName is %q, want HELLO#F80800", got)
+	}
+}
+
+// testFileData is n bytes that differ from block to block
+func testFileData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i/prodosBlockSize + i)
+	}
+	return data
+}
+
+// prodosEntry is the nth entry of a volume's directory, the header
+// being entry 0
+func prodosEntry(img []byte, n int) []byte {
+	block := prodosVolDirKeyBlock + n/prodosEntriesPerBlock
+	return img[block*prodosBlockSize+4+(n%prodosEntriesPerBlock)*prodosEntryLen:]
+}
+
+func prodosBlockFree(img []byte, block int) bool {
+	return img[prodosBitmapBlock*prodosBlockSize+block/8]&(0x80>>uint(block%8)) != 0
+}
+
+func TestBuildProdosVolume(t *testing.T) {
+	modTime := time.Date(2024, time.March, 5, 13, 45, 0, 0, time.UTC)
+	files := []prodosFile{
+		{name: "EMPTY", fileTyp: 0x04, data: []byte{}},
+		{name: "SMALL", fileTyp: prodosTypeBIN, auxType: 0x0300, data: testFileData(100), modTime: modTime},
+		{name: "EXACT", fileTyp: prodosTypeBIN, data: testFileData(prodosBlockSize)},
+		{name: "SAPLING", fileTyp: 0xf8, auxType: 0x0800, data: testFileData(prodosBlockSize + 1)},
+	}
+	const totalBlocks = 64
+	img, err := buildProdosVolume("my vol", files, totalBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img) != totalBlocks*prodosBlockSize {
+		t.Fatalf("volume is %v bytes, want %v", len(img), totalBlocks*prodosBlockSize)
+	}
+
+	hdr := prodosEntry(img, 0)
+	if hdr[0] != prodosVolHdr<<4|6 || string(hdr[1:7]) != "MY.VOL" {
+		t.Errorf("volume header starts % x, want a header for MY.VOL", hdr[:7])
+	}
+	if n := binary.LittleEndian.Uint16(hdr[33:]); n != uint16(len(files)) {
+		t.Errorf("header says %v files, want %v", n, len(files))
+	}
+	if n := binary.LittleEndian.Uint16(hdr[37:]); n != totalBlocks {
+		t.Errorf("header says %v blocks, want %v", n, totalBlocks)
+	}
+
+	// files are laid out in order after the one bitmap block
+	entries := []struct {
+		storage, key, used int
+	}{
+		{prodosSeedling, 7, 1},
+		{prodosSeedling, 8, 1},
+		{prodosSeedling, 9, 1},
+		{prodosSapling, 12, 3},
+	}
+	for i, want := range entries {
+		e := prodosEntry(img, i+1)
+		storage, key, used := int(e[0]>>4), int(binary.LittleEndian.Uint16(e[17:])), int(binary.LittleEndian.Uint16(e[19:]))
+		if storage != want.storage || key != want.key || used != want.used {
+			t.Errorf("%v is storage %v, key block %v, %v blocks, want %v, %v, %v",
+				files[i].name, storage, key, used, want.storage, want.key, want.used)
+		}
+	}
+	if date := prodosEntry(img, 2)[24:28]; !bytes.Equal(date, []byte{0x65, 0x30, 45, 13}) {
+		t.Errorf("SMALL's date is % x, want 65 30 2d 0d", date)
+	}
+
+	for block := 0; block < totalBlocks; block++ {
+		if want := block >= 13; prodosBlockFree(img, block) != want {
+			t.Errorf("block %v free is %v, want %v", block, !want, want)
+		}
+	}
+
+	got, err := readProdosFiles(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(files) {
+		t.Fatalf("read back %v files, want %v", len(got), len(files))
+	}
+	for i, f := range files {
+		g := got[i]
+		if g.name != f.name || g.fileTyp != f.fileTyp || g.auxType != f.auxType || !bytes.Equal(g.data, f.data) {
+			t.Errorf("read back %v as %v type %02x aux %04x with %v bytes, want type %02x aux %04x with %v bytes",
+				f.name, g.name, g.fileTyp, g.auxType, len(g.data), f.fileTyp, f.auxType, len(f.data))
+		}
+	}
+}
+
+func TestBuildProdosVolumeTree(t *testing.T) {
+	data := testFileData(256*prodosBlockSize + 1)
+	img, err := buildProdosVolume("TREE", []prodosFile{{name: "BIG", fileTyp: prodosTypeBIN, data: data}}, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := prodosEntry(img, 1)
+	// 257 data blocks, 2 index blocks and the master index
+	if storage, used := int(e[0]>>4), int(binary.LittleEndian.Uint16(e[19:])); storage != prodosTree || used != 260 {
+		t.Errorf("storage %v with %v blocks, want %v with 260", storage, used, prodosTree)
+	}
+	files, err := readProdosFiles(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || !bytes.Equal(files[0].data, data) {
+		t.Error("tree file didn't read back the same")
+	}
+}
+
+func TestBuildProdosVolumeErrors(t *testing.T) {
+	if _, err := buildProdosVolume("FULL", []prodosFile{{name: "BIG", data: testFileData(8 * prodosBlockSize)}}, 12); err == nil {
+		t.Error("built a volume with more data than blocks")
+	}
+	tooMany := make([]prodosFile, prodosVolDirBlocks*prodosEntriesPerBlock)
+	for i := range tooMany {
+		tooMany[i] = prodosFile{name: prodosName(string(rune('A' + i%26)))}
+	}
+	if _, err := buildProdosVolume("MANY", tooMany, 1024); err == nil {
+		t.Error("built a volume with more files than the directory holds")
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "HELLO#F80800"), []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "game.bin"), testFileData(1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := readProdosFiles(s.img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].name != "GAME.BIN" || files[1].name != "HELLO" || files[1].fileTyp != 0xf8 {
+		t.Fatalf("volume has %+v, want GAME.BIN and HELLO", files)
+	}
+
+	// a file saved on the Apple-1 side goes back out with its type
+	files = append(files, prodosFile{name: "NEW", fileTyp: 0xf8, auxType: 0x0300, data: []byte("new")})
+	if s.img, err = buildProdosVolume("VOL", files, int(s.numBlocks())); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "NEW#F80300")); err != nil || string(data) != "new" {
+		t.Errorf("exported NEW as %q, %v, want \"new\"", data, err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "game.bin")); err != nil || !bytes.Equal(data, testFileData(1000)) {
+		t.Errorf("game.bin didn't keep its host name and data: %v", err)
+	}
+}
+
+func TestDirStoreKeepsToItsDir(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "vol")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the guest can put anything in a directory entry
+	files := []prodosFile{
+		{name: "../ESCAPE", fileTyp: prodosTypeBIN, data: []byte("x")},
+		{name: "SUB/FILE", fileTyp: prodosTypeBIN, data: []byte("x")},
+		{name: `C:\X`, fileTyp: prodosTypeBIN, data: []byte("x")},
+		{name: "OK", fileTyp: prodosTypeBIN, data: []byte("ok")},
+	}
+	if s.img, err = buildProdosVolume("VOL", files, int(s.numBlocks())); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.flush(); err == nil {
+		t.Error("exported bad names without an error")
+	}
+	if _, err := os.Stat(filepath.Join(parent, "ESCAPE#060000")); err == nil {
+		t.Error("exported a file outside the dir")
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "OK#060000" {
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("dir has %v, want just OK#060000", names)
+	}
+}
+
+func TestDirStoreCarriesOverSnapshotLoad(t *testing.T) {
+	dir := t.TempDir()
+	emu, err := newStateWithOptions(Options{
+		Peripherals: []PeripheralConfig{{Type: "cffa1", Args: map[string]string{"dir": dir}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := emu.CFFA1.store
+	// a write still waiting on the quiet-frame flush
+	emu.CFFA1.wroteThisFrame = true
+
+	loaded, err := emu.loadSnapshot(emu.makeSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.CFFA1.store != store {
+		t.Error("snapshot load opened a new store instead of keeping the old one")
+	}
+	if !loaded.CFFA1.needsFlush {
+		t.Error("snapshot load dropped the pending flush")
+	}
+	if emu.CFFA1.store != nil {
+		t.Error("old card kept the store it handed over")
+	}
+}
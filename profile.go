@@ -0,0 +1,164 @@
+package a1go
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Profile is a whole machine: cpu, clock, RAM, ROMs and cards
+type Profile struct {
+	Name        string
+	Description string
+	// CPU is empty for CPU6502
+	CPU CPUType `json:",omitempty"`
+	// ClockHz is zero for a stock Apple-1's ~1.023MHz
+	ClockHz int `json:",omitempty"`
+	// RAM is nil for everywhere RAM can be, see Options.RAM
+	RAM []AddrRange `json:",omitempty"`
+	// ROMSet names a ROMManager rom set
+	ROMSet      string
+	Peripherals []PeripheralConfig `json:",omitempty"`
+}
+
+// DefaultProfileName is the profile used when none is picked. It's a
+// fully loaded board that runs anything, rather than a historical one.
+const DefaultProfileName = "a1go"
+
+var builtinProfiles = []Profile{
+	{
+		Name:        DefaultProfileName,
+		Description: "Apple-1 with every RAM socket filled (48K + 4K at $E000) and BASIC",
+		ROMSet:      "basic",
+	},
+	{
+		Name:        "apple1-4k",
+		Description: "stock 4K Apple-1 with just the Woz Monitor",
+		RAM:         []AddrRange{{0x0000, 0x0fff}},
+		ROMSet:      "woz",
+	},
+	{
+		Name:        "apple1-8k-aci",
+		Description: "8K Apple-1 (4K + 4K at $E000 for BASIC) with the cassette interface",
+		RAM:         []AddrRange{{0x0000, 0x0fff}, {0xe000, 0xefff}},
+		ROMSet:      "aci",
+		Peripherals: []PeripheralConfig{{Type: "aci"}},
+	},
+	{
+		Name:        "replica1",
+		Description: "Replica-1: 65C02 at 1MHz, 32K of RAM and Krusader in ROM",
+		CPU:         CPU65C02,
+		ClockHz:     1000000,
+		RAM:         []AddrRange{{0x0000, 0x7fff}},
+		ROMSet:      "krusader",
+	},
+}
+
+// Options returns the emulator options for this machine. The ROM set is
+// left for a ROMManager to load.
+func (p Profile) Options() Options {
+	return Options{
+		CPU:         p.CPU,
+		ClockHz:     p.ClockHz,
+		RAM:         p.RAM,
+		Peripherals: p.Peripherals,
+	}
+}
+
+func (p Profile) String() string {
+	cpu := p.CPU
+	if cpu == "" {
+		cpu = CPU6502
+	}
+	clock := p.ClockHz
+	if clock == 0 {
+		clock = defaultClockHz
+	}
+	ram := "$0000-$BFFF,$E000-$EFFF"
+	if p.RAM != nil {
+		ranges := []string{}
+		for _, r := range p.RAM {
+			ranges = append(ranges, "$"+strings.Replace(r.String(), "-", "-$", 1))
+		}
+		ram = strings.Join(ranges, ",")
+	}
+	cards := []string{}
+	for _, c := range p.Peripherals {
+		cards = append(cards, c.Type)
+	}
+	return fmt.Sprintf("%v: %v\n    cpu %v at %.3fMHz, ram %v, roms %q, cards [%v]",
+		p.Name, p.Description, cpu, float64(clock)/1e6, ram, p.ROMSet, strings.Join(cards, " "))
+}
+
+// ProfilesPath is where the user's machine profiles live, if they have any
+func ProfilesPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "a1go", "machines.json")
+}
+
+// Profiles holds the known machine profiles by name
+type Profiles map[string]Profile
+
+// LoadProfiles returns the builtin profiles plus any in the user's
+// profiles file (see ProfilesPath).
+func LoadProfiles() (Profiles, error) {
+	profiles := Profiles{}
+	for _, p := range builtinProfiles {
+		profiles[p.Name] = p
+	}
+	if path := ProfilesPath(); path != "" {
+		if err := profiles.LoadFile(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+// LoadFile adds the profiles in a json file, replacing any with the
+// same name. The file is a list of profiles, e.g.
+//
+//	[{"Name": "mine", "CPU": "65c02", "RAM": ["0000-1FFF", "E000-EFFF"],
+//	  "ROMSet": "aci", "Peripherals": [{"Type": "aci"}]}]
+func (ps Profiles) LoadFile(path string) error {
+	profileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var loaded []Profile
+	if err := json.Unmarshal(profileBytes, &loaded); err != nil {
+		return fmt.Errorf("bad machine profiles file %v: %v", path, err)
+	}
+	for _, p := range loaded {
+		if p.Name == "" {
+			return fmt.Errorf("bad machine profiles file %v: every profile needs a Name", path)
+		}
+		ps[p.Name] = p
+	}
+	return nil
+}
+
+// Names returns the profile names, sorted
+func (ps Profiles) Names() []string {
+	names := []string{}
+	for name := range ps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get looks up a profile by name
+func (ps Profiles) Get(name string) (Profile, error) {
+	p, ok := ps[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown machine %q (known machines: %v)", name, strings.Join(ps.Names(), ", "))
+	}
+	return p, nil
+}
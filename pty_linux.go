@@ -0,0 +1,71 @@
+//go:build linux
+// +build linux
+
+package a1go
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// pty is the master side of a pseudo-terminal. It holds on to the slave
+// side too, so the master doesn't see hangups as clients come and go,
+// and so raw mode sticks.
+type pty struct {
+	*os.File
+	slave *os.File
+}
+
+// openPTY makes a new pseudo-terminal in raw mode, returning the master
+// side and the name of the slave side for the user to connect to.
+func openPTY() (io.ReadWriter, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var ptyNum uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, unsafe.Pointer(&ptyNum)); err != nil {
+		master.Close()
+		return nil, "", fmt.Errorf("could not get pty number: %v", err)
+	}
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, "", fmt.Errorf("could not unlock pty: %v", err)
+	}
+	slaveName := fmt.Sprintf("/dev/pts/%d", ptyNum)
+
+	slave, err := os.OpenFile(slaveName, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	var t syscall.Termios
+	if err := ioctl(slave.Fd(), syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, "", fmt.Errorf("could not get pty settings: %v", err)
+	}
+	// cfmakeraw
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	if err := ioctl(slave.Fd(), syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, "", fmt.Errorf("could not set pty to raw mode: %v", err)
+	}
+	return &pty{master, slave}, slaveName, nil
+}
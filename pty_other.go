@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package a1go
+
+import (
+	"fmt"
+	"io"
+)
+
+func openPTY() (io.ReadWriter, string, error) {
+	return nil, "", fmt.Errorf("ptys are only supported on linux, try a tcp link instead")
+}
@@ -0,0 +1,107 @@
+package a1go
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AddrRange is an inclusive range of addresses, e.g. $0000-$0FFF.
+// In json it's a string like "0000-0FFF".
+type AddrRange struct {
+	Start, End uint16
+}
+
+func (r AddrRange) String() string {
+	return fmt.Sprintf("%04X-%04X", r.Start, r.End)
+}
+
+// ParseAddrRange parses ranges like "0000-0FFF" or "$E000-$EFFF"
+func ParseAddrRange(s string) (AddrRange, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return AddrRange{}, fmt.Errorf("bad address range %q, want e.g. 0000-0FFF", s)
+	}
+	start, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(parts[0]), "$"), 16, 16)
+	if err != nil {
+		return AddrRange{}, fmt.Errorf("bad address range %q: %v", s, err)
+	}
+	end, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(parts[1]), "$"), 16, 16)
+	if err != nil {
+		return AddrRange{}, fmt.Errorf("bad address range %q: %v", s, err)
+	}
+	if end < start {
+		return AddrRange{}, fmt.Errorf("bad address range %q: end is before start", s)
+	}
+	return AddrRange{uint16(start), uint16(end)}, nil
+}
+
+func (r AddrRange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *AddrRange) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseAddrRange(s)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r AddrRange) contains(addr uint16) bool {
+	return addr >= r.Start && addr <= r.End
+}
+
+// setRAMLayout populates RAM only in the given ranges. Ranges must be
+// whole pages inside the board's RAM areas ($0000-$BFFF, $E000-$EFFF).
+func (emu *emuState) setRAMLayout(ranges []AddrRange) error {
+	var populated [256]bool
+	for _, r := range ranges {
+		if r.Start&0xff != 0 || r.End&0xff != 0xff {
+			return fmt.Errorf("ram range %v must start and end on page boundaries", r)
+		}
+		inBank1 := int(r.End) < ramBank1Size
+		inBank2 := r.Start >= 0xe000 && r.End < 0xe000+ramBank2Size
+		if !inBank1 && !inBank2 {
+			return fmt.Errorf("ram range %v must be inside $0000-$%04X or $E000-$%04X", r, ramBank1Size-1, 0xe000+ramBank2Size-1)
+		}
+		for page := int(r.Start >> 8); page <= int(r.End>>8); page++ {
+			populated[page] = true
+		}
+	}
+	for page := range emu.Mem.Unpopulated {
+		emu.Mem.Unpopulated[page] = emu.Mem.isRAMArea(uint16(page<<8)) && !populated[page]
+	}
+	return nil
+}
+
+func (m *mem) isRAMArea(addr uint16) bool {
+	return addr < ramBank1Size || (addr >= 0xe000 && addr < 0xe000+ramBank2Size)
+}
+
+// isRAM reports if addr is in a populated RAM page
+func (m *mem) isRAM(addr uint16) bool {
+	return m.isRAMArea(addr) && !m.Unpopulated[addr>>8]
+}
+
+// ramLayout describes the populated RAM, for reports
+func (m *mem) ramLayout() []AddrRange {
+	ranges := []AddrRange{}
+	for addr := 0; addr < 0x10000; addr += 0x100 {
+		if !m.isRAM(uint16(addr)) {
+			continue
+		}
+		if n := len(ranges); n > 0 && int(ranges[n-1].End)+1 == addr {
+			ranges[n-1].End = uint16(addr + 0xff)
+		} else {
+			ranges = append(ranges, AddrRange{uint16(addr), uint16(addr + 0xff)})
+		}
+	}
+	return ranges
+}
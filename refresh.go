@@ -0,0 +1,23 @@
+package a1go
+
+// The apple-1's video section and DRAM refresh share the bus with the
+// cpu. Every 65 cycle scanline, the cpu's clock is held off for 4
+// cycles while the DRAM is refreshed, so only 61 cycles per line go to
+// the cpu. That takes a nominal 1.023MHz down to ~0.96MHz, which is
+// what timing loops written on real boards expect.
+const (
+	refreshCPUCyclesPerLine    = 61
+	refreshStolenCyclesPerLine = 4
+)
+
+// stolenRefreshCycles takes cpu cycles just run and returns
+// how many extra clock cycles the refresh held the cpu off for.
+func (emu *emuState) stolenRefreshCycles(cpuCycles uint64) uint64 {
+	stolen := uint64(0)
+	emu.RefreshCounter += cpuCycles
+	for emu.RefreshCounter >= refreshCPUCyclesPerLine {
+		emu.RefreshCounter -= refreshCPUCyclesPerLine
+		stolen += refreshStolenCyclesPerLine
+	}
+	return stolen
+}
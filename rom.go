@@ -0,0 +1,120 @@
+package a1go
+
+import "fmt"
+
+// romImage is a ROM mapped in somewhere outside of RAM
+type romImage struct {
+	Addr uint16
+	Data []byte
+}
+
+func (r *romImage) contains(addr uint16) bool {
+	return addr >= r.Addr && int(addr-r.Addr) < len(r.Data)
+}
+
+// romByte looks addr up in the user's monitor, then in the loaded
+// ROMs, latest loaded first
+func (m *mem) romByte(addr uint16) (byte, bool) {
+	if m.Monitor.contains(addr) {
+		return m.Monitor.Data[addr-m.Monitor.Addr], true
+	}
+	for i := len(m.ROMs) - 1; i >= 0; i-- {
+		if r := &m.ROMs[i]; r.contains(addr) {
+			return r.Data[addr-r.Addr], true
+		}
+	}
+	return 0, false
+}
+
+// memAreas splits the address space up for loadROM
+var memAreas = []struct {
+	start, end int
+	isRAM      bool
+	isIO       bool
+}{
+	{0x0000, ramBank1Size, true, false},
+	{0xc000, 0xd000, false, false},
+	{0xd000, 0xe000, false, true},
+	{0xe000, 0xf000, true, false},
+	{0xf000, 0x10000, false, false},
+}
+
+// loadROM places a ROM image. Any part that lands in RAM is just copied
+// in, the way BASIC used to come in off of tape. Any other part, including
+// parts in RAM areas with no RAM populated or with a card over them, is
+// mapped in as real ROM, and reads from it win over anything built-in.
+func (emu *emuState) loadROM(addr uint16, rom []byte) error {
+	if len(rom) == 0 {
+		return fmt.Errorf("rom is empty")
+	}
+	if len(rom)+int(addr) > 0x10000 {
+		return fmt.Errorf("rom len %v too big to load at 0x%04x", len(rom), addr)
+	}
+	start, end := int(addr), int(addr)+len(rom)
+	for _, area := range memAreas {
+		if end <= area.start || start >= area.end {
+			continue
+		}
+		if area.isIO {
+			return fmt.Errorf("rom at 0x%04x-0x%04x overlaps I/O space", start, end-1)
+		}
+	}
+	for _, area := range memAreas {
+		chunkStart, chunkEnd := start, end
+		if chunkStart < area.start {
+			chunkStart = area.start
+		}
+		if chunkEnd > area.end {
+			chunkEnd = area.end
+		}
+		if chunkStart >= chunkEnd {
+			continue
+		}
+		chunk := rom[chunkStart-start : chunkEnd-start]
+		if area.isRAM && emu.allRAM(chunkStart, chunkEnd) {
+			if err := emu.loadBinaryToMem(uint16(chunkStart), chunk); err != nil {
+				return err
+			}
+		} else {
+			emu.Mem.mapROM(uint16(chunkStart), chunk)
+		}
+	}
+	return nil
+}
+
+// loadMonitorROM maps in a replacement for the built-in Woz Monitor.
+// It must end at $FFFF, so it can be anywhere from a single page at
+// $FF00 up to a full $F000-$FFFF image. It stays over any ROMs mapped
+// there, before or after, e.g. a rom set's own monitor.
+func (emu *emuState) loadMonitorROM(rom []byte) error {
+	if len(rom) == 0 || len(rom) > 0x1000 {
+		return fmt.Errorf("monitor rom must be 1-4096 bytes, to fit in $F000-$FFFF, got %v", len(rom))
+	}
+	emu.Mem.Monitor = romImage{Addr: uint16(0x10000 - len(rom)), Data: append([]byte{}, rom...)}
+	return nil
+}
+
+// allRAM reports if start-end is all populated RAM, with no cards over it
+func (emu *emuState) allRAM(start, end int) bool {
+	isRAM := func(addr int) bool {
+		return emu.Mem.isRAM(uint16(addr)) && emu.cardPages[addr>>8] == nil
+	}
+	for addr := start; addr < end; addr += 0x100 {
+		if !isRAM(addr) {
+			return false
+		}
+	}
+	return isRAM(end - 1)
+}
+
+// mapROM maps in an image, replacing any earlier image at the same addr
+func (m *mem) mapROM(addr uint16, rom []byte) {
+	img := romImage{Addr: addr, Data: append([]byte{}, rom...)}
+	for i := range m.ROMs {
+		if m.ROMs[i].Addr == addr {
+			m.ROMs[i] = img
+			return
+		}
+	}
+	m.ROMs = append(m.ROMs, img)
+}
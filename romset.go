@@ -0,0 +1,409 @@
+package a1go
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KnownROM describes a ROM image the ROMManager can identify and place
+type KnownROM struct {
+	Name string
+	// SHA1s are the hex sha1s of the dumps of this image that are known
+	// good, as there's more than one dump of most of these going round.
+	// Images can still be found by Files without one, but they're
+	// reported as unverified.
+	SHA1s []string
+	Addr  uint16
+	// Files are the usual filenames for this image
+	Files []string
+	// BASIC is set for images that have Integer BASIC in them, for
+	// features like loading and listing BASIC programs
+	BASIC bool
+}
+
+const wozMonitorName = "Woz Monitor"
+
+func (k KnownROM) hasSHA1(sum string) bool {
+	for _, s := range k.SHA1s {
+		if s == sum {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinROMs are the ROMs a1go knows about out of the box. Only the
+// Woz Monitor, which is compiled in, has a known sha1 here. The rest
+// are matched by filename until the user manifest (see ManifestPath)
+// gives the sha1s of the dumps to accept for them.
+var builtinROMs = []KnownROM{
+	{
+		Name:  wozMonitorName,
+		SHA1s: []string{"224767aa499dc98767e042f375ced1359be8a35f"},
+		Addr:  0xff00,
+		Files: []string{"wozmon.bin", "monitor.bin"},
+	},
+	{
+		Name:  "Integer BASIC",
+		Addr:  0xe000,
+		Files: []string{"basic.bin", "a1basic.bin"},
+		BASIC: true,
+	},
+	{
+		Name:  "ACI",
+		Addr:  0xc100,
+		Files: []string{"aci.bin"},
+	},
+	{
+		Name:  "CFFA1",
+		Addr:  0x9000,
+		Files: []string{"cffa1.bin", "CFFA1.bin"},
+	},
+	{
+		Name:  "Krusader",
+		Addr:  0xe000,
+		Files: []string{"krusader.bin"},
+	},
+}
+
+var builtinROMSets = map[string][]string{
+	"woz":      {wozMonitorName},
+	"basic":    {wozMonitorName, "Integer BASIC"},
+	"aci":      {wozMonitorName, "ACI"},
+	"krusader": {"Krusader"},
+}
+
+// ROMManager finds ROM images along a search path, identifies them by
+// sha1, and loads them at the right addresses.
+type ROMManager struct {
+	SearchPath []string
+	Known      []KnownROM
+	Sets       map[string][]string
+}
+
+// LoadedROM reports what a ROMManager loaded
+type LoadedROM struct {
+	// Name is empty if the image wasn't identified
+	Name string
+	// Path is empty for compiled-in images
+	Path string
+	SHA1 string
+	Addr uint16
+	Size int
+	// Verified is true if the image's sha1 matched the manifest
+	Verified bool
+	// BASIC is true if the image is one the manifest says has
+	// Integer BASIC in it
+	BASIC bool
+}
+
+func (l LoadedROM) String() string {
+	name := l.Name
+	if name == "" {
+		name = "unknown rom"
+	}
+	from := l.Path
+	if from == "" {
+		from = "built-in"
+	}
+	check := "sha1 verified"
+	if !l.Verified {
+		check = "sha1 " + l.SHA1 + " not in manifest"
+	}
+	return fmt.Sprintf("%v at $%04X-$%04X (%v, %v)", name, l.Addr, int(l.Addr)+l.Size-1, from, check)
+}
+
+// ManifestPath is where the user's ROM manifest lives, if they have one
+func ManifestPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "a1go", "roms.json")
+}
+
+// DefaultROMSearchPath is the user's config dir, then the roms dir next
+// to the executable, then the roms dir in the current working dir.
+func DefaultROMSearchPath() []string {
+	path := []string{}
+	if dir, err := os.UserConfigDir(); err == nil {
+		path = append(path, filepath.Join(dir, "a1go", "roms"))
+	}
+	if execPath, err := os.Executable(); err == nil {
+		path = append(path, filepath.Join(filepath.Dir(execPath), "roms"))
+	}
+	return append(path, "roms")
+}
+
+// romManifest is the json format of the user manifest, e.g.
+//
+//	{
+//		"ROMs": [
+//			{"Name": "My Monitor", "SHA1": "...", "Addr": "FF00", "Files": ["mymon.bin"]},
+//			{"Name": "Integer BASIC", "SHA1s": ["...", "..."]}
+//		],
+//		"Sets": {"mine": ["My Monitor", "Integer BASIC"]}
+//	}
+type romManifest struct {
+	ROMs []struct {
+		Name  string
+		SHA1  string
+		SHA1s []string
+		Addr  string
+		Files []string
+		BASIC *bool
+	}
+	Sets map[string][]string
+}
+
+// NewROMManager makes a ROMManager with the default search path and the
+// builtin ROMs and sets, plus anything in the user's manifest.
+func NewROMManager() (*ROMManager, error) {
+	m := &ROMManager{
+		SearchPath: DefaultROMSearchPath(),
+		Known:      append([]KnownROM{}, builtinROMs...),
+		Sets:       map[string][]string{},
+	}
+	for name, roms := range builtinROMSets {
+		m.Sets[name] = roms
+	}
+	if manifestPath := ManifestPath(); manifestPath != "" {
+		if err := m.LoadManifest(manifestPath); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// LoadManifest adds the ROMs and sets in a json manifest file. ROM
+// entries with the name of an existing ROM add their sha1s to the ones
+// it accepts, and replace its addr, files and BASIC flag if they give
+// them. Set entries with the name of an existing set replace it.
+func (m *ROMManager) LoadManifest(path string) error {
+	manifestBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var manifest romManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return fmt.Errorf("bad rom manifest %v: %v", path, err)
+	}
+	for _, r := range manifest.ROMs {
+		sums := []string{}
+		for _, sum := range append([]string{r.SHA1}, r.SHA1s...) {
+			if sum != "" {
+				sums = append(sums, strings.ToLower(sum))
+			}
+		}
+		var addr uint64
+		if r.Addr != "" {
+			if addr, err = strconv.ParseUint(strings.TrimPrefix(r.Addr, "$"), 16, 16); err != nil {
+				return fmt.Errorf("bad addr for rom %q in %v: %v", r.Name, path, err)
+			}
+		}
+		merged := false
+		for i := range m.Known {
+			if m.Known[i].Name != r.Name {
+				continue
+			}
+			known := &m.Known[i]
+			known.SHA1s = append(append([]string{}, known.SHA1s...), sums...)
+			if r.Addr != "" {
+				known.Addr = uint16(addr)
+			}
+			if len(r.Files) > 0 {
+				known.Files = r.Files
+			}
+			if r.BASIC != nil {
+				known.BASIC = *r.BASIC
+			}
+			merged = true
+		}
+		if !merged {
+			if r.Addr == "" {
+				return fmt.Errorf("rom %q in %v needs an addr", r.Name, path)
+			}
+			m.Known = append(m.Known, KnownROM{
+				Name:  r.Name,
+				SHA1s: sums,
+				Addr:  uint16(addr),
+				Files: r.Files,
+				BASIC: r.BASIC != nil && *r.BASIC,
+			})
+		}
+	}
+	for name, roms := range manifest.Sets {
+		m.Sets[name] = roms
+	}
+	return nil
+}
+
+// SetNames returns the names of all known ROM sets, sorted
+func (m *ROMManager) SetNames() []string {
+	names := []string{}
+	for name := range m.Sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Identify looks up a ROM image by sha1
+func (m *ROMManager) Identify(data []byte) (KnownROM, bool) {
+	sum := sha1Hex(data)
+	for _, known := range m.Known {
+		if known.hasSHA1(sum) {
+			return known, true
+		}
+	}
+	return KnownROM{}, false
+}
+
+func (m *ROMManager) knownByName(name string) (KnownROM, bool) {
+	for _, known := range m.Known {
+		if known.Name == name {
+			return known, true
+		}
+	}
+	return KnownROM{}, false
+}
+
+// find looks for a ROM's image along the search path. Files with the
+// right sha1 win over files that just have the right name.
+func (m *ROMManager) find(known KnownROM) (string, []byte, error) {
+	var fallbackPath string
+	var fallbackData []byte
+	for _, dir := range m.SearchPath {
+		for _, filename := range known.Files {
+			path := filepath.Join(dir, filename)
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			if known.hasSHA1(sha1Hex(data)) {
+				return path, data, nil
+			}
+			if fallbackData == nil {
+				fallbackPath, fallbackData = path, data
+			}
+		}
+	}
+	if fallbackData != nil {
+		return fallbackPath, fallbackData, nil
+	}
+	if known.Name == wozMonitorName {
+		return "", monitorROM[:], nil
+	}
+	return "", nil, fmt.Errorf("could not find %v (looked for %v in %v)",
+		known.Name, strings.Join(known.Files, ", "), strings.Join(m.SearchPath, ", "))
+}
+
+// describe reports on an image, naming it by sha1 if it's known
+func (m *ROMManager) describe(name, path string, addr uint16, data []byte) LoadedROM {
+	loaded := LoadedROM{
+		Name: name,
+		Path: path,
+		SHA1: sha1Hex(data),
+		Addr: addr,
+		Size: len(data),
+	}
+	if known, ok := m.Identify(data); ok {
+		loaded.Name = known.Name
+		loaded.Verified = true
+	}
+	if known, ok := m.knownByName(loaded.Name); ok {
+		loaded.BASIC = known.BASIC
+	}
+	return loaded
+}
+
+func (m *ROMManager) place(emu Emulator, name, path string, addr uint16, data []byte) (LoadedROM, error) {
+	loaded := m.describe(name, path, addr, data)
+	if err := emu.LoadROM(addr, data); err != nil {
+		return loaded, fmt.Errorf("could not load %v: %v", path, err)
+	}
+	return loaded, nil
+}
+
+// LoadROM finds the named ROM along the search path and loads it
+func (m *ROMManager) LoadROM(emu Emulator, name string) (LoadedROM, error) {
+	known, ok := m.knownByName(name)
+	if !ok {
+		return LoadedROM{}, fmt.Errorf("unknown rom %q", name)
+	}
+	path, data, err := m.find(known)
+	if err != nil {
+		return LoadedROM{}, err
+	}
+	return m.place(emu, known.Name, path, known.Addr, data)
+}
+
+// LoadSet loads every ROM in the named set. It keeps going past ROMs
+// it can't load, returning what did load along with the first error.
+// A monitor given to Emulator.LoadMonitorROM stays over the set's.
+func (m *ROMManager) LoadSet(emu Emulator, setName string) ([]LoadedROM, error) {
+	names, ok := m.Sets[setName]
+	if !ok {
+		return nil, fmt.Errorf("unknown rom set %q (known sets: %v)", setName, strings.Join(m.SetNames(), ", "))
+	}
+	var firstErr error
+	loaded := []LoadedROM{}
+	for _, name := range names {
+		l, err := m.LoadROM(emu, name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		loaded = append(loaded, l)
+	}
+	return loaded, firstErr
+}
+
+// LoadMonitorFile loads a replacement monitor from a file,
+// see Emulator.LoadMonitorROM
+func (m *ROMManager) LoadMonitorFile(emu Emulator, path string) (LoadedROM, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return LoadedROM{}, err
+	}
+	if len(data) == 0 || len(data) > 0x1000 {
+		return LoadedROM{}, fmt.Errorf("monitor rom %v must be 1-4096 bytes, to fit in $F000-$FFFF, got %v", path, len(data))
+	}
+	loaded := m.describe("monitor", path, uint16(0x10000-len(data)), data)
+	if err := emu.LoadMonitorROM(data); err != nil {
+		return loaded, fmt.Errorf("could not load %v: %v", path, err)
+	}
+	return loaded, nil
+}
+
+// LoadFile loads a ROM image from a file. If addr is nil, the image
+// must be identifiable by sha1, so the manifest can say where it goes.
+func (m *ROMManager) LoadFile(emu Emulator, path string, addr *uint16) (LoadedROM, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return LoadedROM{}, err
+	}
+	if addr == nil {
+		known, ok := m.Identify(data)
+		if !ok {
+			return LoadedROM{}, fmt.Errorf("%v (sha1 %v) is not in the rom manifest, so give it an address, e.g. %v@E000", path, sha1Hex(data), path)
+		}
+		addr = &known.Addr
+	}
+	return m.place(emu, "", path, *addr, data)
+}
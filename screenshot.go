@@ -0,0 +1,41 @@
+package a1go
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+)
+
+// maxScreenshotScale keeps screenshots to a sane size
+const maxScreenshotScale = 16
+
+// screenshotImage returns the screen as an image, each pixel scaled
+// up to a scale x scale block, in the theme's colors
+func (emu *emuState) screenshotImage(scale int) (*image.RGBA, error) {
+	if scale < 1 || scale > maxScreenshotScale {
+		return nil, fmt.Errorf("bad screenshot scale %v, want 1-%v", scale, maxScreenshotScale)
+	}
+	t := &emu.Terminal
+	theme := emu.currentTheme()
+	img := image.NewRGBA(image.Rect(0, 0, t.W*scale, t.H*scale))
+	for y := 0; y < t.H; y++ {
+		for x := 0; x < t.W; x++ {
+			c := theme.shade(t.screen[(y*t.W+x)*4])
+			for sy := 0; sy < scale; sy++ {
+				for sx := 0; sx < scale; sx++ {
+					img.SetRGBA(x*scale+sx, y*scale+sy, c)
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+func (emu *emuState) screenshot(w io.Writer, scale int) error {
+	img, err := emu.screenshotImage(scale)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
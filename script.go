@@ -0,0 +1,352 @@
+package a1go
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ScriptHelp describes the script format, for usage messages
+const ScriptHelp = `one command per line, # starts a comment, strings are quoted Go style:
+  type "0300R\r"              type text (queued, like autokey input)
+  wait-for "\\" [CYCLES]      wait until new output contains the text, or fail
+  timeout CYCLES              set the default wait-for timeout (10000000)
+  wait CYCLES                 just let the emulator run
+  press reset|clear           press a button
+  assert-screen-contains "X"  fail unless the screen shows X
+  assert-mem 0300 A9 [..]     fail unless memory from 0300 holds these bytes
+  snapshot [FILE]             save a snapshot (default SCRIPT.snapshotN)
+  load FILE@ADDR              load a binary file into memory at hex ADDR`
+
+const defaultScriptTimeout = 10000000
+
+// maxScriptOutput caps how much unmatched output a ScriptRunner keeps
+const maxScriptOutput = 64 * 1024
+
+// Script is a list of commands for driving a session, expect style:
+// type things in, wait for the output, and check the results.
+type Script struct {
+	Name string
+	cmds []scriptCmd
+}
+
+type scriptCmd struct {
+	line int
+	name string
+	args []string
+
+	// filled in by ParseScript as needed
+	text   []byte
+	cycles uint64
+	addr   uint16
+	data   []byte
+	file   string
+}
+
+// ParseScript parses a script. name is used in errors, and relative
+// paths in the script are relative to its dir.
+func ParseScript(name string, src []byte) (*Script, error) {
+	script := &Script{Name: name}
+	dir := filepath.Dir(name)
+	for i, line := range strings.Split(string(src), "\n") {
+		args, err := splitScriptLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", name, i+1, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		cmd := scriptCmd{line: i + 1, name: args[0], args: args[1:]}
+		if err := cmd.parseArgs(dir); err != nil {
+			return nil, fmt.Errorf("%v:%v: %v: %v", name, i+1, cmd.name, err)
+		}
+		script.cmds = append(script.cmds, cmd)
+	}
+	return script, nil
+}
+
+// LoadScript reads and parses a script file
+func LoadScript(path string) (*Script, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScript(path, src)
+}
+
+// splitScriptLine splits a line into words and quoted strings,
+// dropping any comment
+func splitScriptLine(line string) ([]string, error) {
+	args := []string{}
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		if line == "" || line[0] == '#' {
+			return args, nil
+		}
+		if line[0] == '"' {
+			end := 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			str, err := strconv.Unquote(line[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad string %v", line[:end+1])
+			}
+			args = append(args, str)
+			line = line[end+1:]
+		} else {
+			end := strings.IndexAny(line, " \t\r")
+			if end < 0 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+		}
+	}
+}
+
+func (cmd *scriptCmd) wantArgs(min, max int) error {
+	if len(cmd.args) < min || len(cmd.args) > max {
+		if min == max {
+			return fmt.Errorf("want %v args, got %v", min, len(cmd.args))
+		}
+		return fmt.Errorf("want %v to %v args, got %v", min, max, len(cmd.args))
+	}
+	return nil
+}
+
+func (cmd *scriptCmd) parseArgs(dir string) error {
+	var err error
+	switch cmd.name {
+	case "type":
+		if err = cmd.wantArgs(1, 1); err == nil {
+			cmd.text = []byte(cmd.args[0])
+		}
+	case "wait-for":
+		if err = cmd.wantArgs(1, 2); err == nil {
+			// output has '\r' for newlines, so match either
+			cmd.text = []byte(strings.Replace(cmd.args[0], "\n", "\r", -1))
+			if len(cmd.args) == 2 {
+				cmd.cycles, err = strconv.ParseUint(cmd.args[1], 10, 64)
+			}
+		}
+	case "timeout", "wait":
+		if err = cmd.wantArgs(1, 1); err == nil {
+			cmd.cycles, err = strconv.ParseUint(cmd.args[0], 10, 64)
+		}
+	case "press":
+		if err = cmd.wantArgs(1, 1); err == nil && cmd.args[0] != "reset" && cmd.args[0] != "clear" {
+			err = fmt.Errorf("can press reset or clear, not %q", cmd.args[0])
+		}
+	case "assert-screen-contains":
+		if err = cmd.wantArgs(1, 1); err == nil {
+			cmd.text = []byte(cmd.args[0])
+		}
+	case "assert-mem":
+		if err = cmd.wantArgs(2, 1+0x10000); err == nil {
+			cmd.addr, err = parseScriptAddr(cmd.args[0])
+			for _, arg := range cmd.args[1:] {
+				if err != nil {
+					break
+				}
+				var b uint64
+				b, err = strconv.ParseUint(arg, 16, 8)
+				cmd.data = append(cmd.data, byte(b))
+			}
+		}
+	case "snapshot":
+		if err = cmd.wantArgs(0, 1); err == nil && len(cmd.args) == 1 {
+			cmd.file = scriptPath(dir, cmd.args[0])
+		}
+	case "load":
+		if err = cmd.wantArgs(1, 1); err == nil {
+			at := strings.LastIndex(cmd.args[0], "@")
+			if at < 0 {
+				return fmt.Errorf("want FILE@ADDR, got %q", cmd.args[0])
+			}
+			cmd.file = scriptPath(dir, cmd.args[0][:at])
+			cmd.addr, err = parseScriptAddr(cmd.args[0][at+1:])
+		}
+	default:
+		err = fmt.Errorf("unknown command")
+	}
+	return err
+}
+
+func parseScriptAddr(s string) (uint16, error) {
+	addr, err := strconv.ParseUint(strings.TrimPrefix(s, "$"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad hex addr %q", s)
+	}
+	return uint16(addr), nil
+}
+
+func scriptPath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// ScriptRunner runs a Script against an Emulator. It never runs the
+// emulator itself: whoever owns it calls Update between frames, so
+// scripts work the same in a window or headless (see Run).
+type ScriptRunner struct {
+	script *Script
+	next   int
+
+	// output is what's been displayed since the last wait-for matched
+	output []byte
+
+	timeout       uint64
+	waitStart     uint64
+	waiting       bool
+	snapshotCount int
+	err           error
+}
+
+// NewScriptRunner starts running script against emu, watching its output from here on
+func NewScriptRunner(script *Script, emu Emulator) *ScriptRunner {
+	r := &ScriptRunner{script: script, timeout: defaultScriptTimeout}
+	emu.WatchOutput(func(char byte) {
+		if r.Done() {
+			return
+		}
+		r.output = append(r.output, char)
+		if len(r.output) > maxScriptOutput {
+			r.output = r.output[len(r.output)-maxScriptOutput/2:]
+		}
+	})
+	return r
+}
+
+// Done reports if the script has finished, or failed
+func (r *ScriptRunner) Done() bool {
+	return r.err != nil || r.next >= len(r.script.cmds)
+}
+
+// Update runs script commands until one needs the emulator to run
+// on, like a wait-for. It returns true once the script is done, and
+// an error, with the script line, if a command failed. Guest code
+// exiting through the host services device ends the script too.
+func (r *ScriptRunner) Update(emu Emulator) (bool, error) {
+	if code, exited := emu.ExitRequested(); exited && !r.Done() {
+		// guest code called it quits, see hostsvc.go
+		r.next = len(r.script.cmds)
+		if code != 0 {
+			r.err = fmt.Errorf("%v: guest code exited with status %v", r.script.Name, code)
+		}
+	}
+	for !r.Done() {
+		cmd := &r.script.cmds[r.next]
+		ready, err := r.run(emu, cmd)
+		if err != nil {
+			r.err = fmt.Errorf("%v:%v: %v: %v", r.script.Name, cmd.line, cmd.name, err)
+			break
+		}
+		if !ready {
+			return false, nil
+		}
+		r.next++
+	}
+	return true, r.err
+}
+
+// Run runs the whole script, running emu as fast as it can in between
+func (r *ScriptRunner) Run(emu Emulator) error {
+	for {
+		done, err := r.Update(emu)
+		if done {
+			return err
+		}
+		emu.RunFrame(Input{})
+	}
+}
+
+// run tries a cmd, returning false if it needs to wait on the emulator
+func (r *ScriptRunner) run(emu Emulator, cmd *scriptCmd) (bool, error) {
+	switch cmd.name {
+	case "type":
+		emu.Type(cmd.text)
+	case "wait-for":
+		if i := bytes.Index(r.output, cmd.text); i >= 0 {
+			r.output = r.output[i+len(cmd.text):]
+			r.waiting = false
+			return true, nil
+		}
+		timeout := r.timeout
+		if cmd.cycles != 0 {
+			timeout = cmd.cycles
+		}
+		if !r.waited(emu, timeout) {
+			return false, nil
+		}
+		return false, fmt.Errorf("timed out after %v cycles waiting for %q, screen was:\n%v", timeout, cmd.args[0], strings.TrimRight(emu.ScreenText(), "\n"))
+	case "timeout":
+		r.timeout = cmd.cycles
+	case "wait":
+		return r.waited(emu, cmd.cycles), nil
+	case "press":
+		var input Input
+		if cmd.args[0] == "reset" {
+			input.ResetButton = true
+		} else {
+			input.ClearScreenButton = true
+		}
+		emu.UpdateInput(input)
+		emu.UpdateInput(Input{})
+	case "assert-screen-contains":
+		if screen := emu.ScreenText(); !strings.Contains(screen, string(cmd.text)) {
+			return false, fmt.Errorf("screen doesn't contain %q, screen was:\n%v", cmd.args[0], strings.TrimRight(screen, "\n"))
+		}
+	case "assert-mem":
+		for i, want := range cmd.data {
+			addr := cmd.addr + uint16(i)
+			if got := emu.ReadMem(addr); got != want {
+				return false, fmt.Errorf("$%04X is %02X, want %02X", addr, got, want)
+			}
+		}
+	case "snapshot":
+		filename := cmd.file
+		if filename == "" {
+			r.snapshotCount++
+			filename = fmt.Sprintf("%v.snapshot%v", r.script.Name, r.snapshotCount)
+		}
+		if err := ioutil.WriteFile(filename, emu.MakeSnapshot(), os.FileMode(0644)); err != nil {
+			return false, err
+		}
+	case "load":
+		bin, err := ioutil.ReadFile(cmd.file)
+		if err != nil {
+			return false, err
+		}
+		if err := emu.LoadBinaryToMem(cmd.addr, bin); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// waited returns true once cycles have passed since the wait began
+func (r *ScriptRunner) waited(emu Emulator, cycles uint64) bool {
+	// a snapshot load can turn back the clock, so start over then
+	if !r.waiting || emu.CycleCount() < r.waitStart {
+		r.waiting = true
+		r.waitStart = emu.CycleCount()
+	}
+	if emu.CycleCount()-r.waitStart < cycles {
+		return false
+	}
+	r.waiting = false
+	return true
+}
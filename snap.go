@@ -46,12 +46,21 @@ func (emu *emuState) convertLatestSnapshot(jsonBytes json.RawMessage) (*emuState
 		return nil, err
 	}
 
-	unpackTerminalFromSnap(&newState)
+	unpackTerminalFromSnap(&newState, emu)
 
-	newState.CPU.RunCycles = newState.runCycles
-	newState.CPU.Write = newState.write
-	newState.CPU.Read = newState.read
-	newState.CPU.Err = func(e error) { emuErr(e) }
+	if err = newState.initCPU(); err != nil {
+		return nil, err
+	}
+	emu.handOverHost(&newState)
+	if err = newState.attachCards(); err != nil {
+		// the old emulator carries on, so give back what it can use
+		newState.handOverHost(emu)
+		return nil, err
+	}
+	newState.console = emu.console
+	newState.outputWatchers = emu.outputWatchers
+	newState.hostOut = emu.hostOut
+	newState.theme = emu.theme
 
 	return &newState, nil
 }
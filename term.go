@@ -1,18 +1,46 @@
 package a1go
 
+import (
+	"strings"
+	"unicode"
+)
+
 type terminal struct {
 	X, Y          int
 	W, H          int
 	screen        []byte // w*h*4
 	font          font
 	flipRequested bool
+
+	// Text is what's on screen as chars, zero where nothing's been drawn
+	Text [termRows][termCols]byte
+
+	// Lowercase is the lowercase mod, which shows lowercase as is,
+	// instead of folding it into uppercase like a stock 2513 does
+	Lowercase bool
+
+	// history is the lines that have scrolled off the top, oldest
+	// first, for looking back on. It's the host's, not the Apple-1's,
+	// so it's left out of snapshots.
+	history [][termCols]byte
 }
 
+const (
+	termCols = 40
+	termRows = 24
+
+	// maxScrollback is how many lines of history are kept
+	maxScrollback = 1000
+)
+
 type font struct {
 	w, h   int
 	glyphs map[rune][]byte
 }
 
+// blankGlyph is drawn for chars a font has no glyph for, not even '?'
+var blankGlyph = make([]byte, glyphW*glyphH)
+
 func (t *terminal) newline() {
 	t.X = 0
 	t.Y += t.font.h + 1
@@ -22,6 +50,12 @@ func (t *terminal) newline() {
 		for i := t.W * t.Y * 4; i < len(t.screen); i++ {
 			t.screen[i] = 0
 		}
+		if len(t.history) == maxScrollback {
+			t.history = append(t.history[:0], t.history[1:]...)
+		}
+		t.history = append(t.history, t.Text[0])
+		copy(t.Text[:], t.Text[1:])
+		t.Text[termRows-1] = [termCols]byte{}
 	}
 	t.flipRequested = true
 }
@@ -44,6 +78,7 @@ func (t *terminal) clearScreen() {
 	}
 	t.X = 0
 	t.Y = 0
+	t.Text = [termRows][termCols]byte{}
 	t.flipRequested = true
 }
 
@@ -64,36 +99,118 @@ func (t *terminal) writeChar(char rune) {
 			char = ' '
 		}
 		if char >= 32 {
-			// woz's ascii trick: flip bit 6
-			char ^= 64
-			// woz's ascii trick: delete bit 5
-			char = ((char >> 1) & 0x60) | (char & 0x1f)
-			char, ok := a1KeyMap[char]
-			if !ok {
-				char = '?'
+			char := displayChar(char, t.Lowercase)
+			t.Text[t.Y/(t.font.h+1)][t.X/(t.font.w+1)] = byte(char)
+
+			t.drawGlyph(t.screen, t.X, t.Y, char)
+			t.advanceChar()
+		}
+	}
+	t.flipRequested = true
+}
+
+// drawGlyph draws char into screen with its top left at x, y
+func (t *terminal) drawGlyph(screen []byte, x, y int, char rune) {
+	fontChr, ok := t.font.glyphs[char]
+	if !ok {
+		// loaded fonts can be missing glyphs, so
+		// lowercase falls back on uppercase, then '?'
+		fontChr, ok = t.font.glyphs[unicode.ToUpper(char)]
+	}
+	if !ok {
+		fontChr, ok = t.font.glyphs['?']
+	}
+	if !ok {
+		fontChr = blankGlyph
+	}
+	for i := 0; i < t.font.h; i++ {
+		lineStartInChars := (y+i)*t.W + x
+		line := screen[lineStartInChars*4:]
+		chrLine := fontChr[i*t.font.w : (i+1)*t.font.w]
+		for j := 0; j < t.font.w; j++ {
+			col := byte(0)
+			if chrLine[j] == 1 {
+				col = 0xff
 			}
+			line[j*4+0], line[j*4+1] = col, col
+			line[j*4+2], line[j*4+3] = col, col
+		}
+	}
+}
 
-			fontChr, ok := t.font.glyphs[char]
-			if !ok {
-				fontChr = t.font.glyphs['?']
+// scrolledBack returns the rows the screen would show if it were
+// scrolled back by the given number of lines of history
+func (t *terminal) scrolledBack(back int) [termRows][termCols]byte {
+	if back > len(t.history) {
+		back = len(t.history)
+	}
+	if back <= 0 {
+		return t.Text
+	}
+	rows := [termRows][termCols]byte{}
+	start := len(t.history) - back
+	for i := range rows {
+		if start+i < len(t.history) {
+			rows[i] = t.history[start+i]
+		} else {
+			rows[i] = t.Text[start+i-len(t.history)]
+		}
+	}
+	return rows
+}
+
+// scrollbackFramebuffer draws the screen scrolled back by back lines
+func (t *terminal) scrollbackFramebuffer(back int) []byte {
+	screen := make([]byte, len(t.screen))
+	for y, row := range t.scrolledBack(back) {
+		for x, c := range row {
+			if c != 0 {
+				t.drawGlyph(screen, x*(t.font.w+1), y*(t.font.h+1), rune(c))
 			}
-			for i := 0; i < t.font.h; i++ {
-				lineStartInChars := (t.Y+i)*t.W + t.X
-				line := t.screen[lineStartInChars*4:]
-				chrLine := fontChr[i*t.font.w : (i+1)*t.font.w]
-				for j := 0; j < t.font.w; j++ {
-					col := byte(0)
-					if chrLine[j] == 1 {
-						col = 0xff
-					}
-					line[j*4+0], line[j*4+1] = col, col
-					line[j*4+2], line[j*4+3] = col, col
-				}
+		}
+	}
+	return screen
+}
+
+// text returns the screen as lines of text, without trailing spaces
+func (t *terminal) text() string {
+	return rowsText(t.Text)
+}
+
+func rowsText(rows [termRows][termCols]byte) string {
+	lines := make([]string, termRows)
+	for i, row := range rows {
+		line := []byte{}
+		for _, c := range row {
+			if c == 0 {
+				c = ' '
 			}
-			t.advanceChar()
+			line = append(line, c)
 		}
+		lines[i] = strings.TrimRight(string(line), " ")
 	}
-	t.flipRequested = true
+	return strings.Join(lines, "\n")
+}
+
+// displayChar is what the display shows for a printable char. With
+// the lowercase mod, that's lowercase too, not just what a 2513 has.
+func displayChar(char rune, lowercase bool) rune {
+	if lowercase && char >= '`' && char <= '~' {
+		return char
+	}
+	return a1DisplayChar(char)
+}
+
+// a1DisplayChar is what the display shows for a printable char
+func a1DisplayChar(char rune) rune {
+	// woz's ascii trick: flip bit 6
+	char ^= 64
+	// woz's ascii trick: delete bit 5
+	char = ((char >> 1) & 0x60) | (char & 0x1f)
+	if shown, ok := a1KeyMap[char]; ok {
+		return shown
+	}
+	return '?'
 }
 
 var a1KeyMap = map[rune]rune{
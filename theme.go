@@ -0,0 +1,131 @@
+package a1go
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// Theme is the colors the screen is shown in
+type Theme struct {
+	Name       string
+	Foreground color.RGBA
+	Background color.RGBA
+}
+
+// Themes are the stock monochrome monitor phosphors. The first,
+// white, is how the screen is shown unless another is picked.
+var Themes = []Theme{
+	{"white", color.RGBA{0xff, 0xff, 0xff, 0xff}, color.RGBA{0, 0, 0, 0xff}},
+	{"green", color.RGBA{0x33, 0xff, 0x33, 0xff}, color.RGBA{0x00, 0x0c, 0x00, 0xff}},
+	{"amber", color.RGBA{0xff, 0xb0, 0x00, 0xff}, color.RGBA{0x0c, 0x06, 0x00, 0xff}},
+}
+
+// themeAliases are the phosphor part numbers for the stock themes
+var themeAliases = map[string]string{
+	"p4": "white", "p1": "green", "p3": "amber",
+}
+
+// ParseTheme reads a theme by name (white, green or amber, or p4, p1
+// or p3), or as custom colors: RRGGBB for the foreground on black, or
+// RRGGBB/RRGGBB for the foreground and background.
+func ParseTheme(spec string) (Theme, error) {
+	name := strings.ToLower(spec)
+	if alias, ok := themeAliases[name]; ok {
+		name = alias
+	}
+	for _, theme := range Themes {
+		if theme.Name == name {
+			return theme, nil
+		}
+	}
+	parts := strings.SplitN(spec, "/", 2)
+	theme := Theme{Name: spec, Background: Themes[0].Background}
+	var err error
+	if theme.Foreground, err = parseRGB(parts[0]); err != nil {
+		return theme, fmt.Errorf("bad theme %q, want white, green, amber, RRGGBB or RRGGBB/RRGGBB", spec)
+	}
+	if len(parts) == 2 {
+		if theme.Background, err = parseRGB(parts[1]); err != nil {
+			return theme, fmt.Errorf("bad theme background %q, want RRGGBB", parts[1])
+		}
+	}
+	return theme, nil
+}
+
+func parseRGB(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("bad color %q", s)
+	}
+	rgb, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("bad color %q", s)
+	}
+	return color.RGBA{byte(rgb >> 16), byte(rgb >> 8), byte(rgb), 0xff}, nil
+}
+
+// NextTheme is the stock theme after t, for cycling through them
+func NextTheme(t Theme) Theme {
+	for i, theme := range Themes {
+		if theme == t {
+			return Themes[(i+1)%len(Themes)]
+		}
+	}
+	return Themes[0]
+}
+
+// shade is the color for a pixel lit 0-0xff
+func (t Theme) shade(lit byte) color.RGBA {
+	mix := func(bg, fg byte) byte {
+		return byte((int(bg)*(0xff-int(lit)) + int(fg)*int(lit)) / 0xff)
+	}
+	return color.RGBA{
+		R: mix(t.Background.R, t.Foreground.R),
+		G: mix(t.Background.G, t.Foreground.G),
+		B: mix(t.Background.B, t.Foreground.B),
+		A: 0xff,
+	}
+}
+
+// tint colors screen, which is drawn white on black, into dst
+func (t Theme) tint(dst, screen []byte) {
+	for i := 0; i < len(screen); i += 4 {
+		c := t.shade(screen[i])
+		dst[i], dst[i+1], dst[i+2], dst[i+3] = c.R, c.G, c.B, c.A
+	}
+}
+
+func (emu *emuState) currentTheme() Theme {
+	if emu.theme == (Theme{}) {
+		return Themes[0]
+	}
+	return emu.theme
+}
+
+// isStock says if t is white on black, which is how the terminal
+// draws, so there's nothing to tint
+func (t Theme) isStock() bool {
+	return t.Foreground == Themes[0].Foreground && t.Background == Themes[0].Background
+}
+
+func (emu *emuState) themedFramebuffer() []byte {
+	theme := emu.currentTheme()
+	if theme.isStock() {
+		return emu.Terminal.screen
+	}
+	if len(emu.themedScreen) != len(emu.Terminal.screen) {
+		emu.themedScreen = make([]byte, len(emu.Terminal.screen))
+	}
+	theme.tint(emu.themedScreen, emu.Terminal.screen)
+	return emu.themedScreen
+}
+
+func (emu *emuState) themedScrollbackFramebuffer(back int) []byte {
+	screen := emu.Terminal.scrollbackFramebuffer(back)
+	if theme := emu.currentTheme(); !theme.isStock() {
+		theme.tint(screen, screen)
+	}
+	return screen
+}
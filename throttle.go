@@ -0,0 +1,67 @@
+package a1go
+
+import "time"
+
+// maxThrottleLag is how far behind the wall clock emulation can fall
+// before the Throttle gives up on catching up and starts over from now.
+const maxThrottleLag = 250 * time.Millisecond
+
+// Throttle paces emulation against the wall clock, independent of
+// any display timing. Time is measured from a reference point, so
+// rounding in any one wait never accumulates into drift.
+type Throttle struct {
+	clockHz    float64
+	multiplier float64
+
+	refTime   time.Time
+	refCycles uint64
+}
+
+// NewThrottle creates a Throttle for a cpu running at clockHz,
+// starting at 100% speed.
+func NewThrottle(clockHz int) *Throttle {
+	return &Throttle{
+		clockHz:    float64(clockHz),
+		multiplier: 1,
+		refTime:    time.Now(),
+	}
+}
+
+// Reset makes cycles the new reference point, e.g. after loading
+// a snapshot or coming out of turbo.
+func (t *Throttle) Reset(cycles uint64) {
+	t.refTime = time.Now()
+	t.refCycles = cycles
+}
+
+// SetMultiplier changes the target speed, e.g. 0.25 for 25%, 10 for 10x.
+func (t *Throttle) SetMultiplier(m float64, cycles uint64) {
+	t.multiplier = m
+	t.Reset(cycles)
+}
+
+// Multiplier returns the current target speed
+func (t *Throttle) Multiplier() float64 {
+	return t.multiplier
+}
+
+// TimeUntil returns how long to wait for the wall clock to catch up
+// with the emulated cycle count. If emulation has fallen too far
+// behind, it resyncs and returns zero.
+func (t *Throttle) TimeUntil(cycles uint64) time.Duration {
+	emuSecs := float64(cycles-t.refCycles) / (t.clockHz * t.multiplier)
+	target := t.refTime.Add(time.Duration(emuSecs * float64(time.Second)))
+	wait := time.Until(target)
+	if wait < -maxThrottleLag {
+		t.Reset(cycles)
+		return 0
+	}
+	return wait
+}
+
+// Wait sleeps until the wall clock catches up with the emulated cycle count
+func (t *Throttle) Wait(cycles uint64) {
+	if wait := t.TimeUntil(cycles); wait > 0 {
+		time.Sleep(wait)
+	}
+}